@@ -0,0 +1,429 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultAccessTokenTTL is how long an access token is accepted before it must be refreshed.
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultSessionTTL is how long a login lasts without being refreshed.
+	DefaultSessionTTL = 7 * 24 * time.Hour
+	// DefaultMaxFailedLogins is the number of wrong passwords in a row that locks an account.
+	DefaultMaxFailedLogins = 5
+	// DefaultLockoutDuration is how long a locked account refuses logins.
+	DefaultLockoutDuration = 15 * time.Minute
+
+	minPasswordLength = 8
+	// maxPasswordLength bounds the work of hashing a password sent by a client.
+	maxPasswordLength = 256
+)
+
+var (
+	errUnauthenticated = errors.New("authentication required")
+	errBadCredentials  = errors.New("invalid email or password")
+	errAccountLocked   = errors.New("too many failed logins: the account is locked")
+	errAccountBanned   = errors.New("the account is banned")
+)
+
+// AuthConfig configures user accounts and their sessions. Zero fields fall back to the defaults.
+type AuthConfig struct {
+	// AccessTokenTTL is how long an access token is accepted. It bounds how long a token
+	// leaked from a client stays useful, and every refresh starts it anew.
+	AccessTokenTTL time.Duration
+	// SessionTTL is how long a refresh token is accepted, and so how long a login lasts
+	// without being refreshed.
+	SessionTTL time.Duration
+	// MaxFailedLogins is the number of wrong passwords in a row after which an account is
+	// locked for LockoutDuration.
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+
+	// argon2 is the cost of new password hashes; tests lower it.
+	argon2 argon2Params
+}
+
+// withDefaults returns c with its zero fields set to the defaults.
+func (c AuthConfig) withDefaults() AuthConfig {
+	if c.AccessTokenTTL <= 0 {
+		c.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if c.SessionTTL <= 0 {
+		c.SessionTTL = DefaultSessionTTL
+	}
+	if c.MaxFailedLogins <= 0 {
+		c.MaxFailedLogins = DefaultMaxFailedLogins
+	}
+	if c.LockoutDuration <= 0 {
+		c.LockoutDuration = DefaultLockoutDuration
+	}
+	if c.argon2 == (argon2Params{}) {
+		c.argon2 = defaultArgon2Params
+	}
+	return c
+}
+
+// authErrorStatus maps an error of the account handlers to an HTTP status code.
+func authErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnauthenticated), errors.Is(err, errBadCredentials),
+		errors.Is(err, errSessionNotFound), errors.Is(err, errRefreshTokenReused), errors.Is(err, errUserNotFound),
+		errors.Is(err, errInvalidAPIKey):
+		return http.StatusUnauthorized
+	case errors.Is(err, errUserExists):
+		return http.StatusConflict
+	case errors.Is(err, errAccountLocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errAccountBanned), errors.Is(err, errPermissionDenied), errors.Is(err, errInsufficientScope):
+		return http.StatusForbidden
+	default:
+		return repositoryErrorStatus(err)
+	}
+}
+
+// writeAuthError reports err, asking for a bearer token when the request was not authenticated.
+func writeAuthError(w http.ResponseWriter, err error) {
+	code := authErrorStatus(err)
+	switch {
+	case errors.Is(err, errSessionNotFound):
+		// tells clients to refresh the token rather than to ask the user to log in (RFC 6750)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mercari", error="invalid_token"`)
+	case code == http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mercari"`)
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// writeJSON writes v as the JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+}
+
+// newSessionToken returns a random token to give to the client, and the hash it is stored as.
+// Tokens are opaque rather than signed like JWTs, so that a session ends as soon as its row
+// is deleted.
+func newSessionToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hash a token is stored as. Tokens are random, so a plain SHA-256
+// is enough: unlike passwords, they cannot be guessed from a dictionary.
+func hashToken(token string) string {
+	return sha256Hex([]byte(token))
+}
+
+// bearerToken returns the token of the Authorization header of r, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticate returns the user of r, authenticated by the API key of its X-API-Key header,
+// which it returns too, or else logged in with its bearer token. It is given to authMiddleware.
+func (s *Handlers) authenticate(r *http.Request) (*User, *APIKey, error) {
+	if r.Header.Get(apiKeyHeader) != "" {
+		return s.authenticateAPIKey(r)
+	}
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, nil, errUnauthenticated
+	}
+	session, err := s.userRepo.SelectSession(r.Context(), hashToken(token), time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.userRepo.Select(r.Context(), session.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.BannedAt.IsZero() {
+		return nil, nil, errAccountBanned
+	}
+	return user, nil, nil
+}
+
+// normalizeEmail returns email trimmed and lower-cased, or an error if it is not an address.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", fmt.Errorf("invalid email address: %q", email)
+	}
+	return email, nil
+}
+
+// RegisterUserRequest creates an account.
+type RegisterUserRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// validate checks the request and normalizes its email and name.
+func (req *RegisterUserRequest) validate() error {
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return err
+	}
+	req.Email = email
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(req.Password) < minPasswordLength || len(req.Password) > maxPasswordLength {
+		return fmt.Errorf("password must be %d to %d bytes long", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+// RegisterUser is a handler to create an account for POST /users .
+func (s *Handlers) RegisterUser(w http.ResponseWriter, r *http.Request) {
+	var req RegisterUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := hashPassword(req.Password, s.auth.withDefaults().argon2)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := &User{Email: req.Email, Name: req.Name, PasswordHash: hash}
+	if err := s.userRepo.Insert(r.Context(), user); err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+	slog.Info("user registered", "user_id", user.ID)
+	writeJSON(w, http.StatusCreated, map[string]any{"user": user})
+}
+
+// LoginRequest logs in with the email and password of an account.
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// SessionTokens holds the access token to send as "Authorization: Bearer <token>" until it
+// expires, and the refresh token to trade for new tokens then, with POST /sessions/refresh .
+type SessionTokens struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// LoginResponse holds the tokens of a new session and its user.
+type LoginResponse struct {
+	SessionTokens
+	User *User `json:"user"`
+}
+
+// newSessionTokens returns new tokens for a session, and the session with their hashes.
+func (c AuthConfig) newSessionTokens(userID int, now time.Time) (*SessionTokens, *Session, error) {
+	token, hash, err := newSessionToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, refreshHash, err := newSessionToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	now = now.UTC().Truncate(time.Second)
+	session := &Session{
+		UserID:           userID,
+		TokenHash:        hash,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(c.AccessTokenTTL),
+		RefreshTokenHash: refreshHash,
+		RefreshExpiresAt: now.Add(c.SessionTTL),
+	}
+	tokens := &SessionTokens{Token: token, ExpiresAt: session.ExpiresAt, RefreshToken: refreshToken, RefreshExpiresAt: session.RefreshExpiresAt}
+	return tokens, session, nil
+}
+
+// Login is a handler to log in for POST /sessions . After MaxFailedLogins wrong passwords
+// in a row, the account is locked for LockoutDuration, even to the right password.
+func (s *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cfg := s.auth.withDefaults()
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) > maxPasswordLength {
+		writeAuthError(w, errBadCredentials)
+		return
+	}
+
+	user, err := s.userRepo.SelectByEmail(ctx, strings.ToLower(strings.TrimSpace(req.Email)))
+	if errors.Is(err, errUserNotFound) {
+		// hash anyway, so that the response time does not tell which emails have an account
+		hashPassword(req.Password, cfg.argon2)
+		writeAuthError(w, errBadCredentials)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	now := time.Now()
+	if now.Before(user.LockedUntil) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(user.LockedUntil.Sub(now).Seconds())+1))
+		writeAuthError(w, errAccountLocked)
+		return
+	}
+
+	ok, err := verifyPassword(req.Password, user.PasswordHash)
+	if err != nil {
+		slog.Error("failed to verify password", "user_id", user.ID, "error", err)
+		http.Error(w, "failed to verify password", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		s.recordFailedLogin(w, r, user, cfg)
+		return
+	}
+	// only told to whoever knows the password
+	if !user.BannedAt.IsZero() {
+		writeAuthError(w, errAccountBanned)
+		return
+	}
+
+	if user.FailedLogins > 0 || !user.LockedUntil.IsZero() {
+		if err := s.userRepo.ResetFailedLogins(ctx, user.ID); err != nil {
+			http.Error(w, err.Error(), repositoryErrorStatus(err))
+			return
+		}
+	}
+	tokens, session, err := cfg.newSessionTokens(user.ID, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.userRepo.InsertSession(ctx, session); err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	slog.Info("user logged in", "user_id", user.ID)
+	writeJSON(w, http.StatusCreated, LoginResponse{SessionTokens: *tokens, User: user})
+}
+
+// recordFailedLogin counts a wrong password given for user, locks the account once there
+// were too many, and reports the failure.
+func (s *Handlers) recordFailedLogin(w http.ResponseWriter, r *http.Request, user *User, cfg AuthConfig) {
+	failures, err := s.userRepo.RecordFailedLogin(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	if failures >= cfg.MaxFailedLogins {
+		if err := s.userRepo.Lock(r.Context(), user.ID, time.Now().Add(cfg.LockoutDuration)); err != nil {
+			http.Error(w, err.Error(), repositoryErrorStatus(err))
+			return
+		}
+		slog.Warn("account locked after failed logins", "user_id", user.ID, "failures", failures)
+	}
+	writeAuthError(w, errBadCredentials)
+}
+
+// RefreshSessionRequest trades a refresh token for new tokens.
+type RefreshSessionRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshSession is a handler to trade a refresh token for new tokens for POST /sessions/refresh .
+// Each refresh token is accepted once: replaying one that was already traded revokes the session.
+func (s *Handlers) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	var req RefreshSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	tokens, session, err := s.auth.withDefaults().newSessionTokens(0, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.userRepo.RotateSession(r.Context(), hashToken(req.RefreshToken), session, now); err != nil {
+		if errors.Is(err, errRefreshTokenReused) {
+			slog.Warn("refresh token reused: session revoked")
+		}
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// Logout is a handler to end the session of the bearer token for DELETE /sessions .
+func (s *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		writeAuthError(w, errUnauthenticated)
+		return
+	}
+	if err := s.userRepo.DeleteSession(r.Context(), hashToken(token)); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSessions is a handler to end every session of the logged in user, on all their
+// devices, for DELETE /me/sessions .
+func (s *Handlers) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeAuthError(w, errUnauthenticated)
+		return
+	}
+	if err := s.userRepo.DeleteUserSessions(r.Context(), user.ID); err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	slog.Info("sessions revoked", "user_id", user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMe is a handler to return the logged in user for GET /me .
+func (s *Handlers) GetMe(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeAuthError(w, errUnauthenticated)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": user})
+}
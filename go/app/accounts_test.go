@@ -0,0 +1,390 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+// testAuthConfig hashes passwords with testArgon2Params.
+var testAuthConfig = AuthConfig{MaxFailedLogins: 3, argon2: testArgon2Params}
+
+// testUser returns a user whose password is "correct horse".
+func testUser(t *testing.T) *User {
+	t.Helper()
+	hash, err := hashPassword("correct horse", testArgon2Params)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	return &User{ID: 1, Email: "alice@example.com", Name: "Alice", PasswordHash: hash}
+}
+
+func TestRegisterUser(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body      string
+		insertErr error
+		wantCode  int
+	}{
+		"ok: registered": {
+			body:     `{"email": " Alice@Example.com ", "name": "Alice", "password": "correct horse"}`,
+			wantCode: http.StatusCreated,
+		},
+		"ng: email taken": {
+			body:      `{"email": "alice@example.com", "name": "Alice", "password": "correct horse"}`,
+			insertErr: errUserExists,
+			wantCode:  http.StatusConflict,
+		},
+		"ng: invalid email": {
+			body:     `{"email": "Alice <alice@example.com>", "name": "Alice", "password": "correct horse"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: short password": {
+			body:     `{"email": "alice@example.com", "name": "Alice", "password": "short"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: missing name": {
+			body:     `{"email": "alice@example.com", "password": "correct horse"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		"ng: malformed body": {
+			body:     `{"email":`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ any, user *User) error {
+				if user.Email != "alice@example.com" {
+					t.Errorf("expected a normalized email, got %q", user.Email)
+				}
+				if ok, err := verifyPassword("correct horse", user.PasswordHash); !ok || err != nil {
+					t.Errorf("expected the password to be stored hashed, got %q", user.PasswordHash)
+				}
+				user.ID = 1
+				return tt.insertErr
+			}).MaxTimes(1)
+			h := &Handlers{userRepo: mockUR, auth: testAuthConfig}
+			req := httptest.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			res := httptest.NewRecorder()
+
+			h.RegisterUser(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if res.Code == http.StatusCreated && strings.Contains(res.Body.String(), "argon2id") {
+				t.Errorf("expected the password hash to stay out of the response, got %s", res.Body.String())
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code       int
+		retryAfter bool
+	}
+	cases := map[string]struct {
+		password     string
+		unknown      bool
+		failedLogins int
+		lockedUntil  time.Time
+		banned       bool
+		// failures is what RecordFailedLogin returns, when a wrong password is given.
+		failures int
+		wantLock bool
+		wants
+	}{
+		"ok: right password": {
+			password: "correct horse",
+			wants:    wants{code: http.StatusCreated},
+		},
+		"ok: right password after failures": {
+			password:     "correct horse",
+			failedLogins: 2,
+			wants:        wants{code: http.StatusCreated},
+		},
+		"ok: right password after lockout ended": {
+			password:    "correct horse",
+			lockedUntil: time.Now().Add(-time.Minute),
+			wants:       wants{code: http.StatusCreated},
+		},
+		"ng: wrong password": {
+			password: "battery staple",
+			failures: 1,
+			wants:    wants{code: http.StatusUnauthorized},
+		},
+		"ng: wrong password locks the account": {
+			password: "battery staple",
+			failures: 3,
+			wantLock: true,
+			wants:    wants{code: http.StatusUnauthorized},
+		},
+		"ng: locked account": {
+			password:    "correct horse",
+			lockedUntil: time.Now().Add(time.Minute),
+			wants:       wants{code: http.StatusTooManyRequests, retryAfter: true},
+		},
+		"ng: unknown email": {
+			password: "correct horse",
+			unknown:  true,
+			wants:    wants{code: http.StatusUnauthorized},
+		},
+		"ng: banned account": {
+			password: "correct horse",
+			banned:   true,
+			wants:    wants{code: http.StatusForbidden},
+		},
+		"ng: banned account, wrong password": {
+			password: "battery staple",
+			banned:   true,
+			failures: 1,
+			wants:    wants{code: http.StatusUnauthorized},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			user := testUser(t)
+			user.FailedLogins = tt.failedLogins
+			user.LockedUntil = tt.lockedUntil
+			if tt.banned {
+				user.BannedAt = time.Now().Add(-time.Hour)
+			}
+			mockUR := NewMockUserRepository(ctrl)
+			if tt.unknown {
+				mockUR.EXPECT().SelectByEmail(gomock.Any(), "alice@example.com").Return(nil, errUserNotFound)
+			} else {
+				mockUR.EXPECT().SelectByEmail(gomock.Any(), "alice@example.com").Return(user, nil)
+			}
+			mockUR.EXPECT().RecordFailedLogin(gomock.Any(), user.ID).Return(tt.failures, nil).Times(min(tt.failures, 1))
+			if tt.wantLock {
+				mockUR.EXPECT().Lock(gomock.Any(), user.ID, gomock.Any()).Return(nil)
+			}
+			if tt.code == http.StatusCreated {
+				if tt.failedLogins > 0 || !tt.lockedUntil.IsZero() {
+					mockUR.EXPECT().ResetFailedLogins(gomock.Any(), user.ID).Return(nil)
+				}
+				mockUR.EXPECT().InsertSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ any, s *Session) error {
+					if s.UserID != user.ID || !s.ExpiresAt.After(time.Now()) || s.RefreshTokenHash == "" || !s.RefreshExpiresAt.After(s.ExpiresAt) {
+						t.Errorf("unexpected session %+v", s)
+					}
+					return nil
+				})
+			}
+			h := &Handlers{userRepo: mockUR, auth: testAuthConfig}
+			body := `{"email": "Alice@example.com", "password": "` + tt.password + `"}`
+			req := httptest.NewRequest("POST", "/sessions", strings.NewReader(body))
+			res := httptest.NewRecorder()
+
+			h.Login(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if got := res.Header().Get("Retry-After") != ""; got != tt.wants.retryAfter {
+				t.Errorf("expected Retry-After %v, got %q", tt.wants.retryAfter, res.Header().Get("Retry-After"))
+			}
+			if res.Code == http.StatusUnauthorized && res.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected a WWW-Authenticate header")
+			}
+			if res.Code == http.StatusCreated {
+				var got LoginResponse
+				if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if got.Token == "" || got.RefreshToken == "" || got.User == nil || got.User.ID != user.ID {
+					t.Errorf("expected a token for user %d, got %+v", user.ID, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMe(t *testing.T) {
+	t.Parallel()
+
+	user := &User{ID: 1, Email: "alice@example.com", Name: "Alice"}
+	cases := map[string]struct {
+		user     *User
+		wantCode int
+	}{
+		"ok: logged in": {user: user, wantCode: http.StatusOK},
+		"ng: anonymous": {wantCode: http.StatusUnauthorized},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{}
+			req := httptest.NewRequest("GET", "/me", nil)
+			if tt.user != nil {
+				req = req.WithContext(withUser(req.Context(), tt.user))
+			}
+			res := httptest.NewRecorder()
+
+			h.GetMe(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if tt.user != nil && !strings.Contains(res.Body.String(), tt.user.Email) {
+				t.Errorf("expected the user in the response, got %s", res.Body.String())
+			}
+		})
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body      string
+		rotateErr error
+		wantCode  int
+		// wantInvalidToken is whether clients are told the token is invalid, rather than missing.
+		wantInvalidToken bool
+	}{
+		"ok: refreshed": {
+			body:     `{"refresh_token": "refresh"}`,
+			wantCode: http.StatusOK,
+		},
+		"ng: expired refresh token": {
+			body:             `{"refresh_token": "refresh"}`,
+			rotateErr:        errSessionNotFound,
+			wantCode:         http.StatusUnauthorized,
+			wantInvalidToken: true,
+		},
+		"ng: reused refresh token": {
+			body:      `{"refresh_token": "refresh"}`,
+			rotateErr: errRefreshTokenReused,
+			wantCode:  http.StatusUnauthorized,
+		},
+		"ng: missing refresh token": {
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var rotated *Session
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().RotateSession(gomock.Any(), hashToken("refresh"), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ any, _ string, next *Session, _ time.Time) error {
+					rotated = next
+					return tt.rotateErr
+				}).MaxTimes(1)
+			h := &Handlers{userRepo: mockUR}
+			req := httptest.NewRequest("POST", "/sessions/refresh", strings.NewReader(tt.body))
+			res := httptest.NewRecorder()
+
+			h.RefreshSession(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if got := strings.Contains(res.Header().Get("WWW-Authenticate"), "invalid_token"); got != tt.wantInvalidToken {
+				t.Errorf("expected invalid_token %v, got WWW-Authenticate %q", tt.wantInvalidToken, res.Header().Get("WWW-Authenticate"))
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			var got SessionTokens
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.RefreshToken == "refresh" || hashToken(got.Token) != rotated.TokenHash || hashToken(got.RefreshToken) != rotated.RefreshTokenHash {
+				t.Errorf("expected new tokens matching the rotated session, got %+v", got)
+			}
+			if !got.ExpiresAt.Before(got.RefreshExpiresAt) {
+				t.Errorf("expected the access token to expire before the refresh token, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	user := &User{ID: 1, Email: "alice@example.com", Name: "Alice"}
+	mockUR := NewMockUserRepository(ctrl)
+	mockUR.EXPECT().DeleteUserSessions(gomock.Any(), user.ID).Return(nil)
+	h := &Handlers{userRepo: mockUR}
+	req := httptest.NewRequest("DELETE", "/me/sessions", nil)
+	res := httptest.NewRecorder()
+
+	h.RevokeSessions(res, req.WithContext(withUser(req.Context(), user)))
+
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusNoContent, res.Code, res.Body.String())
+	}
+}
+
+func TestLogout(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		authorization string
+		deleteErr     error
+		wantCode      int
+	}{
+		"ok: logged out":      {authorization: "Bearer token", wantCode: http.StatusNoContent},
+		"ng: no token":        {wantCode: http.StatusUnauthorized},
+		"ng: unknown session": {authorization: "Bearer token", deleteErr: errSessionNotFound, wantCode: http.StatusUnauthorized},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().DeleteSession(gomock.Any(), hashToken("token")).Return(tt.deleteErr).AnyTimes()
+			h := &Handlers{userRepo: mockUR}
+			req := httptest.NewRequest("DELETE", "/sessions", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := httptest.NewRecorder()
+
+			h.Logout(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// ErrNotFound is returned by Admin when the requested item does not exist.
+var ErrNotFound = errItemNotFound
+
+// ErrCategoryExists is returned by Admin when adding a category whose name is taken.
+var ErrCategoryExists = errCategoryExists
+
+// ErrAdminExists is returned by Admin.CreateAdmin once there is an admin, who creates the
+// other ones through the API.
+var ErrAdminExists = errors.New("an admin already exists")
+
+// ErrUserExists is returned by Admin when creating a user whose email is taken.
+var ErrUserExists = errUserExists
+
+// ErrRemoteImageStore is returned by the Admin operations that work on the image directory
+// when the images are kept in another store, such as S3.
+var ErrRemoteImageStore = errors.New("images are stored remotely, not in the image directory")
+
+// seedCategories and seedItems are the sample data inserted by Admin.Seed.
+var (
+	seedCategories = []string{"fashion", "phone", "furniture", "books"}
+	seedItems      = []struct{ name, category string }{
+		{"jacket", "fashion"},
+		{"used iPhone 16e", "phone"},
+		{"wooden chair", "furniture"},
+		{"Go programming book", "books"},
+	}
+)
+
+// Admin provides the maintenance operations behind the admin CLI.
+// Unlike the server, it does not migrate the database when opened.
+type Admin struct {
+	Items      ItemRepository
+	Categories CategoryRepository
+	Images     ImageRepository
+	Users      UserRepository
+	// ImageStore receives the images of imported items. It defaults to the image directory.
+	// The other image maintenance operations work on the directory of a file store and fail
+	// with ErrRemoteImageStore for any other store.
+	ImageStore ImageStore
+
+	db            *sql.DB
+	dialect       dialect
+	imgDirPath    string
+	migrationsDir string
+}
+
+// NewAdmin opens the database selected by cfg for maintenance of it and of the images in imgDirPath.
+func NewAdmin(cfg DatabaseConfig, imgDirPath string) (*Admin, error) {
+	db, d, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{
+		Items:         &itemRepository{db: db, dialect: d, queryTimeout: cfg.QueryTimeout},
+		Categories:    &categoryRepository{db: db, dialect: d, queryTimeout: cfg.QueryTimeout},
+		Images:        &imageRepository{db: db, dialect: d, queryTimeout: cfg.QueryTimeout},
+		Users:         &userRepository{db: db, dialect: d, queryTimeout: cfg.QueryTimeout},
+		ImageStore:    NewFileImageStore(imgDirPath),
+		db:            db,
+		dialect:       d,
+		imgDirPath:    imgDirPath,
+		migrationsDir: migrationsDir,
+	}, nil
+}
+
+// Close closes the database.
+func (a *Admin) Close() error {
+	return a.db.Close()
+}
+
+// MigrateUp applies every pending migration and returns the applied ones.
+func (a *Admin) MigrateUp(ctx context.Context) ([]Migration, error) {
+	return migrateUp(ctx, a.db, a.dialect, a.migrationsDir)
+}
+
+// MigrateDown reverts the last steps applied migrations and returns the reverted ones.
+func (a *Admin) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	return migrateDown(ctx, a.db, a.dialect, a.migrationsDir, steps)
+}
+
+// MigrationStatus lists every known migration with whether it has been applied.
+func (a *Admin) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return migrationStatus(ctx, a.db, a.dialect, a.migrationsDir)
+}
+
+// Seed inserts the sample categories that are missing, then the sample items
+// if there are no items yet. It is safe to run more than once.
+// It returns the number of categories and items inserted.
+func (a *Admin) Seed(ctx context.Context) (int, int, error) {
+	existing, err := a.Categories.List(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	ids := make(map[string]int, len(existing))
+	for _, c := range existing {
+		ids[c.Name] = c.ID
+	}
+
+	categories := 0
+	for _, name := range seedCategories {
+		if _, ok := ids[name]; ok {
+			continue
+		}
+		c := &Category{Name: name}
+		if err := a.Categories.Insert(ctx, c); err != nil {
+			return categories, 0, fmt.Errorf("failed to seed category %s: %w", name, err)
+		}
+		ids[name] = c.ID
+		categories++
+	}
+
+	items, err := a.Items.LoadItems(ctx)
+	if err != nil {
+		return categories, 0, err
+	}
+	if len(items) > 0 {
+		return categories, 0, nil
+	}
+	for i, seed := range seedItems {
+		item := &Item{Name: seed.name, Category: strconv.Itoa(ids[seed.category]), ImageFileName: defaultImageName}
+		if err := a.Items.Insert(ctx, item); err != nil {
+			return categories, i, fmt.Errorf("failed to seed item %s: %w", seed.name, err)
+		}
+	}
+	return categories, len(seedItems), nil
+}
+
+// CreateAdmin creates the first admin account, with the same validation as POST /users,
+// and returns it. It returns ErrAdminExists if there is already an admin.
+func (a *Admin) CreateAdmin(ctx context.Context, email, name, password string) (*User, error) {
+	req := RegisterUserRequest{Email: email, Name: name, Password: password}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	admins, err := a.Users.CountByRole(ctx, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if admins > 0 {
+		return nil, ErrAdminExists
+	}
+
+	hash, err := hashPassword(req.Password, defaultArgon2Params)
+	if err != nil {
+		return nil, err
+	}
+	user := &User{Email: req.Email, Name: req.Name, Role: RoleAdmin, PasswordHash: hash}
+	if err := a.Users.Insert(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ImportItems imports the listings read from r in the given format (ImportFormatCSV or
+// ImportFormatJSONL), applying the same validation as POST /items/import.
+// images holds the files referenced by path and may be nil.
+func (a *Admin) ImportItems(ctx context.Context, r io.Reader, format string, images *zip.Reader) (*ImportReport, error) {
+	rows, err := readImportRows(r, format)
+	if err != nil {
+		return nil, err
+	}
+	h := a.handlers()
+	return newItemImporter(h.itemRepo, h.categoryRepo, h.storeImages, images, h.uploads).Import(ctx, rows)
+}
+
+// handlers returns Handlers sharing the admin's repositories and image store,
+// so that the CLI stores images exactly like the server does.
+func (a *Admin) handlers() *Handlers {
+	return &Handlers{images: a.ImageStore, itemRepo: a.Items, categoryRepo: a.Categories, imageRepo: a.Images}
+}
+
+// CollectImageGarbage deletes the images that no item references, except those modified
+// within opts.GracePeriod, and reports what it removed. With opts.DryRun, it only reports
+// what would be removed.
+func (a *Admin) CollectImageGarbage(ctx context.Context, opts ImageGCOptions) (*ImageGCReport, error) {
+	dir, err := a.imageDir()
+	if err != nil {
+		return &ImageGCReport{DryRun: opts.DryRun}, err
+	}
+	return collectImageGarbage(ctx, a.db, dir, opts)
+}
+
+// VerifyImages reports referenced images that are missing or whose content no longer matches their hash.
+func (a *Admin) VerifyImages(ctx context.Context) ([]ImageProblem, error) {
+	dir, err := a.imageDir()
+	if err != nil {
+		return nil, err
+	}
+	return verifyImages(ctx, a.db, dir)
+}
+
+// imageDir returns the directory of the image store, which must exist.
+func (a *Admin) imageDir() (string, error) {
+	files, ok := a.ImageStore.(*fileImageStore)
+	if !ok {
+		return "", ErrRemoteImageStore
+	}
+	if _, err := os.Stat(files.dir); err != nil {
+		return "", fmt.Errorf("failed to open image directory %s: %w", files.dir, err)
+	}
+	return files.dir, nil
+}
@@ -0,0 +1,373 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newTestAdmin returns an Admin on a fresh SQLite database and image directory.
+// Migrations are not applied.
+func newTestAdmin(t *testing.T) *Admin {
+	t.Helper()
+
+	a, err := NewAdmin(DatabaseConfig{DSN: sqliteTestDSN(t)}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create admin: %v", err)
+	}
+	a.migrationsDir = testMigrationsDir
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmin(t)
+
+	migrations, err := loadMigrations(testMigrationsDir, a.dialect)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatalf("expected at least one migration")
+	}
+
+	applied, err := a.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("expected %d migrations to be applied, got %d", len(migrations), len(applied))
+	}
+
+	// running again is a no-op
+	applied, err = a.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected no migration to be applied twice, got %d", len(applied))
+	}
+
+	statuses, err := a.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("failed to get migration status: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied || s.AppliedAt == "" {
+			t.Errorf("expected migration %d_%s to be applied", s.Version, s.Name)
+		}
+	}
+
+	reverted, err := a.MigrateDown(ctx, len(migrations))
+	if err != nil {
+		t.Fatalf("failed to migrate down: %v", err)
+	}
+	if len(reverted) != len(migrations) || reverted[0].Version != migrations[len(migrations)-1].Version {
+		t.Errorf("expected every migration to be reverted newest first, got %+v", reverted)
+	}
+	if _, err := a.db.Exec(`SELECT 1 FROM items`); err == nil {
+		t.Errorf("expected items table to be dropped")
+	}
+}
+
+func TestAdminSeed(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmin(t)
+	if _, err := a.MigrateUp(ctx); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	categories, items, err := a.Seed(ctx)
+	if err != nil {
+		t.Fatalf("failed to seed: %v", err)
+	}
+	if categories != len(seedCategories) || items != len(seedItems) {
+		t.Errorf("expected %d categories and %d items, got %d and %d", len(seedCategories), len(seedItems), categories, items)
+	}
+
+	categories, items, err = a.Seed(ctx)
+	if err != nil {
+		t.Fatalf("failed to seed again: %v", err)
+	}
+	if categories != 0 || items != 0 {
+		t.Errorf("expected seeding twice to insert nothing, got %d categories and %d items", categories, items)
+	}
+}
+
+func TestAdminCreateAdmin(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmin(t)
+	if _, err := a.MigrateUp(ctx); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	if _, err := a.CreateAdmin(ctx, "not an email", "Admin", "correct horse"); err == nil {
+		t.Errorf("expected an invalid email to be refused")
+	}
+	user, err := a.CreateAdmin(ctx, " Admin@Example.com ", "Admin", "correct horse")
+	if err != nil {
+		t.Fatalf("failed to create admin: %v", err)
+	}
+	if user.Role != RoleAdmin || user.Email != "admin@example.com" {
+		t.Errorf("expected an admin with a normalized email, got %+v", user)
+	}
+	if ok, err := verifyPassword("correct horse", user.PasswordHash); err != nil || !ok {
+		t.Errorf("expected the password to be hashed, got %v, %v", ok, err)
+	}
+
+	if _, err := a.CreateAdmin(ctx, "other@example.com", "Other", "correct horse"); !errors.Is(err, ErrAdminExists) {
+		t.Errorf("expected ErrAdminExists, got %v", err)
+	}
+}
+
+func TestAdminImages(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmin(t)
+	if _, err := a.MigrateUp(ctx); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	c := &Category{Name: "fashion"}
+	if err := a.Categories.Insert(ctx, c); err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+
+	writeImage := func(name string, content []byte) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(a.imgDirPath, name), content, 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+	hashedName := func(content []byte) string {
+		return fmt.Sprintf("%x.jpg", sha256.Sum256(content))
+	}
+
+	good := []byte("good image")
+	damaged := []byte("damaged image")
+	orphan := []byte("orphan image")
+	writeImage(hashedName(good), good)
+	writeImage(hashedName(damaged), []byte("bit rot"))
+	writeImage(hashedName(orphan), orphan)
+	writeImage(defaultImageName, []byte("placeholder"))
+	variantOf := func(content []byte) string {
+		return filepath.Join(variantDirName, strings.TrimSuffix(hashedName(content), ".jpg")+"_w150.jpg")
+	}
+	if err := os.Mkdir(filepath.Join(a.imgDirPath, variantDirName), 0755); err != nil {
+		t.Fatalf("failed to create variant directory: %v", err)
+	}
+	writeImage(variantOf(good), []byte("good thumbnail"))
+	writeImage(variantOf(orphan), []byte("orphan thumbnail"))
+
+	for _, name := range []string{hashedName(good), hashedName(damaged), "missing.jpg"} {
+		item := &Item{Name: name, Category: strconv.Itoa(c.ID), ImageFileName: name}
+		if err := a.Items.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	t.Run("verify", func(t *testing.T) {
+		problems, err := a.VerifyImages(ctx)
+		if err != nil {
+			t.Fatalf("failed to verify images: %v", err)
+		}
+		got := map[string]bool{}
+		for _, p := range problems {
+			got[p.Name] = true
+		}
+		want := map[string]bool{hashedName(damaged): true, "missing.jpg": true}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected problems (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("gc", func(t *testing.T) {
+		// the grace period spares every file, which were all just written
+		report, err := a.CollectImageGarbage(ctx, ImageGCOptions{GracePeriod: time.Hour})
+		if err != nil {
+			t.Fatalf("failed to collect garbage: %v", err)
+		}
+		if len(report.Removed) != 0 || report.Spared != 2 {
+			t.Errorf("expected the 2 young orphans to be spared, got %+v", report)
+		}
+
+		report, err = a.CollectImageGarbage(ctx, ImageGCOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("failed to collect garbage: %v", err)
+		}
+		var removed []string
+		for _, f := range report.Removed {
+			removed = append(removed, f.Name)
+		}
+		if diff := cmp.Diff([]string{hashedName(orphan), variantOf(orphan)}, removed); diff != "" {
+			t.Errorf("unexpected removed images (-want +got):\n%s", diff)
+		}
+		if want := int64(len(orphan) + len("orphan thumbnail")); report.Bytes != want || !report.DryRun {
+			t.Errorf("expected a dry run report of %d bytes, got %+v", want, report)
+		}
+		if _, err := os.Stat(filepath.Join(a.imgDirPath, hashedName(orphan))); err != nil {
+			t.Errorf("expected dry run to keep the orphan: %v", err)
+		}
+
+		if _, err := a.CollectImageGarbage(ctx, ImageGCOptions{}); err != nil {
+			t.Fatalf("failed to collect garbage: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(a.imgDirPath, hashedName(orphan))); !os.IsNotExist(err) {
+			t.Errorf("expected the orphan to be removed")
+		}
+		if _, err := os.Stat(filepath.Join(a.imgDirPath, variantOf(orphan))); !os.IsNotExist(err) {
+			t.Errorf("expected the variant of the orphan to be removed")
+		}
+		for _, name := range []string{hashedName(good), variantOf(good), defaultImageName} {
+			if _, err := os.Stat(filepath.Join(a.imgDirPath, name)); err != nil {
+				t.Errorf("expected %s to be kept: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("background gc", func(t *testing.T) {
+		late := []byte("late orphan")
+		writeImage(hashedName(late), late)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go runImageGC(ctx, a.db, a.imgDirPath, ImageGCConfig{Interval: 10 * time.Millisecond})
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := os.Stat(filepath.Join(a.imgDirPath, hashedName(late))); os.IsNotExist(err) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the background collection to remove the orphan")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, err := os.Stat(filepath.Join(a.imgDirPath, hashedName(good))); err != nil {
+			t.Errorf("expected the referenced image to be kept: %v", err)
+		}
+	})
+
+	t.Run("server gc", func(t *testing.T) {
+		late := []byte("orphan left behind by the server")
+		writeImage(hashedName(late), late)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		// the command line passes the file store explicitly when S3_BUCKET is unset
+		s := Server{Images: NewFileImageStore(a.imgDirPath), ImageGC: ImageGCConfig{Interval: 10 * time.Millisecond}}
+		s.setupImages(ctx, a.db)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := os.Stat(filepath.Join(a.imgDirPath, hashedName(late))); os.IsNotExist(err) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the server to collect the garbage of its file store")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestAdminImageDir(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmin(t)
+	if _, err := a.MigrateUp(ctx); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	s3, err := NewS3ImageStore(S3Config{Endpoint: "http://localhost:9000", Bucket: "images"})
+	if err != nil {
+		t.Fatalf("failed to create S3 image store: %v", err)
+	}
+
+	cases := map[string]struct {
+		images ImageStore
+		want   error
+	}{
+		"ng: missing directory": {
+			images: NewFileImageStore(filepath.Join(t.TempDir(), "missing")),
+			want:   os.ErrNotExist,
+		},
+		"ng: remote store": {
+			images: s3,
+			want:   ErrRemoteImageStore,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a.ImageStore = tc.images
+			if _, err := a.CollectImageGarbage(ctx, ImageGCOptions{}); !errors.Is(err, tc.want) {
+				t.Errorf("expected the collection to fail with %v, got %v", tc.want, err)
+			}
+			if _, err := a.VerifyImages(ctx); !errors.Is(err, tc.want) {
+				t.Errorf("expected the verification to fail with %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestMigrateDownItemSeller(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdmin(t)
+	if _, err := a.MigrateUp(ctx); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	seller := &User{Email: "seller@example.com", Name: "Seller", PasswordHash: "hash"}
+	if err := a.Users.Insert(ctx, seller); err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	c := &Category{Name: "fashion"}
+	if err := a.Categories.Insert(ctx, c); err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+	item := &Item{Name: "jacket", Category: strconv.Itoa(c.ID), Images: []string{"front.jpg", "back.jpg"}, SellerID: seller.ID}
+	if err := a.Items.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	migrations, err := loadMigrations(testMigrationsDir, a.dialect)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	steps := 0
+	for _, m := range migrations {
+		if m.Name == "add_item_seller" || steps > 0 {
+			steps++
+		}
+	}
+	reverted, err := a.MigrateDown(ctx, steps)
+	if err != nil {
+		t.Fatalf("failed to migrate down: %v", err)
+	}
+	if last := reverted[len(reverted)-1]; last.Name != "add_item_seller" {
+		t.Fatalf("expected to revert down to add_item_seller, got %+v", last)
+	}
+
+	if _, err := a.db.ExecContext(ctx, `SELECT seller_id FROM items`); err == nil {
+		t.Errorf("expected the seller_id column to be dropped")
+	}
+	var name string
+	if err := a.db.QueryRowContext(ctx, `SELECT name FROM items WHERE id = ?`, item.ID).Scan(&name); err != nil || name != "jacket" {
+		t.Errorf("expected the item to be kept, got %q: %v", name, err)
+	}
+	var images int
+	if err := a.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM item_images WHERE item_id = ?`, item.ID).Scan(&images); err != nil || images != 2 {
+		t.Errorf("expected the images of the item to be kept, got %d: %v", images, err)
+	}
+
+	if _, err := a.MigrateUp(ctx); err != nil {
+		t.Fatalf("failed to migrate up again: %v", err)
+	}
+}
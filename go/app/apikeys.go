@@ -0,0 +1,399 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiKeyHeader is the request header carrying an API key.
+	apiKeyHeader = "X-API-Key"
+	// apiKeyScheme starts every API key, so that leaked keys are easy to find in code and logs.
+	apiKeyScheme = "mk_"
+
+	maxAPIKeysPerUser   = 20
+	maxAPIKeyNameLength = 100
+	// apiKeyTouchInterval is how stale the last use of a key may get before it is written
+	// again, so that a busy batch job does not write on every request.
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	errAPIKeyNotFound = errors.New("API key not found")
+	// errInvalidAPIKey is returned for a key that is malformed, unknown, revoked or expired.
+	errInvalidAPIKey = errors.New("invalid or expired API key")
+	// errInsufficientScope is returned when an API key is used on a route its scopes do not cover.
+	errInsufficientScope = errors.New("the API key does not allow this operation")
+	errTooManyAPIKeys    = fmt.Errorf("a user may have at most %d API keys", maxAPIKeysPerUser)
+)
+
+// Scope is what an API key may be used for. Keys are only accepted on the routes declared
+// with one of their scopes (see router.scoped), and never on the others.
+type Scope string
+
+const (
+	// ScopeItemsWrite allows adding and importing items, and changing or deleting those the
+	// user may change.
+	ScopeItemsWrite Scope = "items:write"
+)
+
+// knownScopes are the scopes a key may be given.
+var knownScopes = []Scope{ScopeItemsWrite}
+
+// APIKey lets a program act as its user, within its scopes, by sending the key in the
+// X-API-Key header.
+type APIKey struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"-"`
+	Name   string `json:"name"`
+	// Prefix is the public part of the key, which identifies it.
+	Prefix string `json:"prefix"`
+	// KeyHash is the SHA-256 of the whole key (see hashToken), which itself is never stored.
+	KeyHash   string    `json:"-"`
+	Scopes    []Scope   `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is zero for a key that does not expire.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// LastUsedAt is zero for a key never used; it lags by up to apiKeyTouchInterval.
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+}
+
+// hasScope reports whether the key may be used for scope.
+func (k *APIKey) hasScope(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// newAPIKey returns a new key, made of the scheme, a random prefix and a random secret,
+// and its hash.
+func newAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 6+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	prefix = apiKeyScheme + hex.EncodeToString(b[:6])
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(b[6:])
+	return key, prefix, hashToken(key), nil
+}
+
+// apiKeyPrefix returns the prefix of key, or false if key is not an API key.
+func apiKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyScheme)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 12 || secret == "" {
+		return "", false
+	}
+	return apiKeyScheme + prefix, true
+}
+
+// APIKeyRepository is an interface to manage the API keys of users.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type APIKeyRepository interface {
+	Insert(ctx context.Context, key *APIKey) error
+	SelectByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	ListByUser(ctx context.Context, userID int) ([]*APIKey, error)
+	Delete(ctx context.Context, userID, id int) error
+	Touch(ctx context.Context, id int, at time.Time) error
+}
+
+// apiKeyRepository is an implementation of APIKeyRepository
+type apiKeyRepository struct {
+	db *sql.DB
+	// dialect is the SQL flavour of db, used to rewrite placeholders.
+	dialect dialect
+	// queryTimeout bounds every query issued by the repository. Zero means no limit.
+	queryTimeout time.Duration
+}
+
+// Insert adds key and sets its ID and creation time.
+func (r *apiKeyRepository) Insert(ctx context.Context, key *APIKey) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	key.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(query), key.UserID, key.Name, key.Prefix, key.KeyHash,
+		joinScopes(key.Scopes), dbTime(key.CreatedAt), nullTime(key.ExpiresAt)).Scan(&key.ID)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to insert API key: %w", err))
+	}
+	return nil
+}
+
+// joinScopes returns scopes as stored in the database, separated by spaces like OAuth scopes.
+func joinScopes(scopes []Scope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, " ")
+}
+
+// apiKeyColumns selects the columns scanned by scanAPIKey.
+const apiKeyColumns = `SELECT id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at FROM api_keys`
+
+// scanAPIKey reads a row of an apiKeyColumns query from scan, the Scan method of a row or rows.
+func scanAPIKey(scan func(dest ...any) error) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var createdAt, expiresAt, lastUsedAt sql.NullString
+	err := scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &createdAt, &expiresAt, &lastUsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	for _, scope := range strings.Fields(scopes) {
+		key.Scopes = append(key.Scopes, Scope(scope))
+	}
+	if key.CreatedAt, err = parseDBTime(createdAt); err != nil {
+		return nil, err
+	}
+	if key.ExpiresAt, err = parseDBTime(expiresAt); err != nil {
+		return nil, err
+	}
+	if key.LastUsedAt, err = parseDBTime(lastUsedAt); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// SelectByPrefix returns the key with the given prefix, or errAPIKeyNotFound.
+func (r *apiKeyRepository) SelectByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, r.dialect.rebind(apiKeyColumns+` WHERE prefix = ?`), prefix).Scan)
+	if err != nil && !errors.Is(err, errAPIKeyNotFound) {
+		return nil, queryError(ctx, err)
+	}
+	return key, err
+}
+
+// ListByUser returns the keys of the user with the given ID, oldest first.
+func (r *apiKeyRepository) ListByUser(ctx context.Context, userID int) ([]*APIKey, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(apiKeyColumns+` WHERE user_id = ? ORDER BY id`), userID)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve API keys: %w", err))
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve API keys: %w", err))
+	}
+	return keys, nil
+}
+
+// Delete revokes the key with the given ID of the user with the given ID. It returns
+// errAPIKeyNotFound if the user has no such key.
+func (r *apiKeyRepository) Delete(ctx context.Context, userID, id int) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, r.dialect.rebind(`DELETE FROM api_keys WHERE id = ? AND user_id = ?`), id, userID)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to delete API key: %w", err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return queryError(ctx, fmt.Errorf("failed to delete API key: %w", err))
+	} else if n == 0 {
+		return errAPIKeyNotFound
+	}
+	return nil
+}
+
+// Touch records that the key with the given ID was used at the given time.
+func (r *apiKeyRepository) Touch(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, r.dialect.rebind(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`), dbTime(at), id); err != nil {
+		return queryError(ctx, fmt.Errorf("failed to record API key use: %w", err))
+	}
+	return nil
+}
+
+// authenticateAPIKey returns the key sent in the X-API-Key header of r and its user.
+// Unknown, expired and malformed keys are all errInvalidAPIKey.
+func (s *Handlers) authenticateAPIKey(r *http.Request) (*User, *APIKey, error) {
+	ctx := r.Context()
+	raw := strings.TrimSpace(r.Header.Get(apiKeyHeader))
+	prefix, ok := apiKeyPrefix(raw)
+	if !ok {
+		return nil, nil, errInvalidAPIKey
+	}
+	key, err := s.apiKeyRepo.SelectByPrefix(ctx, prefix)
+	if errors.Is(err, errAPIKeyNotFound) {
+		return nil, nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(hashToken(raw)), []byte(key.KeyHash)) != 1 ||
+		(!key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt)) {
+		return nil, nil, errInvalidAPIKey
+	}
+
+	user, err := s.userRepo.Select(ctx, key.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.BannedAt.IsZero() {
+		return nil, nil, errAccountBanned
+	}
+	if now.Sub(key.LastUsedAt) >= apiKeyTouchInterval {
+		// the request goes on even if its use cannot be recorded
+		if err := s.apiKeyRepo.Touch(ctx, key.ID, now); err != nil {
+			slog.Warn("failed to record API key use", "api_key", key.Prefix, "error", err)
+		}
+	}
+	return user, key, nil
+}
+
+// apiKeyErrorStatus maps an error of the API key handlers to an HTTP status code.
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errTooManyAPIKeys):
+		return http.StatusConflict
+	default:
+		return repositoryErrorStatus(err)
+	}
+}
+
+// CreateAPIKeyRequest creates an API key. ExpiresAt is optional: the key does not expire without it.
+type CreateAPIKeyRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []Scope   `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// validate checks the request, trimming the name and dropping duplicated scopes.
+func (req *CreateAPIKeyRequest) validate(now time.Time) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		return fmt.Errorf("name must be 1 to %d bytes long", maxAPIKeyNameLength)
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(knownScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// CreateAPIKeyResponse is a new API key. Key is only ever sent here: the server only keeps its hash.
+type CreateAPIKeyResponse struct {
+	Key    string  `json:"key"`
+	APIKey *APIKey `json:"api_key"`
+}
+
+// CreateAPIKey is a handler to create an API key of the logged in user for POST /me/api-keys .
+func (s *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := userFromContext(ctx)
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := s.apiKeyRepo.ListByUser(ctx, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	if len(existing) >= maxAPIKeysPerUser {
+		http.Error(w, errTooManyAPIKeys.Error(), apiKeyErrorStatus(errTooManyAPIKeys))
+		return
+	}
+	raw, prefix, hash, err := newAPIKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := &APIKey{UserID: user.ID, Name: req.Name, Prefix: prefix, KeyHash: hash, Scopes: req.Scopes}
+	if !req.ExpiresAt.IsZero() {
+		key.ExpiresAt = req.ExpiresAt.UTC().Truncate(time.Second)
+	}
+	if err := s.apiKeyRepo.Insert(ctx, key); err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	slog.Info("API key created", "user_id", user.ID, "api_key", key.Prefix)
+	writeJSON(w, http.StatusCreated, CreateAPIKeyResponse{Key: raw, APIKey: key})
+}
+
+// GetAPIKeys is a handler to list the API keys of the logged in user for GET /me/api-keys .
+func (s *Handlers) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.apiKeyRepo.ListByUser(r.Context(), userFromContext(r.Context()).ID)
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	if keys == nil {
+		keys = []*APIKey{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"api_keys": keys})
+}
+
+// DeleteAPIKey is a handler to revoke an API key of the logged in user for
+// DELETE /me/api-keys/{key_id} . The keys of other users are reported as not found.
+func (s *Handlers) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("key_id"))
+	if err != nil {
+		http.Error(w, "invalid key_id", http.StatusBadRequest)
+		return
+	}
+	user := userFromContext(ctx)
+	if err := s.apiKeyRepo.Delete(ctx, user.ID, id); err != nil {
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+	slog.Info("API key revoked", "user_id", user.ID, "api_key_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,362 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	backends := map[string]func(t *testing.T) string{
+		"sqlite3":  sqliteTestDSN,
+		"postgres": postgresTestDSN,
+	}
+
+	for name, dsn := range backends {
+		t.Run(name, func(t *testing.T) {
+			if testing.Short() && name == "postgres" {
+				t.Skip("skipping postgres in short mode")
+			}
+			items := newTestItemRepository(t, dsn(t))
+			ctx := context.Background()
+			users := &userRepository{db: items.db, dialect: items.dialect}
+			repo := &apiKeyRepository{db: items.db, dialect: items.dialect}
+
+			var owners []*User
+			for _, email := range []string{"batch@example.com", "other@example.com"} {
+				user := &User{Email: email, Name: "Batch", PasswordHash: "hash"}
+				if err := users.Insert(ctx, user); err != nil {
+					t.Fatalf("failed to insert user: %v", err)
+				}
+				owners = append(owners, user)
+			}
+			expires := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+			key := &APIKey{UserID: owners[0].ID, Name: "nightly import", Prefix: "mk_000000000001", KeyHash: "hash 1", Scopes: []Scope{ScopeItemsWrite}, ExpiresAt: expires}
+			forever := &APIKey{UserID: owners[0].ID, Name: "sync", Prefix: "mk_000000000002", KeyHash: "hash 2", Scopes: []Scope{ScopeItemsWrite}}
+			for _, k := range []*APIKey{key, forever} {
+				if err := repo.Insert(ctx, k); err != nil {
+					t.Fatalf("failed to insert API key: %v", err)
+				}
+			}
+
+			got, err := repo.SelectByPrefix(ctx, key.Prefix)
+			if err != nil {
+				t.Fatalf("failed to select API key: %v", err)
+			}
+			if diff := cmp.Diff(key, got); diff != "" {
+				t.Errorf("unexpected API key (-want +got):\n%s", diff)
+			}
+			if _, err := repo.SelectByPrefix(ctx, "mk_ffffffffffff"); !errors.Is(err, errAPIKeyNotFound) {
+				t.Errorf("expected errAPIKeyNotFound, got %v", err)
+			}
+
+			used := time.Now().UTC().Truncate(time.Second)
+			if err := repo.Touch(ctx, forever.ID, used); err != nil {
+				t.Fatalf("failed to touch API key: %v", err)
+			}
+			forever.LastUsedAt = used
+			list, err := repo.ListByUser(ctx, owners[0].ID)
+			if err != nil {
+				t.Fatalf("failed to list API keys: %v", err)
+			}
+			if diff := cmp.Diff([]*APIKey{key, forever}, list); diff != "" {
+				t.Errorf("unexpected API keys (-want +got):\n%s", diff)
+			}
+
+			if err := repo.Delete(ctx, owners[1].ID, key.ID); !errors.Is(err, errAPIKeyNotFound) {
+				t.Errorf("expected the key of another user not to be found, got %v", err)
+			}
+			if err := repo.Delete(ctx, owners[0].ID, key.ID); err != nil {
+				t.Fatalf("failed to delete API key: %v", err)
+			}
+			if _, err := repo.SelectByPrefix(ctx, key.Prefix); !errors.Is(err, errAPIKeyNotFound) {
+				t.Errorf("expected the key to be revoked, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		t.Fatalf("failed to generate API key: %v", err)
+	}
+	if hash != hashToken(key) {
+		t.Errorf("expected the hash of the key, got %s", hash)
+	}
+
+	cases := map[string]struct {
+		key    string
+		want   string
+		wantOK bool
+	}{
+		"ok: new key":      {key: key, want: prefix, wantOK: true},
+		"ng: bearer token": {key: "dGhpcyBpcyBhIHNlc3Npb24gdG9rZW4"},
+		"ng: prefix only":  {key: prefix},
+		"ng: short prefix": {key: "mk_abc_secret"},
+		"ng: empty secret": {key: prefix + "_"},
+		"ng: other scheme": {key: "sk_" + strings.TrimPrefix(key, apiKeyScheme)},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := apiKeyPrefix(tt.key)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("expected %q, %v, got %q, %v", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	t.Parallel()
+
+	raw, prefix, hash, err := newAPIKey()
+	if err != nil {
+		t.Fatalf("failed to generate API key: %v", err)
+	}
+
+	type wants struct {
+		code  int
+		touch bool
+	}
+	cases := map[string]struct {
+		target string
+		key    string
+		apiKey APIKey
+		banned bool
+		wants
+	}{
+		"ok: scoped route": {
+			target: "/items",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}},
+			wants:  wants{code: http.StatusOK, touch: true},
+		},
+		"ok: public route": {
+			target: "/public",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}},
+			wants:  wants{code: http.StatusOK, touch: true},
+		},
+		"ok: recently used key is not touched": {
+			target: "/items",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}, LastUsedAt: time.Now().Add(-time.Second)},
+			wants:  wants{code: http.StatusOK},
+		},
+		"ok: key expiring later": {
+			target: "/items",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}, ExpiresAt: time.Now().Add(time.Hour)},
+			wants:  wants{code: http.StatusOK, touch: true},
+		},
+		"ng: route for logged in users only": {
+			target: "/private",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}},
+			wants:  wants{code: http.StatusForbidden, touch: true},
+		},
+		"ng: key without the scope": {
+			target: "/items",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{"categories:write"}},
+			wants:  wants{code: http.StatusForbidden, touch: true},
+		},
+		"ng: expired key": {
+			target: "/items",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}, ExpiresAt: time.Now().Add(-time.Minute)},
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: wrong secret": {
+			target: "/items",
+			key:    prefix + "_wrong",
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}},
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: unknown key": {
+			target: "/items",
+			key:    "mk_ffffffffffff_secret",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: malformed key": {
+			target: "/public",
+			key:    "not a key",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: banned user": {
+			target: "/items",
+			key:    raw,
+			apiKey: APIKey{Scopes: []Scope{ScopeItemsWrite}},
+			banned: true,
+			wants:  wants{code: http.StatusForbidden},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			user := &User{ID: 1, Email: "batch@example.com", Name: "Batch"}
+			if tt.banned {
+				user.BannedAt = time.Now()
+			}
+			key := tt.apiKey
+			key.ID, key.UserID, key.Prefix, key.KeyHash = 3, user.ID, prefix, hash
+			mockKR := NewMockAPIKeyRepository(ctrl)
+			mockKR.EXPECT().SelectByPrefix(gomock.Any(), prefix).Return(&key, nil).AnyTimes()
+			mockKR.EXPECT().SelectByPrefix(gomock.Any(), "mk_ffffffffffff").Return(nil, errAPIKeyNotFound).AnyTimes()
+			if tt.wants.touch {
+				mockKR.EXPECT().Touch(gomock.Any(), key.ID, gomock.Any()).Return(nil)
+			}
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().Select(gomock.Any(), user.ID).Return(user, nil).AnyTimes()
+			h := &Handlers{userRepo: mockUR, apiKeyRepo: mockKR}
+
+			var seen *APIKey
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				seen = apiKeyFromContext(r.Context())
+			}
+			mux := http.NewServeMux()
+			routes := router{mux: mux}
+			routes.public("GET /public", handler)
+			routes.authenticated("GET /private", handler)
+			routes.scoped("GET /items", ScopeItemsWrite, handler)
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.Header.Set("X-API-Key", tt.key)
+			res := httptest.NewRecorder()
+
+			authMiddleware(mux, h.authenticate).ServeHTTP(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if res.Code == http.StatusOK && (seen == nil || seen.ID != key.ID) {
+				t.Errorf("expected the API key in the context, got %+v", seen)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body     string
+		existing int
+		wantCode int
+	}{
+		"ok: key without expiry": {body: `{"name": " nightly import ", "scopes": ["items:write", "items:write"]}`, wantCode: http.StatusCreated},
+		"ok: key with expiry":    {body: `{"name": "nightly import", "scopes": ["items:write"], "expires_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`, wantCode: http.StatusCreated},
+		"ng: expiry in the past": {body: `{"name": "nightly import", "scopes": ["items:write"], "expires_at": "2020-01-01T00:00:00Z"}`, wantCode: http.StatusBadRequest},
+		"ng: unknown scope":      {body: `{"name": "nightly import", "scopes": ["users:ban"]}`, wantCode: http.StatusBadRequest},
+		"ng: no scope":           {body: `{"name": "nightly import", "scopes": []}`, wantCode: http.StatusBadRequest},
+		"ng: no name":            {body: `{"name": " ", "scopes": ["items:write"]}`, wantCode: http.StatusBadRequest},
+		"ng: invalid body":       {body: `nightly import`, wantCode: http.StatusBadRequest},
+		"ng: too many keys":      {body: `{"name": "nightly import", "scopes": ["items:write"]}`, existing: maxAPIKeysPerUser, wantCode: http.StatusConflict},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockKR := NewMockAPIKeyRepository(ctrl)
+			if tt.wantCode != http.StatusBadRequest {
+				mockKR.EXPECT().ListByUser(gomock.Any(), testSeller.ID).Return(make([]*APIKey, tt.existing), nil)
+			}
+			var inserted *APIKey
+			if tt.wantCode == http.StatusCreated {
+				mockKR.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key *APIKey) error {
+					key.ID = 3
+					inserted = key
+					return nil
+				})
+			}
+			h := &Handlers{apiKeyRepo: mockKR}
+			req := httptest.NewRequest("POST", "/me/api-keys", strings.NewReader(tt.body))
+			res := httptest.NewRecorder()
+
+			h.CreateAPIKey(res, asUser(req, testSeller))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+			if res.Code != http.StatusCreated {
+				return
+			}
+			var got struct {
+				Key    string         `json:"key"`
+				APIKey map[string]any `json:"api_key"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if prefix, ok := apiKeyPrefix(got.Key); !ok || prefix != inserted.Prefix || hashToken(got.Key) != inserted.KeyHash {
+				t.Errorf("expected the key stored hashed under its prefix, got %q", got.Key)
+			}
+			if inserted.UserID != testSeller.ID || inserted.Name != "nightly import" || !cmp.Equal(inserted.Scopes, []Scope{ScopeItemsWrite}) {
+				t.Errorf("unexpected API key %+v", inserted)
+			}
+			if _, ok := got.APIKey["key_hash"]; ok || got.APIKey["prefix"] != inserted.Prefix {
+				t.Errorf("expected the key without its hash, got %v", got.APIKey)
+			}
+		})
+	}
+}
+
+func TestDeleteAPIKey(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		keyID     string
+		deleteErr error
+		wantCode  int
+	}{
+		"ok: own key":        {keyID: "3", wantCode: http.StatusNoContent},
+		"ng: key of no one":  {keyID: "4", deleteErr: errAPIKeyNotFound, wantCode: http.StatusNotFound},
+		"ng: invalid key_id": {keyID: "abc", wantCode: http.StatusBadRequest},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockKR := NewMockAPIKeyRepository(ctrl)
+			if tt.wantCode != http.StatusBadRequest {
+				mockKR.EXPECT().Delete(gomock.Any(), testSeller.ID, gomock.Any()).Return(tt.deleteErr)
+			}
+			h := &Handlers{apiKeyRepo: mockKR}
+			req := httptest.NewRequest("DELETE", "/me/api-keys/"+tt.keyID, nil)
+			req.SetPathValue("key_id", tt.keyID)
+			res := httptest.NewRecorder()
+
+			h.DeleteAPIKey(res, asUser(req, testSeller))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 500
+)
+
+// AuditAction is an operation of an admin or a moderator recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditCategoryCreate AuditAction = "category.create"
+	AuditItemHide       AuditAction = "item.hide"
+	AuditItemUnhide     AuditAction = "item.unhide"
+	// AuditItemDelete is only recorded when the item was deleted by someone else than its seller.
+	AuditItemDelete AuditAction = "item.delete"
+	AuditUserBan    AuditAction = "user.ban"
+	AuditUserUnban  AuditAction = "user.unban"
+	AuditUserRole   AuditAction = "user.role"
+)
+
+// AuditEntry is an operation recorded in the audit log.
+type AuditEntry struct {
+	ID int `json:"id"`
+	// ActorID is the user who did the operation, zero once their account is deleted.
+	ActorID int         `json:"actor_id,omitempty"`
+	Action  AuditAction `json:"action"`
+	// TargetType and TargetID name what the operation changed, e.g. the item 3.
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	// Details are free-form, such as the reason an item was hidden or the new role of a user.
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditRepository is an interface to record and read the audit log.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type AuditRepository interface {
+	Insert(ctx context.Context, entry *AuditEntry) error
+	List(ctx context.Context, limit, before int) ([]*AuditEntry, error)
+}
+
+// auditRepository is an implementation of AuditRepository
+type auditRepository struct {
+	db *sql.DB
+	// dialect is the SQL flavour of db, used to rewrite placeholders.
+	dialect dialect
+	// queryTimeout bounds every query issued by the repository. Zero means no limit.
+	queryTimeout time.Duration
+}
+
+// Insert adds entry and sets its ID and time.
+func (r *auditRepository) Insert(ctx context.Context, entry *AuditEntry) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO audit_log (actor_id, action, target_type, target_id, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(query), nullInt(entry.ActorID), string(entry.Action),
+		entry.TargetType, entry.TargetID, entry.Details, dbTime(entry.CreatedAt)).Scan(&entry.ID)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to insert audit entry: %w", err))
+	}
+	return nil
+}
+
+// List returns at most limit entries, newest first, starting after the entry with ID before
+// when it is not zero.
+func (r *auditRepository) List(ctx context.Context, limit, before int) ([]*AuditEntry, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	query := `SELECT id, actor_id, action, target_type, target_id, details, created_at FROM audit_log`
+	args := []any{}
+	if before > 0 {
+		query += ` WHERE id < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve audit log: %w", err))
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var actorID sql.NullInt64
+		var createdAt sql.NullString
+		if err := rows.Scan(&entry.ID, &actorID, &entry.Action, &entry.TargetType, &entry.TargetID, &entry.Details, &createdAt); err != nil {
+			return nil, queryError(ctx, fmt.Errorf("failed to scan audit entry: %w", err))
+		}
+		entry.ActorID = int(actorID.Int64)
+		if entry.CreatedAt, err = parseDBTime(createdAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve audit log: %w", err))
+	}
+	return entries, nil
+}
+
+// audit records that the user of ctx did action on the target. The operation is done by
+// then, so a failure to record it is logged rather than reported to the client.
+func (s *Handlers) audit(ctx context.Context, action AuditAction, targetType string, targetID int, details string) {
+	entry := &AuditEntry{Action: action, TargetType: targetType, TargetID: targetID, Details: details}
+	if user := userFromContext(ctx); user != nil {
+		entry.ActorID = user.ID
+	}
+	// the client going away must not lose the record of what it did
+	if err := s.auditRepo.Insert(context.WithoutCancel(ctx), entry); err != nil {
+		slog.Error("failed to record audit entry", "action", action, "target_type", targetType, "target_id", targetID, "error", err)
+		return
+	}
+	slog.Info("audited", "action", action, "target_type", targetType, "target_id", targetID, "actor_id", entry.ActorID)
+}
+
+// AuditLogResponse is a page of the audit log. NextBefore is the before parameter fetching
+// the next page, zero on the last page.
+type AuditLogResponse struct {
+	Entries    []*AuditEntry `json:"entries"`
+	NextBefore int           `json:"next_before,omitempty"`
+}
+
+// GetAuditLog is a handler to read the audit log, newest first, for
+// GET /audit-log?limit=&before= .
+func (s *Handlers) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, before := defaultAuditLogLimit, 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLogLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLogLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := query.Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid before", http.StatusBadRequest)
+			return
+		}
+		before = n
+	}
+
+	entries, err := s.auditRepo.List(r.Context(), limit, before)
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	resp := AuditLogResponse{Entries: entries}
+	if resp.Entries == nil {
+		resp.Entries = []*AuditEntry{}
+	}
+	if len(entries) == limit {
+		resp.NextBefore = entries[len(entries)-1].ID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
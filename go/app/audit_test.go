@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAuditRepository(t *testing.T) {
+	backends := map[string]func(t *testing.T) string{
+		"sqlite3":  sqliteTestDSN,
+		"postgres": postgresTestDSN,
+	}
+
+	for name, dsn := range backends {
+		t.Run(name, func(t *testing.T) {
+			if testing.Short() && name == "postgres" {
+				t.Skip("skipping postgres in short mode")
+			}
+			items := newTestItemRepository(t, dsn(t))
+			ctx := context.Background()
+			users := &userRepository{db: items.db, dialect: items.dialect}
+			repo := &auditRepository{db: items.db, dialect: items.dialect}
+
+			admin := &User{Email: "admin@example.com", Name: "Admin", Role: RoleAdmin, PasswordHash: "hash"}
+			if err := users.Insert(ctx, admin); err != nil {
+				t.Fatalf("failed to insert user: %v", err)
+			}
+			var entries []*AuditEntry
+			for i, action := range []AuditAction{AuditUserBan, AuditItemHide, AuditUserUnban} {
+				entry := &AuditEntry{ActorID: admin.ID, Action: action, TargetType: "user", TargetID: i + 1, Details: string(action)}
+				if err := repo.Insert(ctx, entry); err != nil {
+					t.Fatalf("failed to insert audit entry: %v", err)
+				}
+				entries = append(entries, entry)
+			}
+			// the actor is unknown for operations done from the command line
+			anonymous := &AuditEntry{Action: AuditCategoryCreate, TargetType: "category", TargetID: 1}
+			if err := repo.Insert(ctx, anonymous); err != nil {
+				t.Fatalf("failed to insert audit entry: %v", err)
+			}
+
+			got, err := repo.List(ctx, 2, 0)
+			if err != nil {
+				t.Fatalf("failed to list audit log: %v", err)
+			}
+			if diff := cmp.Diff([]*AuditEntry{anonymous, entries[2]}, got); diff != "" {
+				t.Errorf("unexpected first page (-want +got):\n%s", diff)
+			}
+			got, err = repo.List(ctx, 2, got[1].ID)
+			if err != nil {
+				t.Fatalf("failed to list audit log: %v", err)
+			}
+			if diff := cmp.Diff([]*AuditEntry{entries[1], entries[0]}, got); diff != "" {
+				t.Errorf("unexpected second page (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetAuditLog(t *testing.T) {
+	t.Parallel()
+
+	entries := []*AuditEntry{
+		{ID: 3, ActorID: testAdmin.ID, Action: AuditUserBan, TargetType: "user", TargetID: 8},
+		{ID: 2, ActorID: testAdmin.ID, Action: AuditItemHide, TargetType: "item", TargetID: 1, Details: "spam"},
+	}
+
+	type wants struct {
+		code       int
+		limit      int
+		before     int
+		nextBefore int
+	}
+	cases := map[string]struct {
+		query string
+		wants
+	}{
+		"ok: first page":      {query: "", wants: wants{code: http.StatusOK, limit: defaultAuditLogLimit}},
+		"ok: full page":       {query: "?limit=2", wants: wants{code: http.StatusOK, limit: 2, nextBefore: 2}},
+		"ok: next page":       {query: "?limit=2&before=4", wants: wants{code: http.StatusOK, limit: 2, before: 4, nextBefore: 2}},
+		"ng: zero limit":      {query: "?limit=0", wants: wants{code: http.StatusBadRequest}},
+		"ng: limit too large": {query: "?limit=100000", wants: wants{code: http.StatusBadRequest}},
+		"ng: invalid before":  {query: "?before=abc", wants: wants{code: http.StatusBadRequest}},
+		"ng: negative before": {query: "?before=-1", wants: wants{code: http.StatusBadRequest}},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockAR := NewMockAuditRepository(ctrl)
+			if tt.wants.code == http.StatusOK {
+				mockAR.EXPECT().List(gomock.Any(), tt.wants.limit, tt.wants.before).Return(entries, nil)
+			}
+			h := &Handlers{auditRepo: mockAR}
+			req := asUser(httptest.NewRequest("GET", "/audit-log"+tt.query, nil), testAdmin)
+			res := httptest.NewRecorder()
+
+			h.GetAuditLog(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			var got AuditLogResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(entries, got.Entries); diff != "" {
+				t.Errorf("unexpected entries (-want +got):\n%s", diff)
+			}
+			if got.NextBefore != tt.wants.nextBefore {
+				t.Errorf("expected next_before %d, got %d", tt.wants.nextBefore, got.NextBefore)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// contextKey is the type of the keys of the values this package puts in request contexts.
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	apiKeyContextKey
+)
+
+// withUser returns a copy of ctx carrying the authenticated user.
+func withUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// userFromContext returns the user authenticated by authMiddleware, or nil for an anonymous request.
+func userFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey).(*User)
+	return user
+}
+
+// withAPIKey returns a copy of ctx carrying the API key the request was authenticated with.
+func withAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, key)
+}
+
+// apiKeyFromContext returns the API key authMiddleware authenticated the request with, or
+// nil when the request was not sent with one.
+func apiKeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey).(*APIKey)
+	return key
+}
+
+// authMiddleware authenticates the requests that carry a bearer token or an API key with
+// authenticate, and passes the user, and the key if any, on in the request context. Requests
+// without credentials go on anonymously, and it is up to the route to require a user (see
+// router); credentials that are invalid or expired are rejected even on a public route, so
+// that clients learn to refresh them.
+func authMiddleware(next http.Handler, authenticate func(r *http.Request) (*User, *APIKey, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerToken(r); !ok && r.Header.Get(apiKeyHeader) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, key, err := authenticate(r)
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		ctx := withUser(r.Context(), user)
+		if key != nil {
+			ctx = withAPIKey(ctx, key)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireUser responds 401 to requests that authMiddleware did not authenticate, and 403 to
+// those authenticated with an API key: unless a route accepts a scope (see requireScope),
+// it is for logged in users only.
+func requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userFromContext(r.Context()) == nil {
+			writeAuthError(w, errUnauthenticated)
+			return
+		}
+		if apiKeyFromContext(r.Context()) != nil {
+			writeAuthError(w, errInsufficientScope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireScope is requireUser for routes that also accept the API keys with the given scope.
+func requireScope(scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromContext(r.Context())
+		if key == nil {
+			requireUser(next).ServeHTTP(w, r)
+			return
+		}
+		if !key.hasScope(scope) {
+			writeAuthError(w, fmt.Errorf("%w: it lacks the %s scope", errInsufficientScope, scope))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePermission responds 401 to requests that authMiddleware did not authenticate, and
+// 403 to those of users whose role lacks permission p.
+func requirePermission(p Permission, next http.Handler) http.Handler {
+	return requireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !userFromContext(r.Context()).can(p) {
+			writeAuthError(w, fmt.Errorf("%w: %s", errPermissionDenied, p))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// router registers the routes of the API on mux, each declared public, for authenticated
+// users only, also for API keys with a scope, or for the users whose role has a permission.
+type router struct {
+	mux *http.ServeMux
+}
+
+// public registers a route that anyone may call.
+func (rt router) public(pattern string, handler http.HandlerFunc) {
+	rt.mux.Handle(pattern, handler)
+}
+
+// authenticated registers a route that requires a logged in user.
+func (rt router) authenticated(pattern string, handler http.HandlerFunc) {
+	rt.mux.Handle(pattern, requireUser(handler))
+}
+
+// scoped registers a route that requires a logged in user or an API key with the given scope.
+func (rt router) scoped(pattern string, scope Scope, handler http.HandlerFunc) {
+	rt.mux.Handle(pattern, requireScope(scope, handler))
+}
+
+// authorized registers a route that requires a logged in user with permission p.
+func (rt router) authorized(pattern string, p Permission, handler http.HandlerFunc) {
+	rt.mux.Handle(pattern, requirePermission(p, handler))
+}
+
+// corsAllowedHeaders are the request headers the front end may send. Browsers do not count
+// Authorization under the "*" wildcard, and take it literally for credentialed requests,
+// so every header is listed.
+var corsAllowedHeaders = []string{"Authorization", apiKeyHeader, "Content-Type"}
+
+// corsMiddleware is simpleCORSMiddleware allowing the front end to send the credentials
+// that authMiddleware checks.
+func corsMiddleware(next http.Handler, origin string, methods []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ","))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
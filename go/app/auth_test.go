@@ -0,0 +1,188 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+		// user is the email of the user the handler saw, empty for an anonymous request.
+		user string
+	}
+	cases := map[string]struct {
+		target        string
+		authorization string
+		sessionErr    error
+		banned        bool
+		wants
+	}{
+		"ok: public route, anonymous": {
+			target: "/public",
+			wants:  wants{code: http.StatusOK},
+		},
+		"ok: public route, logged in": {
+			target:        "/public",
+			authorization: "Bearer token",
+			wants:         wants{code: http.StatusOK, user: "alice@example.com"},
+		},
+		"ok: authenticated route, logged in": {
+			target:        "/private",
+			authorization: "bearer token",
+			wants:         wants{code: http.StatusOK, user: "alice@example.com"},
+		},
+		"ng: authenticated route, anonymous": {
+			target: "/private",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: authenticated route, other scheme": {
+			target:        "/private",
+			authorization: "Basic dXNlcjpwYXNz",
+			wants:         wants{code: http.StatusUnauthorized},
+		},
+		"ng: expired token on a public route": {
+			target:        "/public",
+			authorization: "Bearer token",
+			sessionErr:    errSessionNotFound,
+			wants:         wants{code: http.StatusUnauthorized},
+		},
+		"ng: banned user": {
+			target:        "/public",
+			authorization: "Bearer token",
+			banned:        true,
+			wants:         wants{code: http.StatusForbidden},
+		},
+		"ng: session lookup timed out": {
+			target:        "/private",
+			authorization: "Bearer token",
+			sessionErr:    errQueryTimeout,
+			wants:         wants{code: http.StatusGatewayTimeout},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			user := &User{ID: 1, Email: "alice@example.com", Name: "Alice"}
+			if tt.banned {
+				user.BannedAt = time.Now()
+			}
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().SelectSession(gomock.Any(), hashToken("token"), gomock.Any()).
+				Return(&Session{UserID: user.ID}, tt.sessionErr).AnyTimes()
+			mockUR.EXPECT().Select(gomock.Any(), user.ID).Return(user, nil).AnyTimes()
+			h := &Handlers{userRepo: mockUR}
+
+			var seen string
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if u := userFromContext(r.Context()); u != nil {
+					seen = u.Email
+				}
+			}
+			mux := http.NewServeMux()
+			routes := router{mux: mux}
+			routes.public("GET /public", handler)
+			routes.authenticated("GET /private", handler)
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := httptest.NewRecorder()
+
+			authMiddleware(mux, h.authenticate).ServeHTTP(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if seen != tt.wants.user {
+				t.Errorf("expected user %q in the context, got %q", tt.wants.user, seen)
+			}
+			if res.Code == http.StatusUnauthorized && res.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		user       *User
+		permission Permission
+		wantCode   int
+	}{
+		"ok: admin":                     {user: testAdmin, permission: PermissionManageCategories, wantCode: http.StatusOK},
+		"ok: moderator":                 {user: testModerator, permission: PermissionHideItems, wantCode: http.StatusOK},
+		"ng: moderator lacks the right": {user: testModerator, permission: PermissionManageCategories, wantCode: http.StatusForbidden},
+		"ng: user":                      {user: testSeller, permission: PermissionViewAuditLog, wantCode: http.StatusForbidden},
+		"ng: anonymous":                 {permission: PermissionViewAuditLog, wantCode: http.StatusUnauthorized},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mux := http.NewServeMux()
+			routes := router{mux: mux}
+			routes.authorized("GET /staff", tt.permission, func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest("GET", "/staff", nil)
+			if tt.user != nil {
+				req = asUser(req, tt.user)
+			}
+			res := httptest.NewRecorder()
+
+			mux.ServeHTTP(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), "http://localhost:3000", []string{"GET", "POST", "OPTIONS"})
+
+	cases := map[string]struct {
+		method string
+		code   int
+	}{
+		"ok: preflight": {method: http.MethodOptions, code: http.StatusOK},
+		"ok: request":   {method: http.MethodGet, code: http.StatusTeapot},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, httptest.NewRequest(tt.method, "/items", nil))
+
+			if res.Code != tt.code {
+				t.Errorf("expected status code %d, got %d", tt.code, res.Code)
+			}
+			if got, want := res.Header().Get("Access-Control-Allow-Headers"), "Authorization, X-API-Key, Content-Type"; got != want {
+				t.Errorf("expected allowed headers %q, got %q", want, got)
+			}
+			if got := res.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:3000" {
+				t.Errorf("expected the front end origin, got %q", got)
+			}
+		})
+	}
+}
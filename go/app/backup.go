@@ -0,0 +1,352 @@
+package app
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A backup archive is a gzip-compressed tar file laid out as follows:
+//
+//	manifest.json       the BackupManifest describing every other entry
+//	mercari.sqlite3     a consistent snapshot of the database
+//	images/<file>       every image referenced by the snapshot
+const (
+	backupManifestName = "manifest.json"
+	backupDatabaseName = "mercari.sqlite3"
+	backupImageDir     = "images"
+)
+
+var errBackupUnsupported = errors.New("backup and restore are only supported for SQLite")
+
+// BackupManifest describes the contents of a backup archive.
+type BackupManifest struct {
+	CreatedAt time.Time    `json:"created_at"`
+	Files     []BackupFile `json:"files"`
+}
+
+// BackupFile is an entry of a backup archive together with its checksum.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup writes a consistent snapshot of the SQLite database selected by cfg,
+// bundled with the images it references from imgDirPath, to w.
+// It is safe to run while the server keeps serving requests.
+func Backup(ctx context.Context, cfg DatabaseConfig, imgDirPath string, w io.Writer) (*BackupManifest, error) {
+	db, d, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if d != dialectSQLite {
+		return nil, errBackupUnsupported
+	}
+
+	tmpDir, err := os.MkdirTemp("", "mercari-backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// take the snapshot with the online backup API
+	snapshotPath := filepath.Join(tmpDir, backupDatabaseName)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	err = sqliteBackup(ctx, conn, snapshotPath)
+	conn.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take database snapshot: %w", err)
+	}
+
+	// list the images referenced by the snapshot, not by the live database
+	imageNames, err := snapshotImageNames(ctx, snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{backupDatabaseName: snapshotPath}
+	for _, name := range imageNames {
+		p := filepath.Join(imgDirPath, name)
+		if _, err := os.Stat(p); err != nil {
+			slog.Warn("referenced image is missing, skipping", "path", p, "error", err)
+			continue
+		}
+		files[path.Join(backupImageDir, name)] = p
+	}
+
+	manifest := &BackupManifest{CreatedAt: time.Now().UTC()}
+	for name, p := range files {
+		f, err := hashFile(p)
+		if err != nil {
+			return nil, err
+		}
+		f.Name = name
+		manifest.Files = append(manifest.Files, f)
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Name < manifest.Files[j].Name })
+
+	if err := writeBackupArchive(w, manifest, files); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Restore replaces the SQLite database selected by cfg and adds the bundled images to
+// imgDirPath from the backup archive read from r. Every entry is checked against the
+// manifest and the database snapshot must pass an integrity check before anything is
+// touched. Images are moved into place first, as they are content-addressed and never
+// overwrite anything else; the database is then replaced in a single step.
+func Restore(ctx context.Context, cfg DatabaseConfig, imgDirPath string, r io.Reader) (*BackupManifest, error) {
+	db, d, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if d != dialectSQLite {
+		return nil, errBackupUnsupported
+	}
+
+	if err := ensureImageDirExists(imgDirPath); err != nil {
+		return nil, err
+	}
+	// stage images next to their destination so that moving them is a rename
+	stageDir, err := os.MkdirTemp(imgDirPath, ".restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stageDir)
+
+	manifest, err := extractBackupArchive(r, stageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotPath := filepath.Join(stageDir, backupDatabaseName)
+	if err := checkSnapshotIntegrity(ctx, snapshotPath); err != nil {
+		return nil, err
+	}
+
+	for _, f := range manifest.Files {
+		if f.Name == backupDatabaseName {
+			continue
+		}
+		name := path.Base(f.Name)
+		if err := os.Rename(filepath.Join(stageDir, backupImageDir, name), filepath.Join(imgDirPath, name)); err != nil {
+			return nil, fmt.Errorf("failed to restore image %s: %w", name, err)
+		}
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer conn.Close()
+	if err := sqliteRestore(ctx, conn, snapshotPath); err != nil {
+		return nil, fmt.Errorf("failed to restore database: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// snapshotImageNames returns the distinct image file names referenced by the snapshot.
+func snapshotImageNames(ctx context.Context, snapshotPath string) ([]string, error) {
+	db, err := sql.Open(sqliteDriverName, snapshotPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database snapshot: %w", err)
+	}
+	defer db.Close()
+
+	return referencedImageNames(ctx, db)
+}
+
+// checkSnapshotIntegrity runs PRAGMA integrity_check on the database file at snapshotPath.
+func checkSnapshotIntegrity(ctx context.Context, snapshotPath string) error {
+	db, err := sql.Open(sqliteDriverName, snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database snapshot: %w", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("failed to check database snapshot: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database snapshot is corrupted: %s", result)
+	}
+	return nil
+}
+
+func hashFile(p string) (BackupFile, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return BackupFile{}, fmt.Errorf("failed to open %s: %w", p, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return BackupFile{}, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	return BackupFile{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// writeBackupArchive writes the manifest followed by the files it lists.
+// files maps archive entry names to paths on disk.
+func writeBackupArchive(w io.Writer, manifest *BackupManifest, files map[string]string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    backupManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifestJSON)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if _, err := tw.Write(manifestJSON); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	for _, f := range manifest.Files {
+		if err := writeBackupEntry(tw, f, files[f.Name], manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+func writeBackupEntry(tw *tar.Writer, f BackupFile, p string, modTime time.Time) error {
+	src, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", p, err)
+	}
+	defer src.Close()
+
+	err = tw.WriteHeader(&tar.Header{Name: f.Name, Mode: 0644, Size: f.Size, ModTime: modTime})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.Name, err)
+	}
+	if _, err := io.CopyN(tw, src, f.Size); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// extractBackupArchive extracts the archive read from r into dir and verifies every
+// entry against the manifest, which must be the first entry.
+func extractBackupArchive(r io.Reader, dir string) (*BackupManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != backupManifestName {
+		return nil, fmt.Errorf("archive does not start with %s", backupManifestName)
+	}
+	manifest := &BackupManifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	expected := make(map[string]BackupFile, len(manifest.Files))
+	for _, f := range manifest.Files {
+		if !validBackupEntryName(f.Name) {
+			return nil, fmt.Errorf("invalid entry in manifest: %s", f.Name)
+		}
+		expected[f.Name] = f
+	}
+	if _, ok := expected[backupDatabaseName]; !ok {
+		return nil, fmt.Errorf("archive does not contain %s", backupDatabaseName)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, backupImageDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		f, ok := expected[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("unexpected entry in archive: %s", hdr.Name)
+		}
+		delete(expected, hdr.Name)
+		if err := extractBackupEntry(tr, f, filepath.Join(dir, filepath.FromSlash(f.Name))); err != nil {
+			return nil, err
+		}
+	}
+	for name := range expected {
+		return nil, fmt.Errorf("archive is missing %s", name)
+	}
+	return manifest, nil
+}
+
+func extractBackupEntry(r io.Reader, f BackupFile, dst string) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	if n != f.Size || hex.EncodeToString(h.Sum(nil)) != f.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s", f.Name)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// validBackupEntryName reports whether name is the database snapshot or a file directly under images/.
+func validBackupEntryName(name string) bool {
+	if name == backupDatabaseName {
+		return true
+	}
+	dir, file := path.Split(name)
+	return dir == backupImageDir+"/" && file != "" && file != "." && file != ".."
+}
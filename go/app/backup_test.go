@@ -0,0 +1,121 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+
+	dsn := sqliteTestDSN(t)
+	cfg := DatabaseConfig{DSN: dsn}
+	repo := newTestItemRepository(t, dsn)
+	categoryID := insertTestCategory(t, repo, "fashion")
+
+	imgDirPath := t.TempDir()
+	image := []byte("not really a jpeg")
+	if err := os.WriteFile(filepath.Join(imgDirPath, "jacket.jpg"), image, 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	item := &Item{Name: "jacket", Category: strconv.Itoa(categoryID), ImageFileName: "jacket.jpg"}
+	if err := repo.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	var archive bytes.Buffer
+	manifest, err := Backup(ctx, cfg, imgDirPath, &archive)
+	if err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("expected database and one image in manifest, got %+v", manifest.Files)
+	}
+
+	t.Run("ok: restores database and images", func(t *testing.T) {
+		// lose the data the backup is supposed to bring back
+		if _, err := repo.db.Exec(`DELETE FROM items`); err != nil {
+			t.Fatalf("failed to delete items: %v", err)
+		}
+		restoreDir := t.TempDir()
+
+		if _, err := Restore(ctx, cfg, restoreDir, bytes.NewReader(archive.Bytes())); err != nil {
+			t.Fatalf("failed to restore: %v", err)
+		}
+
+		items, err := repo.LoadItems(ctx)
+		if err != nil {
+			t.Fatalf("failed to load items: %v", err)
+		}
+		if len(items) != 1 || items[0].Name != "jacket" {
+			t.Errorf("expected the backed up item, got %+v", items)
+		}
+		got, err := os.ReadFile(filepath.Join(restoreDir, "jacket.jpg"))
+		if err != nil {
+			t.Fatalf("failed to read restored image: %v", err)
+		}
+		if !bytes.Equal(got, image) {
+			t.Errorf("restored image differs from the original")
+		}
+	})
+
+	t.Run("ng: rejects tampered archive", func(t *testing.T) {
+		tampered := tamperBackupArchive(t, archive.Bytes(), "images/jacket.jpg", []byte("tampered image!!!"))
+		restoreDir := t.TempDir()
+
+		if _, err := Restore(ctx, cfg, restoreDir, bytes.NewReader(tampered)); err == nil {
+			t.Fatalf("expected a checksum error")
+		}
+		if _, err := os.Stat(filepath.Join(restoreDir, "jacket.jpg")); !os.IsNotExist(err) {
+			t.Errorf("expected no image to be restored from a tampered archive")
+		}
+	})
+}
+
+// tamperBackupArchive returns a copy of archive with the content of entry replaced by content.
+func tamperBackupArchive(t *testing.T, archive []byte, entry string, content []byte) []byte {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	tr := tar.NewReader(gr)
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		if hdr.Name == entry {
+			data = content
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write archive: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("failed to write archive: %v", err)
+		}
+	}
+	tw.Close()
+	gw.Close()
+	return out.Bytes()
+}
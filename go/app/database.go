@@ -0,0 +1,143 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// migrationsDir is the directory holding the migrations of every dialect, relative to the working directory.
+const migrationsDir = "db/migrations"
+
+// DatabaseConfig holds the settings used to open the item database.
+type DatabaseConfig struct {
+	// DSN selects the backend by its scheme.
+	// "postgres://" and "postgresql://" open PostgreSQL,
+	// "sqlite3://" or a bare file path open SQLite.
+	DSN string
+	// QueryTimeout is the deadline applied to each database query. Zero means no limit.
+	QueryTimeout time.Duration
+	// BusyTimeout is how long SQLite waits for a lock held by another connection
+	// before failing with "database is locked". Ignored for PostgreSQL.
+	BusyTimeout time.Duration
+
+	// MaxOpenConns is the maximum number of open connections. Zero means unlimited.
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections. Zero keeps the database/sql default.
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum time a connection may be reused. Zero means forever.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime is the maximum time a connection may stay idle. Zero means forever.
+	ConnMaxIdleTime time.Duration
+}
+
+// dialect identifies the SQL flavour spoken by the database backend.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+func (d dialect) String() string {
+	switch d {
+	case dialectPostgres:
+		return "postgres"
+	default:
+		return "sqlite3"
+	}
+}
+
+// driverName returns the database/sql driver registered for the dialect.
+func (d dialect) driverName() string {
+	switch d {
+	case dialectPostgres:
+		return "pgx"
+	default:
+		return sqliteDriverName
+	}
+}
+
+// migrationsSubdir returns the directory under migrationsDir holding the migrations for the dialect.
+func (d dialect) migrationsSubdir() string {
+	switch d {
+	case dialectPostgres:
+		return "postgres"
+	default:
+		return "sqlite"
+	}
+}
+
+// rebind rewrites the "?" placeholders of query into the form expected by the dialect.
+// Queries in this package are written with "?" and must not contain a literal question mark.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// parseDSN detects the dialect of dsn and returns the data source name understood by its driver.
+func parseDSN(dsn string) (dialect, string, error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return dialectPostgres, dsn, nil
+	case strings.HasPrefix(dsn, "sqlite3://"):
+		return dialectSQLite, strings.TrimPrefix(dsn, "sqlite3://"), nil
+	case strings.Contains(dsn, "://"):
+		return 0, "", fmt.Errorf("unsupported database scheme: %s", dsn)
+	case dsn == "":
+		return 0, "", fmt.Errorf("database DSN is required")
+	default:
+		return dialectSQLite, dsn, nil
+	}
+}
+
+// openDatabase opens the database selected by cfg.DSN without touching its schema.
+// SQLite connections are opened in WAL mode with the busy timeout and foreign key
+// enforcement enabled, and the pool limits of cfg are applied to every backend.
+func openDatabase(cfg DatabaseConfig) (*sql.DB, dialect, error) {
+	d, source, err := parseDSN(cfg.DSN)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d == dialectSQLite {
+		source = sqliteDSN(source, cfg.BusyTimeout)
+	}
+	db, err := sql.Open(d.driverName(), source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	return db, d, nil
+}
+
+// appendDSNParams appends the URL-style query parameters params to the SQLite path source.
+func appendDSNParams(source string, params []string) string {
+	sep := "?"
+	if strings.Contains(source, "?") {
+		sep = "&"
+	}
+	return source + sep + strings.Join(params, "&")
+}
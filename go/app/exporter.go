@@ -0,0 +1,196 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Export file formats, the same as the import ones.
+const (
+	ExportFormatCSV   = ImportFormatCSV
+	ExportFormatJSONL = ImportFormatJSONL
+)
+
+// exportFlushInterval is the number of rows written between two flushes of the response,
+// so that clients receive the export progressively.
+const exportFlushInterval = 100
+
+// ExportedItem is a row of an item export.
+type ExportedItem struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Category      string `json:"category"`
+	ImageFileName string `json:"image_name"`
+	ImageURL      string `json:"image_url"`
+}
+
+// exportColumns is the header of a CSV export, in the field order of ExportedItem.
+var exportColumns = []string{"id", "name", "category", "image_name", "image_url"}
+
+// itemExporter writes exported items in one of the export formats.
+type itemExporter interface {
+	Write(item *ExportedItem) error
+	Flush() error
+}
+
+type csvItemExporter struct {
+	w *csv.Writer
+}
+
+func newCSVItemExporter(w io.Writer) (*csvItemExporter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return nil, err
+	}
+	return &csvItemExporter{w: cw}, nil
+}
+
+func (e *csvItemExporter) Write(item *ExportedItem) error {
+	return e.w.Write([]string{strconv.Itoa(item.ID), item.Name, item.Category, item.ImageFileName, item.ImageURL})
+}
+
+func (e *csvItemExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlItemExporter struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlItemExporter) Write(item *ExportedItem) error {
+	// Encode terminates each value with a newline
+	return e.enc.Encode(item)
+}
+
+func (e *jsonlItemExporter) Flush() error {
+	return nil
+}
+
+// apiBaseURL returns the root URL of the server, as seen by the client of r.
+func apiBaseURL(r *http.Request) *url.URL {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host, Path: "/"}
+}
+
+// imageURL returns the absolute URL of image name of item, as given by itemImageURL.
+// The URLs served by the API are relative to the server; those are resolved against base.
+func (s *Handlers) imageURL(base *url.URL, item *Item, name string) string {
+	ref := s.itemImageURL(item, name)
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return base.JoinPath(imagePathPrefix, name).String()
+	}
+	return base.ResolveReference(u).String()
+}
+
+// ExportItems is a handler to stream every item for GET /items/export .
+// The format query parameter selects CSV (the default) or JSON Lines, and the optional
+// keyword parameter filters items like GET /search does.
+// Items are read a page at a time and written as they come, so the export never holds the
+// whole table in memory, nor a database connection while the client reads.
+func (s *Handlers) ExportItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+
+	var contentType string
+	switch format {
+	case ExportFormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	case ExportFormatJSONL:
+		contentType = "application/x-ndjson"
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format %q: must be %s or %s", format, ExportFormatCSV, ExportFormatJSONL), http.StatusBadRequest)
+		return
+	}
+
+	base := apiBaseURL(r)
+	rc := http.NewResponseController(w)
+
+	// the response starts with the first row, so that a failing query can still be reported with its status
+	var exporter itemExporter
+	rows := 0
+	start := func() error {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="items.%s"`, format))
+		if format == ExportFormatJSONL {
+			exporter = &jsonlItemExporter{enc: json.NewEncoder(w)}
+			return nil
+		}
+		var err error
+		exporter, err = newCSVItemExporter(w)
+		return err
+	}
+
+	user := userFromContext(ctx)
+	err := s.itemRepo.EachItem(ctx, query.Get("keyword"), func(item *Item) error {
+		if !canViewItem(user, item) {
+			return nil
+		}
+		if exporter == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		err := exporter.Write(&ExportedItem{
+			ID:            item.ID,
+			Name:          item.Name,
+			Category:      item.Category,
+			ImageFileName: item.ImageFileName,
+			ImageURL:      s.imageURL(base, item, item.ImageFileName),
+		})
+		if err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushInterval == 0 {
+			if err := exporter.Flush(); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if exporter == nil {
+			slog.Error("failed to export items", "error", err)
+			http.Error(w, err.Error(), repositoryErrorStatus(err))
+			return
+		}
+		// the response is already under way: the truncated body is all the client gets
+		slog.Error("export aborted", "rows", rows, "error", err)
+		return
+	}
+
+	if exporter == nil {
+		// no item matched: send the header row alone
+		if err := start(); err != nil {
+			slog.Error("failed to export items", "error", err)
+			return
+		}
+	}
+	if err := exporter.Flush(); err != nil {
+		slog.Error("failed to export items", "rows", rows, "error", err)
+		return
+	}
+	slog.Info("exported items", "format", format, "rows", rows)
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportItems(t *testing.T) {
+	t.Parallel()
+
+	items := []*Item{
+		{ID: 1, Name: "jacket", Category: "fashion", ImageFileName: "a.jpg"},
+		{ID: 2, Name: `used "iPhone", 16e`, Category: "phone", ImageFileName: "b.jpg"},
+	}
+	eachItem := func(keyword string) func(m *MockItemRepository) {
+		return func(m *MockItemRepository) {
+			m.EXPECT().
+				EachItem(gomock.Any(), keyword, gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ string, fn func(*Item) error) error {
+					for _, item := range items {
+						if err := fn(item); err != nil {
+							return err
+						}
+					}
+					return nil
+				}).Times(1)
+		}
+	}
+
+	type wants struct {
+		code        int
+		contentType string
+		body        string
+	}
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: csv by default": {
+			query:    "",
+			injector: eachItem(""),
+			wants: wants{
+				code:        http.StatusOK,
+				contentType: "text/csv; charset=utf-8",
+				body: "id,name,category,image_name,image_url\n" +
+					"1,jacket,fashion,a.jpg,http://example.com/images/a.jpg\n" +
+					"2,\"used \"\"iPhone\"\", 16e\",phone,b.jpg,http://example.com/images/b.jpg\n",
+			},
+		},
+		"ok: jsonl filtered by keyword": {
+			query:    "?format=jsonl&keyword=jacket",
+			injector: eachItem("jacket"),
+			wants: wants{
+				code:        http.StatusOK,
+				contentType: "application/x-ndjson",
+				body: `{"id":1,"name":"jacket","category":"fashion","image_name":"a.jpg","image_url":"http://example.com/images/a.jpg"}` + "\n" +
+					`{"id":2,"name":"used \"iPhone\", 16e","category":"phone","image_name":"b.jpg","image_url":"http://example.com/images/b.jpg"}` + "\n",
+			},
+		},
+		"ok: no item matched": {
+			query: "?format=csv&keyword=nothing",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().EachItem(gomock.Any(), "nothing", gomock.Any()).Return(nil).Times(1)
+			},
+			wants: wants{
+				code:        http.StatusOK,
+				contentType: "text/csv; charset=utf-8",
+				body:        "id,name,category,image_name,image_url\n",
+			},
+		},
+		"ng: unsupported format": {
+			query:    "?format=parquet",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:        http.StatusBadRequest,
+				contentType: "text/plain; charset=utf-8",
+				body:        "unsupported format \"parquet\": must be csv or jsonl\n",
+			},
+		},
+		"ng: query timed out": {
+			query: "?format=jsonl",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					EachItem(gomock.Any(), "", gomock.Any()).
+					Return(fmt.Errorf("%w: %w", errQueryTimeout, context.DeadlineExceeded)).Times(1)
+			},
+			wants: wants{
+				code:        http.StatusGatewayTimeout,
+				contentType: "text/plain; charset=utf-8",
+				body:        "query timed out: context deadline exceeded\n",
+			},
+		},
+		"ng: failed mid-stream": {
+			query: "?format=jsonl",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					EachItem(gomock.Any(), "", gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ string, fn func(*Item) error) error {
+						if err := fn(items[0]); err != nil {
+							return err
+						}
+						return errors.New("connection lost")
+					}).Times(1)
+			},
+			wants: wants{
+				// the status is sent with the first row; the body is cut short
+				code:        http.StatusOK,
+				contentType: "application/x-ndjson",
+				body:        `{"id":1,"name":"jacket","category":"fashion","image_name":"a.jpg","image_url":"http://example.com/images/a.jpg"}` + "\n",
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR, images: NewFileImageStore(t.TempDir())}
+
+			req := httptest.NewRequest("GET", "/items/export"+tt.query, nil)
+			res := httptest.NewRecorder()
+
+			h.ExportItems(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+			if got := res.Header().Get("Content-Type"); got != tt.wants.contentType {
+				t.Errorf("expected content type %q, got %q", tt.wants.contentType, got)
+			}
+			if diff := cmp.Diff(tt.wants.body, res.Body.String()); diff != "" {
+				t.Errorf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// immutableCacheControl is sent with content-addressed images, which never change.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// fallbackCacheControl is sent with the default image served in place of a missing one,
+	// so that an image uploaded later under that name is fetched.
+	fallbackCacheControl = "no-store"
+	// mutableCacheControl is sent with images whose names are not content hashes.
+	mutableCacheControl = "no-cache"
+)
+
+// isContentHash reports whether s is a hex encoded SHA-256 hash, as produced by putImage.
+func isContentHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// imageETag returns the strong ETag of image name served at width, or false when the name is
+// not a content hash and the image may change. The ETag is the hash, suffixed with the width
+// for variants, whether the variant or the narrower original is served: both never change.
+func imageETag(name string, width int) (string, bool) {
+	hash := strings.TrimSuffix(name, filepath.Ext(name))
+	if !isContentHash(hash) {
+		return "", false
+	}
+	if width > 0 {
+		return fmt.Sprintf(`"%s_w%d"`, hash, width), true
+	}
+	return `"` + hash + `"`, true
+}
+
+// etagMatches reports whether the If-None-Match header value ifNoneMatch lists etag, with the
+// weak comparison RFC 9110 prescribes for it. "*" is not taken as a match, as it is about any
+// current image: it is left to http.ServeContent, once the image is known to exist.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setImageCacheHeaders sets the caching headers of an image response: immutable with an ETag
+// for content-addressed images, and never stored for the default image served as a fallback.
+// An image served through a signed URL expiring at expires may only be kept by the client,
+// until then.
+func setImageCacheHeaders(h http.Header, etag string, immutable, fallback bool, expires time.Time) {
+	switch {
+	case fallback:
+		h.Set("Cache-Control", fallbackCacheControl)
+	case !expires.IsZero():
+		if immutable {
+			h.Set("ETag", etag)
+		}
+		h.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", max(int(time.Until(expires).Seconds()), 0)))
+	case immutable:
+		h.Set("ETag", etag)
+		h.Set("Cache-Control", immutableCacheControl)
+	default:
+		h.Set("Cache-Control", mutableCacheControl)
+	}
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImageETag(t *testing.T) {
+	t.Parallel()
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte("image")))
+	cases := map[string]struct {
+		name      string
+		width     int
+		want      string
+		immutable bool
+	}{
+		"ok: original":           {name: hash + ".jpg", want: `"` + hash + `"`, immutable: true},
+		"ok: variant":            {name: hash + ".webp", width: 300, want: `"` + hash + `_w300"`, immutable: true},
+		"ng: not a hash":         {name: "default.jpg"},
+		"ng: upper case hex":     {name: fmt.Sprintf("%X.jpg", sha256.Sum256([]byte("image")))},
+		"ng: too short for hash": {name: hash[:63] + ".jpg"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, immutable := imageETag(tt.name, tt.width)
+			if got != tt.want || immutable != tt.immutable {
+				t.Errorf("expected %q, %v, got %q, %v", tt.want, tt.immutable, got, immutable)
+			}
+		})
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		"ok: same":     {ifNoneMatch: `"abc"`, want: true},
+		"ok: in list":  {ifNoneMatch: `"xyz", "abc"`, want: true},
+		"ok: weak":     {ifNoneMatch: `W/"abc"`, want: true},
+		"ng: other":    {ifNoneMatch: `"xyz"`},
+		"ng: unquoted": {ifNoneMatch: `abc`},
+		"ng: none":     {ifNoneMatch: ``},
+		"ng: any":      {ifNoneMatch: `*`},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := etagMatches(tt.ifNoneMatch, `"abc"`); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetImageCaching(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	images := NewFileImageStore(t.TempDir())
+	image := testJPEG(t, 400, 300)
+	hash := fmt.Sprintf("%x", sha256.Sum256(image))
+	missing := fmt.Sprintf("%x", sha256.Sum256([]byte("missing")))
+	if err := images.Put(ctx, hash+".jpg", image); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+	if err := images.Put(ctx, defaultImageName, testJPEG(t, 2, 2)); err != nil {
+		t.Fatalf("failed to put default image: %v", err)
+	}
+	if err := images.Put(ctx, "legacy.jpg", testJPEG(t, 2, 2)); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+
+	type wants struct {
+		code         int
+		etag         string
+		cacheControl string
+		body         bool
+	}
+	cases := map[string]struct {
+		target      string
+		ifNoneMatch string
+		wants
+	}{
+		"ok: hashed image": {
+			target: "/images/" + hash + ".jpg",
+			wants:  wants{code: http.StatusOK, etag: `"` + hash + `"`, cacheControl: immutableCacheControl, body: true},
+		},
+		"ok: variant": {
+			target: "/images/" + hash + ".jpg?w=150",
+			wants:  wants{code: http.StatusOK, etag: `"` + hash + `_w150"`, cacheControl: immutableCacheControl, body: true},
+		},
+		"ok: not modified": {
+			target:      "/images/" + hash + ".jpg",
+			ifNoneMatch: `"` + hash + `"`,
+			wants:       wants{code: http.StatusNotModified, etag: `"` + hash + `"`, cacheControl: immutableCacheControl},
+		},
+		"ok: stale variant ETag": {
+			target:      "/images/" + hash + ".jpg?w=150",
+			ifNoneMatch: `"` + hash + `"`,
+			wants:       wants{code: http.StatusOK, etag: `"` + hash + `_w150"`, cacheControl: immutableCacheControl, body: true},
+		},
+		"ok: any copy of an existing image": {
+			target:      "/images/" + hash + ".jpg",
+			ifNoneMatch: `*`,
+			wants:       wants{code: http.StatusNotModified, etag: `"` + hash + `"`, cacheControl: immutableCacheControl},
+		},
+		"ok: ETag of a missing image": {
+			target:      "/images/" + missing + ".jpg",
+			ifNoneMatch: `"` + missing + `"`,
+			wants:       wants{code: http.StatusOK, cacheControl: fallbackCacheControl, body: true},
+		},
+		"ok: any copy of a missing image": {
+			target:      "/images/" + missing + ".jpg",
+			ifNoneMatch: `*`,
+			wants:       wants{code: http.StatusOK, cacheControl: fallbackCacheControl, body: true},
+		},
+		"ok: default image is not cached": {
+			target: "/images/" + missing + ".jpg",
+			wants:  wants{code: http.StatusOK, cacheControl: fallbackCacheControl, body: true},
+		},
+		"ok: name not a hash": {
+			target: "/images/legacy.jpg",
+			wants:  wants{code: http.StatusOK, cacheControl: mutableCacheControl, body: true},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{images: images, imageRepo: publicImageRepository(t)}
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.SetPathValue("filename", req.URL.Path[len(imagePathPrefix):])
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			res := httptest.NewRecorder()
+
+			h.GetImage(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+			if got := res.Header().Get("ETag"); got != tt.wants.etag {
+				t.Errorf("expected ETag %q, got %q", tt.wants.etag, got)
+			}
+			if got := res.Header().Get("Cache-Control"); got != tt.wants.cacheControl {
+				t.Errorf("expected Cache-Control %q, got %q", tt.wants.cacheControl, got)
+			}
+			if got := res.Body.Len() > 0; got != tt.wants.body {
+				t.Errorf("expected a body %v, got %d bytes", tt.wants.body, res.Body.Len())
+			}
+		})
+	}
+}
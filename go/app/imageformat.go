@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// errUnsupportedImage is returned when uploaded bytes are not an image in one of the supported formats.
+var errUnsupportedImage = errors.New("unsupported image format")
+
+// ImageFormat is an image encoding accepted for item images.
+type ImageFormat string
+
+const (
+	ImageFormatJPEG ImageFormat = "jpeg"
+	ImageFormatPNG  ImageFormat = "png"
+	ImageFormatGIF  ImageFormat = "gif"
+	ImageFormatWebP ImageFormat = "webp"
+)
+
+// imageFormats lists the file extension, content type and magic bytes of every supported format.
+// A nil byte in magic matches any byte.
+var imageFormats = []struct {
+	format      ImageFormat
+	extensions  []string // the first one is used when storing
+	contentType string
+	magic       [][]byte
+}{
+	{ImageFormatJPEG, []string{".jpg", ".jpeg"}, "image/jpeg", [][]byte{[]byte("\xff\xd8\xff")}},
+	{ImageFormatPNG, []string{".png"}, "image/png", [][]byte{[]byte("\x89PNG\r\n\x1a\n")}},
+	{ImageFormatGIF, []string{".gif"}, "image/gif", [][]byte{[]byte("GIF87a"), []byte("GIF89a")}},
+	{ImageFormatWebP, []string{".webp"}, "image/webp", [][]byte{[]byte("RIFF\x00\x00\x00\x00WEBP")}},
+}
+
+// detectImageFormat identifies the format of data from its leading magic bytes.
+func detectImageFormat(data []byte) (ImageFormat, bool) {
+	for _, f := range imageFormats {
+		for _, magic := range f.magic {
+			if hasMagic(data, magic) {
+				return f.format, true
+			}
+		}
+	}
+	return "", false
+}
+
+// hasMagic reports whether data starts with magic, where zero bytes in magic match anything
+// (the WebP signature embeds the file size).
+func hasMagic(data, magic []byte) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	for i, b := range magic {
+		if b != 0 && data[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// imageFormatFromExtension returns the format stored under the file extension ext, such as ".png".
+func imageFormatFromExtension(ext string) (ImageFormat, bool) {
+	ext = strings.ToLower(ext)
+	for _, f := range imageFormats {
+		for _, e := range f.extensions {
+			if e == ext {
+				return f.format, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Extension returns the file extension images of format f are stored with.
+func (f ImageFormat) Extension() string {
+	for _, known := range imageFormats {
+		if known.format == f {
+			return known.extensions[0]
+		}
+	}
+	return ""
+}
+
+// ContentType returns the MIME type images of format f are served with.
+func (f ImageFormat) ContentType() string {
+	for _, known := range imageFormats {
+		if known.format == f {
+			return known.contentType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// ImageMetadata describes a stored image.
+type ImageMetadata struct {
+	// Name is the file name of the image in the image directory.
+	Name   string      `json:"name"`
+	Format ImageFormat `json:"format"`
+	Width  int         `json:"width"`
+	Height int         `json:"height"`
+	// Size is the length of the file in bytes.
+	Size int64 `json:"size"`
+	// PHash is the perceptual hash of the image as 16 hex digits (see perceptualHash),
+	// empty for images stored before it was computed.
+	PHash string `json:"phash,omitempty"`
+	// BlurHash and DominantColor, as #rrggbb, are shown by clients while the image loads
+	// (see imagePlaceholder). They are empty for images stored before they were computed.
+	BlurHash      string `json:"blurhash,omitempty"`
+	DominantColor string `json:"dominant_color,omitempty"`
+}
+
+// describeImage decodes data, the content of image meta, and sets the fields of meta computed
+// from its pixels: its perceptual hash and its placeholder.
+func describeImage(meta *ImageMetadata, data []byte) error {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	meta.PHash = formatPHash(perceptualHash(img))
+	meta.BlurHash, meta.DominantColor = imagePlaceholder(img)
+	return nil
+}
+
+// inspectImage detects the format of data and reads its dimensions from the image header,
+// without decoding the pixels. It returns errUnsupportedImage for anything that is not
+// a well-formed image in a supported format. The returned metadata has no name yet.
+func inspectImage(data []byte) (*ImageMetadata, error) {
+	format, ok := detectImageFormat(data)
+	if !ok {
+		return nil, errUnsupportedImage
+	}
+	cfg, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s image: %v", errUnsupportedImage, format, err)
+	}
+	if decoded != string(format) {
+		return nil, fmt.Errorf("%w: %s signature but %s content", errUnsupportedImage, format, decoded)
+	}
+	return &ImageMetadata{
+		Format: format,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+		Size:   int64(len(data)),
+	}, nil
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testImage returns a w x h image with a gradient, so that encoders have something to compress.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(w, h)); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(w, h), nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func testGIF(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, testImage(w, h), nil); err != nil {
+		t.Fatalf("failed to encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testWebP returns the header of a lossless WebP image of w x h pixels.
+// There is no WebP encoder in the standard library, and the header is all DecodeConfig reads.
+func testWebP(w, h int) []byte {
+	bits := uint32(w-1) | uint32(h-1)<<14
+	vp8l := binary.LittleEndian.AppendUint32([]byte{0x2f}, bits)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+8+len(vp8l)))
+	buf.WriteString("WEBPVP8L")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(vp8l)))
+	buf.Write(vp8l)
+	return buf.Bytes()
+}
+
+func TestInspectImage(t *testing.T) {
+	t.Parallel()
+
+	jpegImage := testJPEG(t, 8, 6)
+	pngImage := testPNG(t, 5, 7)
+	gifImage := testGIF(t, 3, 2)
+	webpImage := testWebP(640, 480)
+
+	cases := map[string]struct {
+		data    []byte
+		want    *ImageMetadata
+		wantErr error
+	}{
+		"ok: jpeg": {
+			data: jpegImage,
+			want: &ImageMetadata{Format: ImageFormatJPEG, Width: 8, Height: 6, Size: int64(len(jpegImage))},
+		},
+		"ok: png": {
+			data: pngImage,
+			want: &ImageMetadata{Format: ImageFormatPNG, Width: 5, Height: 7, Size: int64(len(pngImage))},
+		},
+		"ok: gif": {
+			data: gifImage,
+			want: &ImageMetadata{Format: ImageFormatGIF, Width: 3, Height: 2, Size: int64(len(gifImage))},
+		},
+		"ok: webp": {
+			data: webpImage,
+			want: &ImageMetadata{Format: ImageFormatWebP, Width: 640, Height: 480, Size: int64(len(webpImage))},
+		},
+		"ng: text": {
+			data:    []byte("definitely not an image"),
+			wantErr: errUnsupportedImage,
+		},
+		"ng: empty": {
+			data:    nil,
+			wantErr: errUnsupportedImage,
+		},
+		"ng: truncated png": {
+			data:    pngImage[:12],
+			wantErr: errUnsupportedImage,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := inspectImage(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetImageContentType(t *testing.T) {
+	t.Parallel()
+
+	imgDirPath := t.TempDir()
+	files := map[string][]byte{
+		"a.png":  testPNG(t, 2, 2),
+		"b.webp": testWebP(2, 2),
+		"c.gif":  testGIF(t, 2, 2),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(imgDirPath, name), data, 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		fileName    string
+		code        int
+		contentType string
+	}{
+		"ok: png":                 {fileName: "a.png", code: http.StatusOK, contentType: "image/png"},
+		"ok: webp":                {fileName: "b.webp", code: http.StatusOK, contentType: "image/webp"},
+		"ok: gif":                 {fileName: "c.gif", code: http.StatusOK, contentType: "image/gif"},
+		"ng: unknown extension":   {fileName: "d.txt", code: http.StatusBadRequest},
+		"ng: directory traversal": {fileName: "../a.png", code: http.StatusBadRequest},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{images: NewFileImageStore(imgDirPath), imageRepo: publicImageRepository(t)}
+			req := httptest.NewRequest("GET", "/images/"+tt.fileName, nil)
+			req.SetPathValue("filename", tt.fileName)
+			res := httptest.NewRecorder()
+
+			h.GetImage(res, req)
+
+			if res.Code != tt.code {
+				t.Errorf("expected status code %d, got %d", tt.code, res.Code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			if got := res.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("expected content type %q, got %q", tt.contentType, got)
+			}
+			if !bytes.Equal(res.Body.Bytes(), files[tt.fileName]) {
+				t.Errorf("unexpected body for %s", tt.fileName)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultImageName is the placeholder image served when an image is missing.
+// It is never referenced by items and never collected.
+const defaultImageName = "default.jpg"
+
+// ImageProblem describes a referenced image that is missing or damaged.
+type ImageProblem struct {
+	Name    string `json:"name"`
+	Problem string `json:"problem"`
+}
+
+// referencedImageNames returns the distinct image file names referenced by items, sorted.
+// Both the cover images and the images listed in item_images count.
+func referencedImageNames(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT image_name FROM items WHERE image_name IS NOT NULL AND image_name != ''
+		UNION
+		SELECT image_name FROM item_images`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referenced images: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan image name: %w", err)
+		}
+		// image names are plain file names; anything else would escape the image directory
+		if name != filepath.Base(name) {
+			slog.Warn("skipping image with invalid name", "name", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list referenced images: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ImageGCOptions configures a garbage collection of the image directory.
+type ImageGCOptions struct {
+	// GracePeriod spares unreferenced files modified more recently than that, such as an
+	// image just stored for an item not inserted yet.
+	GracePeriod time.Duration
+	// DryRun only reports what would be removed.
+	DryRun bool
+}
+
+// ImageGCConfig schedules the garbage collection run by the server in the background.
+type ImageGCConfig struct {
+	// Interval is the time between two collections. Zero disables the background collection.
+	Interval    time.Duration
+	GracePeriod time.Duration
+}
+
+// ImageGCFile is a file removed by a garbage collection, or that would be with DryRun.
+type ImageGCFile struct {
+	// Name is the path of the file relative to the image directory.
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// ImageGCReport describes the outcome of a garbage collection.
+type ImageGCReport struct {
+	DryRun  bool          `json:"dry_run"`
+	Removed []ImageGCFile `json:"removed"`
+	// Bytes is the total size of the removed files.
+	Bytes int64 `json:"bytes"`
+	// Spared counts the unreferenced files kept because of the grace period.
+	Spared int `json:"spared"`
+}
+
+// collectImageGarbage deletes the files in imgDirPath that no item references, along with
+// the resized variants of those images, unless they were modified within the grace period.
+// The report lists what was removed, even when an error interrupts the collection.
+func collectImageGarbage(ctx context.Context, db *sql.DB, imgDirPath string, opts ImageGCOptions) (*ImageGCReport, error) {
+	report := &ImageGCReport{DryRun: opts.DryRun}
+	referenced, err := referencedImageNames(ctx, db)
+	if err != nil {
+		return report, err
+	}
+	keep := make(map[string]bool, len(referenced)+1)
+	for _, name := range referenced {
+		keep[name] = true
+	}
+	keep[defaultImageName] = true
+	cutoff := time.Now().Add(-opts.GracePeriod)
+
+	err = sweepImageDir(ctx, imgDirPath, "", cutoff, opts.DryRun, report, func(name string) bool {
+		return keep[name]
+	})
+	if err != nil {
+		return report, err
+	}
+
+	stems := make(map[string]bool, len(keep))
+	for name := range keep {
+		stems[strings.TrimSuffix(name, filepath.Ext(name))] = true
+	}
+	err = sweepImageDir(ctx, filepath.Join(imgDirPath, variantDirName), variantDirName, cutoff, opts.DryRun, report, func(name string) bool {
+		source, ok := variantSource(name)
+		return ok && stems[source]
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		// no variant was generated yet
+		return report, nil
+	}
+	return report, err
+}
+
+// sweepImageDir removes the files of dir that keep rejects and that were last modified
+// before cutoff, and adds them to report under prefix. With dryRun, nothing is removed.
+func sweepImageDir(ctx context.Context, dir, prefix string, cutoff time.Time, dryRun bool, report *ImageGCReport, keep func(name string) bool) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("image directory %s does not exist: %w", dir, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read image directory: %w", err)
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// skip directories and hidden files such as .gitignore, staging directories
+		// or images being written under temporary names
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") || keep(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// removed in the meantime
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat image %s: %w", e.Name(), err)
+		}
+		if info.ModTime().After(cutoff) {
+			report.Spared++
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove image %s: %w", e.Name(), err)
+			}
+		}
+		report.Removed = append(report.Removed, ImageGCFile{
+			Name:    path.Join(prefix, e.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		report.Bytes += info.Size()
+	}
+	return nil
+}
+
+// runImageGC collects the garbage of imgDirPath every cfg.Interval until ctx is done.
+func runImageGC(ctx context.Context, db *sql.DB, imgDirPath string, cfg ImageGCConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := collectImageGarbage(ctx, db, imgDirPath, ImageGCOptions{GracePeriod: cfg.GracePeriod})
+		for _, f := range report.Removed {
+			slog.Info("removed unreferenced image", "name", f.Name, "size", f.Size, "mod_time", f.ModTime)
+		}
+		if err != nil {
+			slog.Error("failed to collect image garbage", "error", err)
+			continue
+		}
+		slog.Info("collected image garbage", "removed", len(report.Removed), "bytes", report.Bytes, "spared", report.Spared)
+	}
+}
+
+// verifyImages checks that every referenced image exists in imgDirPath and, for
+// content-addressed names (<sha256>.<ext>), that its content still matches its name.
+func verifyImages(ctx context.Context, db *sql.DB, imgDirPath string) ([]ImageProblem, error) {
+	referenced, err := referencedImageNames(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []ImageProblem
+	for _, name := range referenced {
+		if problem := verifyImage(filepath.Join(imgDirPath, name)); problem != "" {
+			problems = append(problems, ImageProblem{Name: name, Problem: problem})
+		}
+	}
+	return problems, nil
+}
+
+// verifyImage returns a description of what is wrong with the image at p, or "" if nothing is.
+func verifyImage(p string) string {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return "missing"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err.Error()
+	}
+	base := filepath.Base(p)
+	hash := strings.TrimSuffix(base, filepath.Ext(base))
+	if len(hash) != sha256.Size*2 {
+		// not a content-addressed name, nothing to compare against
+		return ""
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != hash {
+		return fmt.Sprintf("checksum mismatch: content hashes to %s", sum)
+	}
+	return ""
+}
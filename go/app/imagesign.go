@@ -0,0 +1,150 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultImageURLTTL is how long a signed image URL stays valid.
+const DefaultImageURLTTL = 15 * time.Minute
+
+// query parameters of a signed image URL
+const (
+	imageURLExpiresParam   = "expires"
+	imageURLSignatureParam = "signature"
+)
+
+var (
+	errImageURLUnsigned  = errors.New("this image requires a signed URL")
+	errImageURLSignature = errors.New("invalid image URL signature")
+	errImageURLExpired   = errors.New("image URL expired")
+)
+
+// imageURLSigner signs and verifies image URLs with HMAC-SHA256. A signed URL carries its
+// expiry time and the signature of the image name and that time as query parameters, so that
+// it grants access to that image, at any width, until it expires.
+type imageURLSigner struct {
+	key []byte
+	ttl time.Duration
+	// now returns the current time; tests replace it.
+	now func() time.Time
+}
+
+// newImageURLSigner returns a signer issuing URLs valid for ttl, or DefaultImageURLTTL when
+// ttl is zero. Without a key, a random one is generated: the URLs it signs then stop working
+// when the process exits, and are rejected by other replicas.
+func newImageURLSigner(key []byte, ttl time.Duration) (*imageURLSigner, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate image URL key: %w", err)
+		}
+	}
+	if ttl <= 0 {
+		ttl = DefaultImageURLTTL
+	}
+	return &imageURLSigner{key: key, ttl: ttl, now: time.Now}, nil
+}
+
+func (s *imageURLSigner) signature(name string, expires int64) string {
+	mac := hmacSHA256(s.key, name+"\n"+strconv.FormatInt(expires, 10))
+	return base64.RawURLEncoding.EncodeToString(mac)
+}
+
+// Sign returns the path the API serves image name under, signed to expire after the TTL.
+func (s *imageURLSigner) Sign(name string) string {
+	expires := s.now().Add(s.ttl).Unix()
+	query := url.Values{
+		imageURLExpiresParam:   {strconv.FormatInt(expires, 10)},
+		imageURLSignatureParam: {s.signature(name, expires)},
+	}
+	return apiImageURL(name) + "?" + query.Encode()
+}
+
+// Verify checks the signature in the query of a request for image name, and returns when it
+// expires. It returns errImageURLUnsigned when the query carries no signature.
+func (s *imageURLSigner) Verify(name string, query url.Values) (time.Time, error) {
+	sig, exp := query.Get(imageURLSignatureParam), query.Get(imageURLExpiresParam)
+	if sig == "" && exp == "" {
+		return time.Time{}, errImageURLUnsigned
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return time.Time{}, errImageURLSignature
+	}
+	// compare the signatures before the time, so that a forged URL is never told it expired
+	if !hmac.Equal([]byte(sig), []byte(s.signature(name, expires))) {
+		return time.Time{}, errImageURLSignature
+	}
+	expiresAt := time.Unix(expires, 0)
+	if !s.now().Before(expiresAt) {
+		return time.Time{}, errImageURLExpired
+	}
+	return expiresAt, nil
+}
+
+// itemImageURL returns where clients fetch image name of item from: the URL given by the image
+// store for public items, and a signed URL served by the API otherwise, since the store may be
+// publicly readable. Hidden items are not public. The URL is empty for an item that is not
+// public when no signer is configured.
+func (s *Handlers) itemImageURL(item *Item, name string) string {
+	if !item.Hidden && (item.Status == ItemStatusPublic || item.Status == "") {
+		return s.images.URL(name)
+	}
+	if s.imageURLs == nil {
+		return ""
+	}
+	return s.imageURLs.Sign(name)
+}
+
+// setImageURLs sets the ImageURL and ImageURLs of items before they are sent to a client.
+func (s *Handlers) setImageURLs(items ...*Item) {
+	for _, item := range items {
+		item.ImageURL = s.itemImageURL(item, item.ImageFileName)
+		item.ImageURLs = make([]string, len(item.Images))
+		for i, name := range item.Images {
+			item.ImageURLs[i] = s.itemImageURL(item, name)
+		}
+	}
+}
+
+// authorizeImage checks that the client of r may fetch image name. A valid signature grants
+// access until the returned expiry time, which is zero for unsigned requests of images that
+// are not private.
+func (s *Handlers) authorizeImage(r *http.Request, name string) (time.Time, error) {
+	query := r.URL.Query()
+	if s.imageURLs != nil {
+		expires, err := s.imageURLs.Verify(name, query)
+		if !errors.Is(err, errImageURLUnsigned) {
+			return expires, err
+		}
+	} else if query.Has(imageURLSignatureParam) {
+		return time.Time{}, errImageURLSignature
+	}
+
+	private, err := s.imageRepo.IsPrivate(r.Context(), name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if private {
+		return time.Time{}, errImageURLUnsigned
+	}
+	return time.Time{}, nil
+}
+
+// imageAuthorizationStatus maps an error returned by authorizeImage to an HTTP status code.
+func imageAuthorizationStatus(err error) int {
+	switch {
+	case errors.Is(err, errImageURLUnsigned), errors.Is(err, errImageURLSignature), errors.Is(err, errImageURLExpired):
+		return http.StatusForbidden
+	default:
+		return repositoryErrorStatus(err)
+	}
+}
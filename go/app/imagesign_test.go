@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+// publicImageRepository returns an ImageRepository for which every image is public.
+func publicImageRepository(t *testing.T) ImageRepository {
+	t.Helper()
+	m := NewMockImageRepository(gomock.NewController(t))
+	m.EXPECT().IsPrivate(gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	return m
+}
+
+// testImageURLSigner returns a signer with a fixed key whose clock reads now.
+func testImageURLSigner(t *testing.T, now time.Time) *imageURLSigner {
+	t.Helper()
+	s, err := newImageURLSigner([]byte("test key"), time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestImageURLSigner(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	signer := testImageURLSigner(t, now)
+	signed, err := url.Parse(signer.Sign("a.jpg"))
+	if err != nil {
+		t.Fatalf("failed to parse signed URL: %v", err)
+	}
+	if signed.Path != "/images/a.jpg" {
+		t.Errorf("expected the API path of the image, got %s", signed.Path)
+	}
+	query := signed.Query()
+
+	other, err := newImageURLSigner(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	if other.ttl != DefaultImageURLTTL {
+		t.Errorf("expected the default TTL, got %v", other.ttl)
+	}
+	other.now = signer.now
+
+	with := func(key, value string) url.Values {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set(key, value)
+		return q
+	}
+	cases := map[string]struct {
+		signer  *imageURLSigner
+		name    string
+		query   url.Values
+		wantErr error
+	}{
+		"ok: signed":                {signer: signer, name: "a.jpg", query: query},
+		"ok: variant":               {signer: signer, name: "a.jpg", query: with("w", "150")},
+		"ng: unsigned":              {signer: signer, name: "a.jpg", query: url.Values{}, wantErr: errImageURLUnsigned},
+		"ng: other image":           {signer: signer, name: "b.jpg", query: query, wantErr: errImageURLSignature},
+		"ng: extended expiry":       {signer: signer, name: "a.jpg", query: with(imageURLExpiresParam, fmt.Sprint(now.Add(time.Hour).Unix())), wantErr: errImageURLSignature},
+		"ng: malformed expiry":      {signer: signer, name: "a.jpg", query: with(imageURLExpiresParam, "soon"), wantErr: errImageURLSignature},
+		"ng: missing signature":     {signer: signer, name: "a.jpg", query: with(imageURLSignatureParam, ""), wantErr: errImageURLSignature},
+		"ng: signed with other key": {signer: other, name: "a.jpg", query: query, wantErr: errImageURLSignature},
+		"ng: expired":               {signer: testImageURLSigner(t, now.Add(time.Minute)), name: "a.jpg", query: query, wantErr: errImageURLExpired},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			expires, err := tt.signer.Verify(tt.name, tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && !expires.Equal(now.Add(time.Minute)) {
+				t.Errorf("expected expiry %v, got %v", now.Add(time.Minute), expires)
+			}
+		})
+	}
+}
+
+func TestSetImageURLs(t *testing.T) {
+	t.Parallel()
+
+	signer := testImageURLSigner(t, time.Now())
+	h := &Handlers{images: NewFileImageStore(t.TempDir()), imageURLs: signer}
+	public := &Item{Status: ItemStatusPublic, ImageFileName: "a.jpg", Images: []string{"a.jpg", "b.jpg"}}
+	draft := &Item{Status: ItemStatusDraft, ImageFileName: "c.jpg", Images: []string{"c.jpg"}}
+	h.setImageURLs(public, draft)
+
+	if public.ImageURL != "/images/a.jpg" || public.ImageURLs[1] != "/images/b.jpg" {
+		t.Errorf("expected plain URLs for a public item, got %s, %v", public.ImageURL, public.ImageURLs)
+	}
+	u, err := url.Parse(draft.ImageURL)
+	if err != nil {
+		t.Fatalf("failed to parse signed URL: %v", err)
+	}
+	if _, err := signer.Verify("c.jpg", u.Query()); err != nil || u.Path != "/images/c.jpg" {
+		t.Errorf("expected a signed URL for a draft item, got %s: %v", draft.ImageURL, err)
+	}
+
+	// without a signer, nothing would let the client fetch the images
+	reserved := &Item{Status: ItemStatusReserved, ImageFileName: "c.jpg", Images: []string{"c.jpg"}}
+	(&Handlers{images: h.images}).setImageURLs(reserved)
+	if reserved.ImageURL != "" {
+		t.Errorf("expected no URL without a signer, got %s", reserved.ImageURL)
+	}
+}
+
+func TestGetImageSigned(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	images := NewFileImageStore(t.TempDir())
+	image := testJPEG(t, 400, 300)
+	hash := fmt.Sprintf("%x", sha256.Sum256(image))
+	name := hash + ".jpg"
+	if err := images.Put(ctx, name, image); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+	signer := testImageURLSigner(t, time.Now())
+	expired := testImageURLSigner(t, time.Now().Add(-time.Hour))
+
+	type wants struct {
+		code         int
+		cacheControl string
+	}
+	cases := map[string]struct {
+		target    string
+		private   bool
+		lookupErr error
+		wants
+	}{
+		"ok: public image": {
+			target: "/images/" + name,
+			wants:  wants{code: http.StatusOK, cacheControl: immutableCacheControl},
+		},
+		"ok: private image with signed URL": {
+			target:  signer.Sign(name),
+			private: true,
+			wants:   wants{code: http.StatusOK, cacheControl: "private, max-age="},
+		},
+		"ok: variant with signed URL": {
+			target:  signer.Sign(name) + "&w=150",
+			private: true,
+			wants:   wants{code: http.StatusOK, cacheControl: "private, max-age="},
+		},
+		"ng: private image without signature": {
+			target:  "/images/" + name,
+			private: true,
+			wants:   wants{code: http.StatusForbidden},
+		},
+		"ng: expired URL": {
+			target:  expired.Sign(name),
+			private: true,
+			wants:   wants{code: http.StatusForbidden},
+		},
+		"ng: forged signature": {
+			target: strings.Replace(signer.Sign(name), "signature=", "signature=x", 1),
+			wants:  wants{code: http.StatusForbidden},
+		},
+		"ng: visibility lookup failed": {
+			target:    "/images/" + name,
+			lookupErr: errQueryTimeout,
+			wants:     wants{code: http.StatusGatewayTimeout},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockImR := NewMockImageRepository(ctrl)
+			mockImR.EXPECT().IsPrivate(gomock.Any(), gomock.Any()).Return(tt.private, tt.lookupErr).AnyTimes()
+			h := &Handlers{images: images, imageRepo: mockImR, imageURLs: signer}
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.SetPathValue("filename", req.URL.Path[len(imagePathPrefix):])
+			res := httptest.NewRecorder()
+
+			h.GetImage(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			// the max-age of signed URLs depends on the time the test took
+			if got := res.Header().Get("Cache-Control"); !strings.HasPrefix(got, tt.wants.cacheControl) {
+				t.Errorf("expected Cache-Control %q, got %q", tt.wants.cacheControl, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImageStore stores the image files. Keys are slash-separated relative paths, such as
+// <sha256>.jpg for an uploaded image or variants/<sha256>_w300.jpg for one of its variants.
+// Get returns an error wrapping errImageNotFound for a key that is not stored.
+type ImageStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// URL returns the URL clients fetch the image from, which is relative to the API
+	// unless the store serves its files itself.
+	URL(key string) string
+}
+
+// imagePathPrefix is the path under which the API serves images.
+const imagePathPrefix = "/images/"
+
+// checkImageKey rejects keys that are not clean relative paths, so that no store
+// can be made to read or write outside of its root.
+func checkImageKey(key string) error {
+	if key == "" || path.Clean(key) != key || path.IsAbs(key) || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid image key: %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		// this also rejects "..": hidden names are reserved for temporary files
+		if strings.HasPrefix(part, ".") {
+			return fmt.Errorf("invalid image key: %q", key)
+		}
+	}
+	return nil
+}
+
+// apiImageURL returns the path under which the API serves the image key.
+func apiImageURL(key string) string {
+	return imagePathPrefix + (&url.URL{Path: key}).EscapedPath()
+}
+
+// fileImageStore stores images as files under a directory of the local file system.
+type fileImageStore struct {
+	dir string
+}
+
+// NewFileImageStore returns an ImageStore keeping the images in dir,
+// which is created on the first Put if needed.
+func NewFileImageStore(dir string) ImageStore {
+	return &fileImageStore{dir: dir}
+}
+
+func (s *fileImageStore) path(key string) (string, error) {
+	if err := checkImageKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes data to a temporary file first and renames it into place once it is synced,
+// so that neither readers nor a crash ever leave a partial image under key.
+func (s *fileImageStore) Put(ctx context.Context, key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create image directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".image-*")
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write image %s: %w", key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync image %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write image %s: %w", key, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write image %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to write image %s: %w", key, err)
+	}
+	// persist the rename itself
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync image directory: %w", err)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *fileImageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", errImageNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", key, err)
+	}
+	return data, nil
+}
+
+func (s *fileImageStore) Exists(ctx context.Context, key string) (bool, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat image %s: %w", key, err)
+	}
+	return info.Mode().IsRegular(), nil
+}
+
+// Delete removes the image key. Deleting a missing image is not an error.
+func (s *fileImageStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove image %s: %w", key, err)
+	}
+	return nil
+}
+
+// touch sets the modification time of the image under key to now. The garbage collection
+// spares recently modified files, so this keeps a reused image from being collected before
+// the item referencing it is saved. It returns an error wrapping os.ErrNotExist if the image
+// was removed in the meantime.
+func (s *fileImageStore) touch(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); err != nil {
+		return fmt.Errorf("failed to touch image %s: %w", key, err)
+	}
+	return nil
+}
+
+// URL returns the path the API serves key under, since the files are not reachable otherwise.
+func (s *fileImageStore) URL(key string) string {
+	return apiImageURL(key)
+}
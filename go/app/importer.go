@@ -0,0 +1,496 @@
+package app
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// importBatchSize is the number of items inserted per transaction.
+	importBatchSize = 100
+	// importFetchTimeout bounds the download of an image given by URL.
+	importFetchTimeout = 10 * time.Second
+	// maxImportRedirects is how many redirects are followed when fetching an image by URL.
+	maxImportRedirects = 3
+)
+
+// errImportURLDisabled is returned for a row whose image is a URL when the importer may not fetch URLs.
+var errImportURLDisabled = errors.New("images given by URL are not accepted: put the image in the archive instead")
+
+// Import file formats.
+const (
+	ImportFormatCSV   = "csv"
+	ImportFormatJSONL = "jsonl"
+)
+
+// ImportResult is the outcome of importing one row. Rows are numbered from 1,
+// not counting the CSV header.
+type ImportResult struct {
+	Row    int    `json:"row"`
+	Name   string `json:"name,omitempty"`
+	ItemID int    `json:"item_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarizes a bulk import.
+type ImportReport struct {
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+// importRow is a listing read from an import file. Image is either a path inside
+// the accompanying zip archive or an http(s) URL.
+type importRow struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Image    string `json:"image"`
+	// err is set when the row could not be parsed.
+	err error
+}
+
+// UnmarshalJSON accepts the category either as a string or as a number.
+func (row *importRow) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name     string          `json:"name"`
+		Category json.RawMessage `json:"category"`
+		Image    string          `json:"image"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	row.Name, row.Image = raw.Name, raw.Image
+	if len(raw.Category) == 0 || string(raw.Category) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Category, &row.Category); err == nil {
+		return nil
+	}
+	var id json.Number
+	if err := json.Unmarshal(raw.Category, &id); err != nil {
+		return errors.New("category must be a string or a number")
+	}
+	row.Category = id.String()
+	return nil
+}
+
+// ImportFormatFromName guesses the import format from a file name.
+func ImportFormatFromName(name string) (string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return ImportFormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return ImportFormatJSONL, nil
+	default:
+		return "", fmt.Errorf("cannot tell the format of %s; use csv or jsonl", name)
+	}
+}
+
+// readImportRows reads every row of r. A malformed row is returned with its err set,
+// so that it is reported without stopping the import.
+func readImportRows(r io.Reader, format string) ([]importRow, error) {
+	switch format {
+	case ImportFormatCSV:
+		return readImportCSV(r)
+	case ImportFormatJSONL:
+		return readImportJSONL(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format: %q", format)
+	}
+}
+
+// readImportCSV reads a CSV file whose header names the name, category and image columns.
+func readImportCSV(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range []string{"name", "category", "image"} {
+		if _, ok := columns[c]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", c)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{err: err})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		rows = append(rows, importRow{
+			Name:     field(record, "name"),
+			Category: field(record, "category"),
+			Image:    field(record, "image"),
+		})
+	}
+	return rows, nil
+}
+
+// readImportJSONL reads one JSON object per line. Blank lines are skipped.
+func readImportJSONL(r io.Reader) ([]importRow, error) {
+	dec := json.NewDecoder(r)
+	var rows []importRow
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// the decoder cannot resynchronize after a syntax error
+			rows = append(rows, importRow{err: fmt.Errorf("invalid JSON: %w", err)})
+			break
+		}
+		var row importRow
+		if err := json.Unmarshal(raw, &row); err != nil {
+			row = importRow{err: fmt.Errorf("invalid row: %w", err)}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// itemImporter validates import rows like AddItem does and inserts them in batches.
+type itemImporter struct {
+	itemRepo     ItemRepository
+	categoryRepo CategoryRepository
+	// storeImages stores the images of a row and returns the function rolling them back when
+	// the row cannot be inserted, as Handlers.storeImages does.
+	storeImages func(ctx context.Context, images [][]byte) ([]string, func(), error)
+	// images holds the files referenced by path. It may be nil when every image is a URL.
+	images *zip.Reader
+	// client fetches the images given by URL. They are refused when it is nil.
+	client *http.Client
+	// maxImageBytes is the largest image accepted per row, the body limit of AddItem.
+	maxImageBytes int64
+	batchSize     int
+	// sellerID is the user the items are listed for, zero for imports by the admin CLI.
+	sellerID int
+}
+
+func newItemImporter(itemRepo ItemRepository, categoryRepo CategoryRepository, storeImages func(context.Context, [][]byte) ([]string, func(), error), images *zip.Reader, limits UploadLimits) *itemImporter {
+	return &itemImporter{
+		itemRepo:      itemRepo,
+		categoryRepo:  categoryRepo,
+		storeImages:   storeImages,
+		images:        images,
+		client:        &http.Client{Timeout: importFetchTimeout},
+		maxImageBytes: limits.withDefaults().MaxBodyBytes,
+		batchSize:     importBatchSize,
+	}
+}
+
+// pendingItem is a validated row waiting for its batch to be inserted.
+type pendingItem struct {
+	result   int // index into the report results
+	item     *Item
+	rollback func()
+}
+
+// Import imports rows and reports the outcome of each one.
+// The returned error is set only when the import could not run at all.
+func (im *itemImporter) Import(ctx context.Context, rows []importRow) (*ImportReport, error) {
+	categories, err := im.categoryRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	categoryIDs := make(map[string]string, len(categories)*2)
+	for _, c := range categories {
+		id := strconv.Itoa(c.ID)
+		categoryIDs[id] = id
+		categoryIDs[strings.ToLower(c.Name)] = id
+	}
+
+	report := &ImportReport{Results: make([]ImportResult, len(rows))}
+	var batch []pendingItem
+	for i, row := range rows {
+		report.Results[i] = ImportResult{Row: i + 1, Name: row.Name}
+		item, rollback, err := im.prepare(ctx, row, categoryIDs)
+		if err != nil {
+			report.Results[i].Error = err.Error()
+			continue
+		}
+		batch = append(batch, pendingItem{result: i, item: item, rollback: rollback})
+		if len(batch) == im.batchSize {
+			im.flush(ctx, batch, report)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		im.flush(ctx, batch, report)
+	}
+
+	for _, r := range report.Results {
+		if r.Error != "" {
+			report.Failed++
+		} else {
+			report.Imported++
+		}
+	}
+	return report, nil
+}
+
+// prepare validates row with the rules of AddItem, stores its image and returns the item to
+// insert, along with the function removing the image if the item cannot be inserted.
+func (im *itemImporter) prepare(ctx context.Context, row importRow, categoryIDs map[string]string) (*Item, func(), error) {
+	if row.err != nil {
+		return nil, nil, row.err
+	}
+	req := &AddItemRequest{Name: row.Name, Category: row.Category}
+	if row.Image != "" {
+		image, err := im.loadImage(ctx, row.Image)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Images = [][]byte{image}
+	}
+	if err := validateAddItemRequest(req); err != nil {
+		return nil, nil, err
+	}
+
+	categoryID, ok := categoryIDs[strings.ToLower(req.Category)]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown category: %s", req.Category)
+	}
+
+	names, rollback, err := im.storeImages(ctx, req.Images[:1])
+	if errors.Is(err, errUnsupportedImage) || errors.Is(err, errImageTooLarge) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to save image: %w", err)
+	}
+	return &Item{Name: req.Name, Category: categoryID, ImageFileName: names[0], SellerID: im.sellerID}, rollback, nil
+}
+
+// flush inserts batch in one transaction and records the outcome of each of its rows.
+// If the batch fails, the images stored for its rows are rolled back.
+func (im *itemImporter) flush(ctx context.Context, batch []pendingItem, report *ImportReport) {
+	items := make([]*Item, len(batch))
+	for i, p := range batch {
+		items[i] = p.item
+	}
+	err := im.itemRepo.InsertBatch(ctx, items)
+	for _, p := range batch {
+		if err != nil {
+			report.Results[p.result].Error = fmt.Sprintf("batch insert failed: %v", err)
+			p.rollback()
+			continue
+		}
+		report.Results[p.result].ItemID = p.item.ID
+	}
+	if err != nil {
+		slog.Warn("failed to insert import batch", "size", len(batch), "error", err)
+	}
+}
+
+// loadImage reads the image referenced by ref, either from the URL or from the zip archive.
+func (im *itemImporter) loadImage(ctx context.Context, ref string) ([]byte, error) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return im.fetchImage(ctx, ref)
+	}
+	if im.images == nil {
+		return nil, fmt.Errorf("image %s is a file path but no image archive was uploaded", ref)
+	}
+
+	name := path.Clean(strings.TrimPrefix(filepath.ToSlash(ref), "/"))
+	f, err := im.images.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("image %s not found in archive", ref)
+	}
+	defer f.Close()
+	return im.readImage(f, ref)
+}
+
+func (im *itemImporter) fetchImage(ctx context.Context, url string) ([]byte, error) {
+	if im.client == nil {
+		return nil, errImportURLDisabled
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image URL %s: %w", url, err)
+	}
+	res, err := im.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image %s: %w", url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image %s: %s", url, res.Status)
+	}
+	return im.readImage(res.Body, url)
+}
+
+// newImportClient returns the client POST /items/import fetches images with, or nil when hosts
+// is empty and images given by URL are refused. The rows come from clients, so that the server
+// cannot be made to reach its own network, the client only fetches from hosts, re-checking them
+// on every redirect, and never connects to loopback, private or link-local addresses, whatever
+// the names resolve to.
+func newImportClient(hosts []string) *http.Client {
+	if len(hosts) == 0 {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would connect on our behalf, out of reach of the address check
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: importFetchTimeout, Control: refuseInternalAddress}).DialContext
+	return importClient(hosts, transport)
+}
+
+// importClient returns a client sending requests through transport to hosts only.
+func importClient(hosts []string, transport http.RoundTripper) *http.Client {
+	allowed := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		allowed[strings.ToLower(h)] = true
+	}
+	return &http.Client{
+		Timeout: importFetchTimeout,
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if !allowed[strings.ToLower(r.URL.Hostname())] {
+				return nil, fmt.Errorf("images are not fetched from host %s", r.URL.Hostname())
+			}
+			return transport.RoundTrip(r)
+		}),
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) > maxImportRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// refuseInternalAddress is a net.Dialer control function refusing to connect to the addresses
+// of the server's own network: loopback, private, link-local (such as the cloud metadata
+// endpoint 169.254.169.254), unspecified and multicast ones.
+func refuseInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", host, err)
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("refusing to connect to internal address %s", ip)
+	}
+	return nil
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// readImage reads an image of at most maxImageBytes from r.
+func (im *itemImporter) readImage(r io.Reader, ref string) ([]byte, error) {
+	image, err := io.ReadAll(io.LimitReader(r, im.maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", ref, err)
+	}
+	if int64(len(image)) > im.maxImageBytes {
+		return nil, fmt.Errorf("image %s is larger than %d bytes", ref, im.maxImageBytes)
+	}
+	return image, nil
+}
+
+// ImportItems handles POST /items/import. The multipart form carries the listings in the
+// "file" part (CSV or JSON Lines, chosen by the "format" field or the file extension) and
+// optionally a zip archive of the images referenced by path in the "images" part.
+// It responds with a per-row report.
+func (s *Handlers) ImportItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	s.limitUploadBody(w, r)
+	if err := r.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		writeUploadError(w, fmt.Errorf("failed to parse multipart form: %w", err))
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		if format, err = ImportFormatFromName(header.Filename); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	rows, err := readImportRows(file, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var images *zip.Reader
+	if archive, archiveHeader, err := r.FormFile("images"); err == nil {
+		defer archive.Close()
+		if images, err = zip.NewReader(archive, archiveHeader.Size); err != nil {
+			http.Error(w, fmt.Sprintf("images must be a zip archive: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	importer := newItemImporter(s.itemRepo, s.categoryRepo, s.storeImages, images, s.uploads)
+	importer.client = s.importClient
+	if user := userFromContext(ctx); user != nil {
+		importer.sellerID = user.ID
+	}
+	report, err := importer.Import(ctx, rows)
+	if err != nil {
+		slog.Error("failed to import items", "error", err)
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
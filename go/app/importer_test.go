@@ -0,0 +1,389 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestReadImportRows(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		format  string
+		input   string
+		want    []importRow
+		wantErr bool
+	}{
+		"ok: csv": {
+			format: ImportFormatCSV,
+			input:  "name,category,image\njacket,fashion,images/jacket.jpg\n\"used iPhone, 16e\",2,https://example.com/phone.jpg\n",
+			want: []importRow{
+				{Name: "jacket", Category: "fashion", Image: "images/jacket.jpg"},
+				{Name: "used iPhone, 16e", Category: "2", Image: "https://example.com/phone.jpg"},
+			},
+		},
+		"ok: csv with reordered columns": {
+			format: ImportFormatCSV,
+			input:  "image,name,category\njacket.jpg,jacket,fashion\n",
+			want:   []importRow{{Name: "jacket", Category: "fashion", Image: "jacket.jpg"}},
+		},
+		"ok: jsonl with numeric category": {
+			format: ImportFormatJSONL,
+			input:  "{\"name\":\"jacket\",\"category\":\"fashion\",\"image\":\"jacket.jpg\"}\n\n{\"name\":\"phone\",\"category\":2,\"image\":\"phone.jpg\"}\n",
+			want: []importRow{
+				{Name: "jacket", Category: "fashion", Image: "jacket.jpg"},
+				{Name: "phone", Category: "2", Image: "phone.jpg"},
+			},
+		},
+		"ng: csv without image column": {
+			format:  ImportFormatCSV,
+			input:   "name,category\njacket,fashion\n",
+			wantErr: true,
+		},
+		"ng: unknown format": {
+			format:  "xml",
+			input:   "<items/>",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := readImportRows(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatalf("expected an error")
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreUnexported(importRow{})); diff != "" {
+				t.Errorf("unexpected rows (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("ng: malformed jsonl row is reported", func(t *testing.T) {
+		t.Parallel()
+
+		rows, err := readImportRows(strings.NewReader("{\"name\":\"jacket\",\"category\":[1],\"image\":\"a.jpg\"}\n"), ImportFormatJSONL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(rows) != 1 || rows[0].err == nil {
+			t.Errorf("expected one row with an error, got %+v", rows)
+		}
+	})
+}
+
+func TestImportItems(t *testing.T) {
+	repo := newTestItemRepository(t, sqliteTestDSN(t))
+	categories := &categoryRepository{db: repo.db, dialect: repo.dialect}
+	insertTestCategory(t, repo, "fashion")
+	insertTestCategory(t, repo, "phone")
+
+	// a local stub serving images by URL
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/phone.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testPNG(t, 2, 2))
+	}))
+	t.Cleanup(stub.Close)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string][]byte{
+		"images/jacket.jpg": testPNG(t, 4, 3),
+		"images/empty.jpg":  nil,
+		"images/notes.jpg":  []byte("not an image"),
+	} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		f.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+
+	csvFile := strings.Join([]string{
+		"name,category,image",
+		"jacket,fashion,images/jacket.jpg",
+		"used iPhone 16e,2," + stub.URL + "/phone.jpg",
+		",fashion,images/jacket.jpg",
+		"hat,toys,images/jacket.jpg",
+		"scarf,fashion,images/missing.jpg",
+		"gloves,fashion,images/empty.jpg",
+		"charger,phone," + stub.URL + "/missing.jpg",
+		"boots,FASHION,images/jacket.jpg",
+		"shirt,fashion,images/notes.jpg",
+		"cable,phone," + strings.Replace(stub.URL, "127.0.0.1", "localhost", 1) + "/phone.jpg",
+	}, "\n")
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "items.csv")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write([]byte(csvFile))
+	part, err = writer.CreateFormFile("images", "images.zip")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(archive.Bytes())
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/items/import", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	res := httptest.NewRecorder()
+
+	images := &imageRepository{db: repo.db, dialect: repo.dialect}
+	// the stub listens on loopback, which the client of the server refuses to connect to
+	client := importClient([]string{"127.0.0.1"}, http.DefaultTransport)
+	h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: repo, categoryRepo: categories, imageRepo: images, importClient: client}
+	h.ImportItems(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	var report ImportReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	wantOK := map[int]bool{1: true, 2: true, 8: true}
+	for _, r := range report.Results {
+		if wantOK[r.Row] && (r.Error != "" || r.ItemID == 0) {
+			t.Errorf("expected row %d to be imported, got %+v", r.Row, r)
+		}
+		if !wantOK[r.Row] && r.Error == "" {
+			t.Errorf("expected row %d to fail, got %+v", r.Row, r)
+		}
+	}
+	if report.Imported != 3 || report.Failed != 7 {
+		t.Errorf("expected 3 imported and 7 failed, got %d and %d", report.Imported, report.Failed)
+	}
+	if got := report.Results[8].Error; !strings.Contains(got, errUnsupportedImage.Error()) {
+		t.Errorf("expected row 9 to fail as an unsupported image, got %q", got)
+	}
+
+	items, err := repo.LoadItems(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load items: %v", err)
+	}
+	if len(items) != 3 {
+		t.Errorf("expected 3 items in the database, got %d", len(items))
+	}
+	for _, item := range items {
+		if !strings.HasSuffix(item.ImageFileName, ".png") {
+			t.Errorf("expected %s to be stored as a PNG image, got %s", item.Name, item.ImageFileName)
+		}
+	}
+}
+
+func TestItemImporterBatches(t *testing.T) {
+	repo := newTestItemRepository(t, sqliteTestDSN(t))
+	categories := &categoryRepository{db: repo.db, dialect: repo.dialect}
+	insertTestCategory(t, repo, "fashion")
+
+	rows := make([]importRow, 5)
+	for i := range rows {
+		rows[i] = importRow{Name: "item", Category: "fashion", Image: "https://stub.invalid/image.jpg"}
+	}
+
+	im := newItemImporter(repo, categories, func(context.Context, [][]byte) ([]string, func(), error) {
+		return []string{"image.jpg"}, func() {}, nil
+	}, nil, UploadLimits{})
+	im.batchSize = 2
+	im.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Write([]byte("image"))
+		return rec.Result(), nil
+	})}
+
+	report, err := im.Import(context.Background(), rows)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if report.Imported != 5 {
+		t.Errorf("expected 5 imported rows, got %+v", report)
+	}
+}
+
+func TestItemImporterRollback(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCR := NewMockCategoryRepository(ctrl)
+	mockCR.EXPECT().List(gomock.Any()).Return([]*Category{{ID: 1, Name: "fashion"}}, nil)
+	mockIR := NewMockItemRepository(ctrl)
+	gomock.InOrder(
+		mockIR.EXPECT().InsertBatch(gomock.Any(), gomock.Any()).Return(errors.New("insert failed")),
+		mockIR.EXPECT().InsertBatch(gomock.Any(), gomock.Any()).Return(nil),
+	)
+
+	var rolledBack []string
+	storeImages := func(_ context.Context, images [][]byte) ([]string, func(), error) {
+		name := string(images[0]) + ".jpg"
+		return []string{name}, func() { rolledBack = append(rolledBack, name) }, nil
+	}
+	im := newItemImporter(mockIR, mockCR, storeImages, nil, UploadLimits{})
+	im.batchSize = 2
+	im.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+		return rec.Result(), nil
+	})}
+
+	var rows []importRow
+	for _, name := range []string{"a", "b", "c"} {
+		rows = append(rows, importRow{Name: name, Category: "fashion", Image: "https://stub.invalid/" + name})
+	}
+	report, err := im.Import(context.Background(), rows)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if report.Imported != 1 || report.Failed != 2 {
+		t.Errorf("expected the first batch to fail, got %+v", report)
+	}
+	if diff := cmp.Diff([]string{"a.jpg", "b.jpg"}, rolledBack); diff != "" {
+		t.Errorf("unexpected rolled back images (-want +got):\n%s", diff)
+	}
+}
+
+func TestItemImporterImageLimit(t *testing.T) {
+	t.Parallel()
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, size := range map[string]int{"small.jpg": 16, "large.jpg": 17} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		f.Write(make([]byte, size))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	images, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("failed to read zip: %v", err)
+	}
+
+	// images get the body limit of the upload endpoints
+	im := newItemImporter(nil, nil, nil, images, UploadLimits{MaxBodyBytes: 16})
+	if _, err := im.loadImage(context.Background(), "small.jpg"); err != nil {
+		t.Errorf("expected an image within the limit to load, got %v", err)
+	}
+	if _, err := im.loadImage(context.Background(), "large.jpg"); err == nil {
+		t.Errorf("expected an error for an image over the limit")
+	}
+}
+
+func TestImportClient(t *testing.T) {
+	t.Parallel()
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+			return
+		}
+		w.Write(testPNG(t, 2, 2))
+	}))
+	t.Cleanup(stub.Close)
+	localhost := strings.Replace(stub.URL, "127.0.0.1", "localhost", 1)
+
+	cases := map[string]struct {
+		client  *http.Client
+		url     string
+		wantErr bool
+	}{
+		"ok: allowed host":                  {client: importClient([]string{"127.0.0.1"}, http.DefaultTransport), url: stub.URL + "/a.png"},
+		"ok: redirect to an allowed host":   {client: importClient([]string{"127.0.0.1", "localhost"}, http.DefaultTransport), url: stub.URL + "/redirect?to=" + localhost + "/a.png"},
+		"ng: other host":                    {client: importClient([]string{"example.com"}, http.DefaultTransport), url: stub.URL + "/a.png", wantErr: true},
+		"ng: redirect to another host":      {client: importClient([]string{"127.0.0.1"}, http.DefaultTransport), url: stub.URL + "/redirect?to=" + localhost + "/a.png", wantErr: true},
+		"ng: allowed host on loopback":      {client: newImportClient([]string{"127.0.0.1"}), url: stub.URL + "/a.png", wantErr: true},
+		"ng: allowed name of a loopback IP": {client: newImportClient([]string{"localhost"}), url: localhost + "/a.png", wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := tt.client.Get(tt.url)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("ng: no allowed host", func(t *testing.T) {
+		t.Parallel()
+
+		if c := newImportClient(nil); c != nil {
+			t.Fatalf("expected no client")
+		}
+		im := newItemImporter(nil, nil, nil, nil, UploadLimits{})
+		im.client = nil
+		if _, err := im.loadImage(context.Background(), stub.URL+"/a.png"); !errors.Is(err, errImportURLDisabled) {
+			t.Errorf("expected errImportURLDisabled, got %v", err)
+		}
+	})
+}
+
+func TestRefuseInternalAddress(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		address string
+		wantErr bool
+	}{
+		"ok: public ipv4":   {address: "93.184.216.34:443"},
+		"ok: public ipv6":   {address: "[2606:2800:220:1:248:1893:25c8:1946]:443"},
+		"ng: loopback":      {address: "127.0.0.1:80", wantErr: true},
+		"ng: ipv6 loopback": {address: "[::1]:80", wantErr: true},
+		"ng: private":       {address: "10.0.0.5:80", wantErr: true},
+		"ng: ipv6 private":  {address: "[fd00::1]:80", wantErr: true},
+		"ng: metadata":      {address: "169.254.169.254:80", wantErr: true},
+		"ng: mapped ipv4":   {address: "[::ffff:192.168.0.1]:80", wantErr: true},
+		"ng: unspecified":   {address: "0.0.0.0:80", wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := refuseInternalAddress("tcp", tt.address, nil); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -4,42 +4,166 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"io/ioutil"
 	"fmt"
+	"log/slog"
 	"os"
-	// STEP 5-1: uncomment this line
-	_ "github.com/mattn/go-sqlite3"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 )
 
 var errImageNotFound = errors.New("image not found")
 
+// errQueryTimeout is returned by ItemRepository when a query runs past its deadline.
+var errQueryTimeout = errors.New("query timed out")
+
+var (
+	errItemNotFound   = errors.New("item not found")
+	errCategoryExists = errors.New("category already exists")
+)
+
+// maxItemImages is the largest number of images an item can have.
+const maxItemImages = 10
+
+var (
+	errTooManyImages      = fmt.Errorf("an item can have at most %d images", maxItemImages)
+	errLastImage          = errors.New("an item must keep at least one image")
+	errImageOrderMismatch = errors.New("the new order must list every image of the item exactly once")
+)
+
+// ItemStatus tells who may see an item.
+type ItemStatus string
+
+const (
+	// ItemStatusPublic is a listing anyone may see. It is the status of new items.
+	ItemStatusPublic ItemStatus = "public"
+	// ItemStatusDraft is a listing not published yet.
+	ItemStatusDraft ItemStatus = "draft"
+	// ItemStatusReserved is a listing set aside for a buyer.
+	ItemStatusReserved ItemStatus = "reserved"
+)
+
+var errInvalidItemStatus = fmt.Errorf("status must be %s, %s or %s", ItemStatusPublic, ItemStatusDraft, ItemStatusReserved)
+
+// parseItemStatus validates a status sent by a client. An empty status is public.
+func parseItemStatus(s string) (ItemStatus, error) {
+	switch status := ItemStatus(s); status {
+	case "":
+		return ItemStatusPublic, nil
+	case ItemStatusPublic, ItemStatusDraft, ItemStatusReserved:
+		return status, nil
+	default:
+		return "", errInvalidItemStatus
+	}
+}
+
 type Item struct {
-	ID            int    `json:"id"`
-	Name          string `json:"name"`
-	Category      string `json:"category"`
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// ImageFileName is the cover image, always the first of Images.
 	ImageFileName string `json:"image_name"`
+	// Images are the file names of every image of the item, in display order.
+	Images []string `json:"images"`
+	// BlurHash and DominantColor are the placeholder of the cover image, empty when unknown.
+	// They are read from the image metadata and never stored with the item.
+	BlurHash      string `json:"blurhash,omitempty"`
+	DominantColor string `json:"dominant_color,omitempty"`
+	// Status tells who may see the item; an empty status is stored as public.
+	Status ItemStatus `json:"status"`
+	// SellerID is the user who listed the item, zero for items listed before accounts existed,
+	// which only admins may change.
+	SellerID int `json:"seller_id,omitempty"`
+	// Hidden is set when a moderator hid the item from everyone but its seller and the
+	// moderators, for HiddenReason.
+	Hidden       bool   `json:"hidden,omitempty"`
+	HiddenReason string `json:"hidden_reason,omitempty"`
+	// ImageURL and ImageURLs are where clients fetch ImageFileName and Images from. They are
+	// not stored but set by the handlers, and are signed and expire unless the item is public.
+	ImageURL  string   `json:"image_url,omitempty"`
+	ImageURLs []string `json:"image_urls,omitempty"`
 }
 
-func setupDatabase() (*sql.DB, error) {
-	// Open SQLite database file
-	db, err := sql.Open("sqlite3", "db/mercari.sqlite3")
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+// itemColumns selects an item together with one of its images per row, in display order.
+// Rows are turned into items by scanItems.
+const itemColumns = `
+		SELECT items.id, items.name, categories.name, items.image_name, cover.blurhash, cover.dominant_color,
+			items.status, items.seller_id, items.hidden_at, items.hidden_reason, item_images.image_name
+		FROM items
+		JOIN categories ON items.category_id = categories.id
+		LEFT JOIN images AS cover ON cover.name = items.image_name
+		LEFT JOIN item_images ON item_images.item_id = items.id`
+
+// itemOrder orders the rows of itemColumns by item, then by image position.
+const itemOrder = ` ORDER BY items.id, item_images.position`
+
+// scanItems reads the rows of an itemColumns query, which holds one row per image,
+// and groups them into items.
+func scanItems(rows *sql.Rows) ([]*Item, error) {
+	var items []*Item
+	err := eachScannedItem(rows, func(item *Item) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// eachScannedItem groups the rows of an itemColumns query into items and calls fn for each
+// of them as soon as it is complete, that is once the rows of the next item start.
+// Errors returned by fn are returned as is.
+func eachScannedItem(rows *sql.Rows, fn func(item *Item) error) error {
+	var current *Item
+	for rows.Next() {
+		var item Item
+		var image, blurHash, dominantColor, hiddenAt, hiddenReason sql.NullString
+		var sellerID sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.ImageFileName, &blurHash, &dominantColor, &item.Status, &sellerID, &hiddenAt, &hiddenReason, &image); err != nil {
+			return fmt.Errorf("failed to scan item: %w", err)
+		}
+		item.BlurHash, item.DominantColor = blurHash.String, dominantColor.String
+		item.SellerID = int(sellerID.Int64)
+		item.Hidden, item.HiddenReason = hiddenAt.Valid, hiddenReason.String
+		if current == nil || current.ID != item.ID {
+			if current != nil {
+				if err := fn(current); err != nil {
+					return err
+				}
+			}
+			current = &item
+		}
+		if image.Valid {
+			current.Images = append(current.Images, image.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read items: %w", err)
 	}
+	if current != nil {
+		return fn(current)
+	}
+	return nil
+}
 
-	// Read the SQL script from items.sql and execute it
-	sqlFile, err := ioutil.ReadFile("db/items.sql")
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func setupDatabase(cfg DatabaseConfig) (*sql.DB, dialect, error) {
+	// Open the database selected by the DSN scheme
+	db, d, err := openDatabase(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read the SQL file: %w", err)
+		return nil, 0, err
 	}
 
-	// Execute the SQL script
-	_, err = db.Exec(string(sqlFile))
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL script: %w", err)
+	// Apply the pending migrations for the dialect
+	if _, err := migrateUp(context.Background(), db, d, migrationsDir); err != nil {
+		db.Close()
+		return nil, 0, err
 	}
 
-	return db, nil
+	return db, d, nil
 }
 
 // Please run `go generate ./...` to generate the mock implementation
@@ -49,70 +173,720 @@ func setupDatabase() (*sql.DB, error) {
 type ItemRepository interface {
 	Insert(ctx context.Context, item *Item) error
     LoadItems(ctx context.Context) ([]*Item, error)
-	SearchItemsByName(keyword string) ([]*Item, error)
+	SearchItemsByName(ctx context.Context, keyword string) ([]*Item, error)
+	EachItem(ctx context.Context, keyword string, fn func(item *Item) error) error
+	InsertBatch(ctx context.Context, items []*Item) error
+	Select(ctx context.Context, id int) (*Item, error)
+	Delete(ctx context.Context, id int) error
+	AddImages(ctx context.Context, id int, names []string) (*Item, error)
+	RemoveImage(ctx context.Context, id int, name string) (*Item, error)
+	ReorderImages(ctx context.Context, id int, names []string) (*Item, error)
+	UpdateStatus(ctx context.Context, id int, status ItemStatus) (*Item, error)
+	SelectBySeller(ctx context.Context, sellerID int) ([]*Item, error)
+	SetHidden(ctx context.Context, id int, hidden bool, reason string) (*Item, error)
+}
+
+// CategoryRepository is an interface to manage categories.
+type CategoryRepository interface {
+	Insert(ctx context.Context, category *Category) error
+	List(ctx context.Context) ([]*Category, error)
+}
+
+// ImageRepository is an interface to manage the metadata of stored images.
+type ImageRepository interface {
+	Insert(ctx context.Context, image *ImageMetadata) error
+	Select(ctx context.Context, name string) (*ImageMetadata, error)
+	FindSimilar(ctx context.Context, names []string, maxDistance int) ([]SimilarImage, error)
+	IsPrivate(ctx context.Context, name string) (bool, error)
+	DeleteUnreferenced(ctx context.Context, names []string) ([]string, error)
 }
 
 // itemRepository is an implementation of ItemRepository
 type itemRepository struct {
     db *sql.DB
+	// dialect is the SQL flavour of db, used to rewrite placeholders.
+	dialect dialect
+	// queryTimeout bounds every query issued by the repository. Zero means no limit.
+	queryTimeout time.Duration
 }
 
-// NewItemRepository creates a new itemRepository.
-func NewItemRepository() (ItemRepository, error) {
-	db, err := setupDatabase()
+// NewItemRepository creates a new itemRepository backed by the database selected by cfg.DSN.
+// Each query is cancelled once cfg.QueryTimeout has elapsed; zero disables the deadline.
+func NewItemRepository(cfg DatabaseConfig) (ItemRepository, error) {
+	db, d, err := setupDatabase(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create item repository: %w", err)
 	}
-	return &itemRepository{db: db}, nil
+	return &itemRepository{db: db, dialect: d, queryTimeout: cfg.QueryTimeout}, nil
 }
 
-func (r *itemRepository) Insert(ctx context.Context, item *Item) error {
+// withQueryTimeout derives a context carrying the repository's query deadline.
+func (r *itemRepository) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withQueryTimeout(ctx, r.queryTimeout)
+}
+
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryError wraps err with errQueryTimeout when ctx hit its deadline,
+// so that handlers can tell a slow query apart from other failures.
+func queryError(ctx context.Context, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", errQueryTimeout, err)
+	}
+	return err
+}
+
+func (r *itemRepository) Insert(ctx context.Context, item *Item) (err error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+	defer func() {
+		if err != nil {
+			err = queryError(ctx, err)
+		}
+	}()
+
 	tx, err := r.db.BeginTx(ctx, nil) // Start transaction
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer tx.Rollback() // Rollback in case of error
 
+	if err := r.insert(ctx, tx, item); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// InsertBatch inserts items in a single transaction: either all of them are stored or none is.
+func (r *itemRepository) InsertBatch(ctx context.Context, items []*Item) (err error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+	defer func() {
+		if err != nil {
+			err = queryError(ctx, err)
+		}
+	}()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, item := range items {
+		if err := r.insert(ctx, tx, item); err != nil {
+			return fmt.Errorf("%s: %w", item.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// insert inserts item and its images within tx, then sets its ID and replaces its category ID
+// with the category name. An item without Images gets ImageFileName as its only image;
+// otherwise ImageFileName is set to the first of Images.
+func (r *itemRepository) insert(ctx context.Context, tx *sql.Tx, item *Item) error {
+	if len(item.Images) == 0 && item.ImageFileName != "" {
+		item.Images = []string{item.ImageFileName}
+	}
+	if len(item.Images) > maxItemImages {
+		return errTooManyImages
+	}
+	if len(item.Images) > 0 {
+		item.ImageFileName = item.Images[0]
+	}
+	if item.Status == "" {
+		item.Status = ItemStatusPublic
+	}
+
 	// Get category_id
 	var categoryID int
-	err = tx.QueryRowContext(ctx, "SELECT id FROM categories WHERE id = ?", item.Category).Scan(&categoryID)
+	err := tx.QueryRowContext(ctx, r.dialect.rebind("SELECT id FROM categories WHERE id = ?"), item.Category).Scan(&categoryID)
 	if err != nil {
 		return fmt.Errorf("failed to get category: %w", err)
 	}
-	
 
 	// Insert new data into items table
-	query := `INSERT INTO items (name, category_id, image_name) VALUES (?, ?, ?)`
-	res, err := tx.ExecContext(ctx, query, item.Name, categoryID, item.ImageFileName)
+	// and get the item's ID (RETURNING works on both SQLite and PostgreSQL)
+	query := `INSERT INTO items (name, category_id, image_name, status, seller_id) VALUES (?, ?, ?, ?, ?) RETURNING id`
+	err = tx.QueryRowContext(ctx, r.dialect.rebind(query), item.Name, categoryID, item.ImageFileName, string(item.Status), nullInt(item.SellerID)).Scan(&item.ID)
 	if err != nil {
 		return fmt.Errorf("failed to insert item: %w", err)
 	}
 
-	// Get the item's ID
-	lastID, err := res.LastInsertId()
-	if err != nil {
-		return fmt.Errorf("failed to get item ID: %w", err)
+	if err := r.writeImages(ctx, tx, item.ID, item.Images); err != nil {
+		return err
 	}
-	item.ID = int(lastID)
 
 	// Get the category name
 	var categoryName string
-	err = tx.QueryRowContext(ctx, "SELECT name FROM categories WHERE id = ?", categoryID).Scan(&categoryName)
+	err = tx.QueryRowContext(ctx, r.dialect.rebind("SELECT name FROM categories WHERE id = ?"), categoryID).Scan(&categoryName)
 	if err != nil {
 		return fmt.Errorf("failed to get category name: %w", err)
 	}
 
 	// Set the item’s category name
 	item.Category = categoryName
+	return nil
+}
+
+// eachItemPageSize is the number of items EachItem reads per query.
+const eachItemPageSize = 100
+
+// EachItem calls fn for every item, in ID order, reading a page of items at a time instead of
+// loading them all like LoadItems. A non-empty keyword filters items the same way as
+// SearchItemsByName. Iteration stops at the first error returned by fn, which EachItem returns
+// as is. Each page is read under the query deadline and no query is left open while fn runs,
+// so that a slow fn, such as a write to a slow client, never holds a connection.
+func (r *itemRepository) EachItem(ctx context.Context, keyword string, fn func(item *Item) error) error {
+	return r.eachItem(ctx, keyword, eachItemPageSize, fn)
+}
+
+func (r *itemRepository) eachItem(ctx context.Context, keyword string, pageSize int, fn func(item *Item) error) error {
+	for after := 0; ; {
+		page, err := r.itemPage(ctx, keyword, after, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range page {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+// itemPage returns the first limit items with an ID greater than after, in ID order,
+// filtered by keyword like EachItem.
+func (r *itemRepository) itemPage(ctx context.Context, keyword string, after, limit int) ([]*Item, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	filter := `id > ?`
+	args := []any{after}
+	if keyword != "" {
+		filter += ` AND LOWER(name) LIKE LOWER(?)`
+		args = append(args, "%"+strings.ToLower(keyword)+"%")
+	}
+	args = append(args, limit)
+	// the limit applies to items, not to the rows of their images
+	query := itemColumns + ` WHERE items.id IN (SELECT id FROM items WHERE ` + filter + ` ORDER BY id LIMIT ?)` + itemOrder
+
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve items: %w", err))
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return items, nil
+}
+
+// Select returns the item with the given ID, or errItemNotFound.
+func (r *itemRepository) Select(ctx context.Context, id int) (*Item, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	item, err := r.selectItem(ctx, r.db, id)
+	if err != nil && !errors.Is(err, errItemNotFound) {
+		return nil, queryError(ctx, err)
+	}
+	return item, err
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// selectItem returns the item with the given ID and its images, or errItemNotFound.
+func (r *itemRepository) selectItem(ctx context.Context, q queryer, id int) (*Item, error) {
+	rows, err := q.QueryContext(ctx, r.dialect.rebind(itemColumns+` WHERE items.id = ?`+itemOrder), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, errItemNotFound
+	}
+	return items[0], nil
+}
+
+// AddImages appends images to the item with the given ID and returns the updated item.
+// It returns errItemNotFound, or errTooManyImages if the item would exceed maxItemImages.
+func (r *itemRepository) AddImages(ctx context.Context, id int, names []string) (*Item, error) {
+	return r.updateImages(ctx, id, func(images []string) ([]string, error) {
+		if len(images)+len(names) > maxItemImages {
+			return nil, errTooManyImages
+		}
+		return append(images, names...), nil
+	})
+}
+
+// RemoveImage removes an image from the item with the given ID and returns the updated item.
+// It returns errItemNotFound, errImageNotFound if the item has no such image, or errLastImage.
+// The image file is left in place; it may be shared with other items.
+func (r *itemRepository) RemoveImage(ctx context.Context, id int, name string) (*Item, error) {
+	return r.updateImages(ctx, id, func(images []string) ([]string, error) {
+		i := slices.Index(images, name)
+		if i < 0 {
+			return nil, errImageNotFound
+		}
+		if len(images) == 1 {
+			return nil, errLastImage
+		}
+		return slices.Delete(images, i, i+1), nil
+	})
+}
+
+// ReorderImages sets the display order of the images of the item with the given ID and
+// returns the updated item. names must be a permutation of the current images, otherwise
+// errImageOrderMismatch is returned.
+func (r *itemRepository) ReorderImages(ctx context.Context, id int, names []string) (*Item, error) {
+	return r.updateImages(ctx, id, func(images []string) ([]string, error) {
+		current := slices.Sorted(slices.Values(images))
+		requested := slices.Sorted(slices.Values(names))
+		if !slices.Equal(current, requested) {
+			return nil, errImageOrderMismatch
+		}
+		return names, nil
+	})
+}
+
+// UpdateStatus sets the status of the item with the given ID and returns the updated item,
+// or errItemNotFound.
+func (r *itemRepository) UpdateStatus(ctx context.Context, id int, status ItemStatus) (*Item, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, r.dialect.rebind(`UPDATE items SET status = ? WHERE id = ?`), string(status), id)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to update item status: %w", err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to update item status: %w", err))
+	} else if n == 0 {
+		return nil, errItemNotFound
+	}
+
+	item, err := r.selectItem(ctx, r.db, id)
+	if err != nil && !errors.Is(err, errItemNotFound) {
+		return nil, queryError(ctx, err)
+	}
+	return item, err
+}
+
+// SetHidden hides the item with the given ID for reason, or shows it again, and returns the
+// updated item, or errItemNotFound if there is no such item.
+func (r *itemRepository) SetHidden(ctx context.Context, id int, hidden bool, reason string) (*Item, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	hiddenAt, hiddenReason := sql.NullString{}, sql.NullString{}
+	if hidden {
+		hiddenAt, hiddenReason = nullString(dbTime(time.Now())), nullString(reason)
+	}
+	res, err := r.db.ExecContext(ctx, r.dialect.rebind(`UPDATE items SET hidden_at = ?, hidden_reason = ? WHERE id = ?`), hiddenAt, hiddenReason, id)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to update item visibility: %w", err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to update item visibility: %w", err))
+	} else if n == 0 {
+		return nil, errItemNotFound
+	}
+
+	item, err := r.selectItem(ctx, r.db, id)
+	if err != nil && !errors.Is(err, errItemNotFound) {
+		return nil, queryError(ctx, err)
+	}
+	return item, err
+}
+
+// updateImages replaces the images of the item with the given ID by the result of update,
+// keeping the cover image in sync, all in one transaction.
+func (r *itemRepository) updateImages(ctx context.Context, id int, update func(images []string) ([]string, error)) (_ *Item, err error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+	defer func() {
+		if err != nil && !errors.Is(err, errItemNotFound) {
+			err = queryError(ctx, err)
+		}
+	}()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	item, err := r.selectItem(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	images, err := update(slices.Clone(item.Images))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, r.dialect.rebind(`DELETE FROM item_images WHERE item_id = ?`), id); err != nil {
+		return nil, fmt.Errorf("failed to update images: %w", err)
+	}
+	if err := r.writeImages(ctx, tx, id, images); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, r.dialect.rebind(`UPDATE items SET image_name = ? WHERE id = ?`), images[0], id); err != nil {
+		return nil, fmt.Errorf("failed to update cover image: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	item.Images = images
+	item.ImageFileName = images[0]
+	return item, nil
+}
+
+// writeImages inserts the images of an item within tx, numbering their positions from 0.
+func (r *itemRepository) writeImages(ctx context.Context, tx *sql.Tx, id int, images []string) error {
+	query := r.dialect.rebind(`INSERT INTO item_images (item_id, image_name, position) VALUES (?, ?, ?)`)
+	for position, name := range images {
+		if _, err := tx.ExecContext(ctx, query, id, name, position); err != nil {
+			return fmt.Errorf("failed to insert image: %w", err)
+		}
+	}
+	return nil
+}
+
+// SelectBySeller returns the items listed by the user with the given ID, in ID order.
+func (r *itemRepository) SelectBySeller(ctx context.Context, sellerID int) ([]*Item, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(itemColumns+` WHERE items.seller_id = ?`+itemOrder), sellerID)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve items of seller: %w", err))
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve items of seller: %w", err))
+	}
+	return items, nil
+}
+
+// Delete removes the item with the given ID, or returns errItemNotFound.
+// The image file is left in place; it may be shared with other items.
+func (r *itemRepository) Delete(ctx context.Context, id int) error {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, r.dialect.rebind(`DELETE FROM items WHERE id = ?`), id)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to delete item: %w", err))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+	if n == 0 {
+		return errItemNotFound
+	}
+	return nil
+}
+
+// categoryRepository is an implementation of CategoryRepository
+type categoryRepository struct {
+	db *sql.DB
+	// dialect is the SQL flavour of db, used to rewrite placeholders.
+	dialect dialect
+	// queryTimeout bounds every query issued by the repository. Zero means no limit.
+	queryTimeout time.Duration
+}
+
+// Insert adds a category and sets its ID. Category names are unique.
+func (r *categoryRepository) Insert(ctx context.Context, category *Category) (err error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	defer func() {
+		if err != nil {
+			err = queryError(ctx, err)
+		}
+	}()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, r.dialect.rebind(`SELECT COUNT(*) FROM categories WHERE name = ?`), category.Name).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check category: %w", err)
+	}
+	if exists > 0 {
+		return errCategoryExists
+	}
+
+	err = tx.QueryRowContext(ctx, r.dialect.rebind(`INSERT INTO categories (name) VALUES (?) RETURNING id`), category.Name).Scan(&category.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert category: %w", err)
+	}
 
-	// Commit the transaction
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return nil
+}
+
+// List returns every category ordered by ID.
+func (r *categoryRepository) List(ctx context.Context) ([]*Category, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT id, name FROM categories ORDER BY id`)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve categories: %w", err))
+	}
+	defer rows.Close()
+
+	var categories []*Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name); err != nil {
+			return nil, queryError(ctx, fmt.Errorf("failed to scan category: %w", err))
+		}
+		categories = append(categories, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to retrieve categories: %w", err))
+	}
+	return categories, nil
+}
+
+// imageRepository is an implementation of ImageRepository
+type imageRepository struct {
+	db *sql.DB
+	// dialect is the SQL flavour of db, used to rewrite placeholders.
+	dialect dialect
+	// queryTimeout bounds every query issued by the repository. Zero means no limit.
+	queryTimeout time.Duration
+}
+
+// Insert records the metadata of an image. Images are content-addressed, so
+// recording the same image again only fills in the fields it lacked.
+func (r *imageRepository) Insert(ctx context.Context, image *ImageMetadata) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
 
+	// an image stored again keeps its metadata, but gains what was computed since it was first stored
+	query := `INSERT INTO images (name, format, width, height, size, phash, blurhash, dominant_color) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET
+			phash = COALESCE(images.phash, excluded.phash),
+			blurhash = COALESCE(images.blurhash, excluded.blurhash),
+			dominant_color = COALESCE(images.dominant_color, excluded.dominant_color)`
+	_, err := r.db.ExecContext(ctx, r.dialect.rebind(query), image.Name, string(image.Format), image.Width, image.Height, image.Size,
+		nullString(image.PHash), nullString(image.BlurHash), nullString(image.DominantColor))
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to insert image: %w", err))
+	}
 	return nil
 }
 
+// Select returns the metadata of the image with the given file name, or errImageNotFound.
+func (r *imageRepository) Select(ctx context.Context, name string) (*ImageMetadata, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	image := ImageMetadata{Name: name}
+	var format string
+	var phash, blurHash, dominantColor sql.NullString
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(`SELECT format, width, height, size, phash, blurhash, dominant_color FROM images WHERE name = ?`), name).
+		Scan(&format, &image.Width, &image.Height, &image.Size, &phash, &blurHash, &dominantColor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errImageNotFound
+	}
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to get image: %w", err))
+	}
+	image.Format = ImageFormat(format)
+	image.PHash = phash.String
+	image.BlurHash, image.DominantColor = blurHash.String, dominantColor.String
+	return &image, nil
+}
+
+// nullString returns s as a column value, NULL when empty.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullInt returns NULL for a zero ID.
+func nullInt(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// IsPrivate reports whether image name is only shown by items that are not public or are
+// hidden, and so may only be served through a signed URL. Images of no item are not private.
+func (r *imageRepository) IsPrivate(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	var items, public int
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN items.status = ? AND items.hidden_at IS NULL THEN 1 ELSE 0 END), 0)
+		FROM item_images JOIN items ON items.id = item_images.item_id
+		WHERE item_images.image_name = ?`), string(ItemStatusPublic), name).Scan(&items, &public)
+	if err != nil {
+		return false, queryError(ctx, fmt.Errorf("failed to check image visibility: %w", err))
+	}
+	return items > 0 && public == 0, nil
+}
+
+// DeleteUnreferenced deletes the metadata of those of names that no item references and
+// returns them, so that their files may be removed. The references are checked in the same
+// transaction, so an image that an item saved in the meantime is kept.
+func (r *imageRepository) DeleteUnreferenced(ctx context.Context, names []string) (deleted []string, err error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	defer func() {
+		if err != nil {
+			err = queryError(ctx, err)
+		}
+	}()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, name := range names {
+		var referenced bool
+		err := tx.QueryRowContext(ctx, r.dialect.rebind(`
+			SELECT EXISTS (SELECT 1 FROM items WHERE image_name = ?)
+				OR EXISTS (SELECT 1 FROM item_images WHERE image_name = ?)`), name, name).Scan(&referenced)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check image references: %w", err)
+		}
+		if referenced {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, r.dialect.rebind(`DELETE FROM images WHERE name = ?`), name); err != nil {
+			return nil, fmt.Errorf("failed to delete image: %w", err)
+		}
+		deleted = append(deleted, name)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deleted, nil
+}
+
+// FindSimilar returns the images of items whose perceptual hash is at most maxDistance bits
+// away from that of any of the images names, closest first. Each is compared with the closest
+// of names, and items showing one of names itself are included with a distance of 0, whatever
+// their status: it is up to the caller to leave out the items the viewer may not see.
+// The stored hashes are scanned once however many names are given. It returns errImageNotFound
+// for an unknown name, and nothing for images without a perceptual hash.
+func (r *imageRepository) FindSimilar(ctx context.Context, names []string, maxDistance int) ([]SimilarImage, error) {
+	type target struct {
+		name string
+		hash uint64
+	}
+	var targets []target
+	for _, name := range names {
+		image, err := r.Select(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if image.PHash == "" {
+			continue
+		}
+		hash, err := parsePHash(image.PHash)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{name: name, hash: hash})
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	// the distances are computed here, since SQLite has no portable way to count bits
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT item_images.item_id, item_images.image_name, images.phash, items.status, items.seller_id, items.hidden_at
+		FROM item_images
+		JOIN images ON images.name = item_images.image_name
+		JOIN items ON items.id = item_images.item_id
+		WHERE images.phash IS NOT NULL
+		ORDER BY item_images.item_id, item_images.position`)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to find similar images: %w", err))
+	}
+	defer rows.Close()
+
+	var similar []SimilarImage
+	for rows.Next() {
+		s := SimilarImage{Distance: maxDistance + 1}
+		var phash string
+		var sellerID sql.NullInt64
+		var hiddenAt sql.NullString
+		if err := rows.Scan(&s.ItemID, &s.ImageName, &phash, &s.Status, &sellerID, &hiddenAt); err != nil {
+			return nil, queryError(ctx, fmt.Errorf("failed to scan similar image: %w", err))
+		}
+		s.SellerID, s.Hidden = int(sellerID.Int64), hiddenAt.Valid
+		hash, err := parsePHash(phash)
+		if err != nil {
+			slog.Warn("skipping image with invalid perceptual hash", "name", s.ImageName, "error", err)
+			continue
+		}
+		for _, t := range targets {
+			if d := phashDistance(t.hash, hash); d < s.Distance {
+				s.SimilarTo, s.Distance = t.name, d
+			}
+		}
+		if s.Distance <= maxDistance {
+			similar = append(similar, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, fmt.Errorf("failed to find similar images: %w", err))
+	}
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Distance < similar[j].Distance })
+	return similar, nil
+}
+
 // StoreImage stores an image and returns an error if any.
 // This package doesn't have a related interface for simplicity.
 func StoreImage(fileName string, image []byte) error {
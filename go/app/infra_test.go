@@ -0,0 +1,866 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// testMigrationsDir is the migrations directory relative to this package.
+const testMigrationsDir = "../db/migrations"
+
+func TestItemRepository(t *testing.T) {
+	backends := map[string]func(t *testing.T) string{
+		"sqlite3":  sqliteTestDSN,
+		"postgres": postgresTestDSN,
+	}
+
+	for name, dsn := range backends {
+		t.Run(name, func(t *testing.T) {
+			if testing.Short() && name == "postgres" {
+				t.Skip("skipping postgres in short mode")
+			}
+			repo := newTestItemRepository(t, dsn(t))
+			testItemRepository(t, repo)
+		})
+	}
+}
+
+// testItemRepository runs the repository test suite shared by every backend.
+func testItemRepository(t *testing.T, repo *itemRepository) {
+	ctx := context.Background()
+
+	fashionID := insertTestCategory(t, repo, "fashion")
+	phoneID := insertTestCategory(t, repo, "phone")
+
+	t.Run("Insert", func(t *testing.T) {
+		item := &Item{Name: "jacket", Category: strconv.Itoa(fashionID), ImageFileName: "jacket.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		if item.ID == 0 {
+			t.Errorf("expected item ID to be set")
+		}
+		if item.Category != "fashion" {
+			t.Errorf("expected category name fashion, got %s", item.Category)
+		}
+
+		err := repo.Insert(ctx, &Item{Name: "ghost", Category: "9999", ImageFileName: "ghost.jpg"})
+		if err == nil {
+			t.Errorf("expected an error for an unknown category")
+		}
+	})
+
+	t.Run("LoadItems", func(t *testing.T) {
+		item := &Item{Name: "used iPhone 16e", Category: strconv.Itoa(phoneID), ImageFileName: "phone.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		items, err := repo.LoadItems(ctx)
+		if err != nil {
+			t.Fatalf("failed to load items: %v", err)
+		}
+		found := false
+		for _, got := range items {
+			if got.ID == item.ID {
+				found = true
+				if diff := cmp.Diff(item, got); diff != "" {
+					t.Errorf("unexpected item (-want +got):\n%s", diff)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("inserted item %d is missing from %d loaded items", item.ID, len(items))
+		}
+	})
+
+	t.Run("SearchItemsByName", func(t *testing.T) {
+		item := &Item{Name: "Vintage Denim", Category: strconv.Itoa(fashionID), ImageFileName: "denim.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		cases := map[string]struct {
+			keyword string
+			want    []*Item
+		}{
+			"ok: case-insensitive partial match": {keyword: "denim", want: []*Item{item}},
+			"ok: no match":                       {keyword: "nothing like this", want: nil},
+		}
+		for name, tt := range cases {
+			t.Run(name, func(t *testing.T) {
+				got, err := repo.SearchItemsByName(ctx, tt.keyword)
+				if err != nil {
+					t.Fatalf("failed to search items: %v", err)
+				}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("unexpected items (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("EachItem", func(t *testing.T) {
+		item := &Item{Name: "Denim Jacket", Category: strconv.Itoa(fashionID), ImageFileName: "denim-jacket.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		var all []*Item
+		if err := repo.EachItem(ctx, "", func(item *Item) error {
+			all = append(all, item)
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to iterate items: %v", err)
+		}
+		loaded, err := repo.LoadItems(ctx)
+		if err != nil {
+			t.Fatalf("failed to load items: %v", err)
+		}
+		if len(all) != len(loaded) {
+			t.Errorf("expected %d items, got %d", len(loaded), len(all))
+		}
+		for i := 1; i < len(all); i++ {
+			if all[i-1].ID >= all[i].ID {
+				t.Errorf("expected items in ID order, got %d before %d", all[i-1].ID, all[i].ID)
+			}
+		}
+
+		var matched []*Item
+		if err := repo.EachItem(ctx, "denim", func(item *Item) error {
+			matched = append(matched, item)
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to iterate items: %v", err)
+		}
+		searched, err := repo.SearchItemsByName(ctx, "denim")
+		if err != nil {
+			t.Fatalf("failed to search items: %v", err)
+		}
+		byID := cmpopts.SortSlices(func(a, b *Item) bool { return a.ID < b.ID })
+		if diff := cmp.Diff(searched, matched, byID); diff != "" {
+			t.Errorf("expected the same items as SearchItemsByName (-want +got):\n%s", diff)
+		}
+
+		errStop := errors.New("stop")
+		calls := 0
+		err = repo.EachItem(ctx, "", func(*Item) error {
+			calls++
+			return errStop
+		})
+		if !errors.Is(err, errStop) || calls != 1 {
+			t.Errorf("expected iteration to stop with errStop after 1 call, got %v after %d", err, calls)
+		}
+
+		if len(all) <= 2 {
+			t.Fatalf("expected more items than fit in a page, got %d", len(all))
+		}
+		// with pages of 2 items and a single connection, fn may only query the database
+		// if no query is left open while it runs
+		repo.db.SetMaxOpenConns(1)
+		defer repo.db.SetMaxOpenConns(4)
+		var paged []*Item
+		err = repo.eachItem(ctx, "", 2, func(item *Item) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			got, err := repo.Select(ctx, item.ID)
+			if err != nil {
+				return err
+			}
+			paged = append(paged, got)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to iterate items: %v", err)
+		}
+		if diff := cmp.Diff(all, paged); diff != "" {
+			t.Errorf("expected the same items page by page (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Select", func(t *testing.T) {
+		item := &Item{Name: "sofa", Category: strconv.Itoa(fashionID), ImageFileName: "sofa.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		got, err := repo.Select(ctx, item.ID)
+		if err != nil {
+			t.Fatalf("failed to select item: %v", err)
+		}
+		if diff := cmp.Diff(item, got); diff != "" {
+			t.Errorf("unexpected item (-want +got):\n%s", diff)
+		}
+
+		if _, err := repo.Select(ctx, 999999); !errors.Is(err, errItemNotFound) {
+			t.Errorf("expected errItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		item := &Item{Name: "broken lamp", Category: strconv.Itoa(fashionID), ImageFileName: "lamp.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		if err := repo.Delete(ctx, item.ID); err != nil {
+			t.Fatalf("failed to delete item: %v", err)
+		}
+		if _, err := repo.Select(ctx, item.ID); !errors.Is(err, errItemNotFound) {
+			t.Errorf("expected deleted item to be gone, got %v", err)
+		}
+		if err := repo.Delete(ctx, item.ID); !errors.Is(err, errItemNotFound) {
+			t.Errorf("expected errItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("ItemImages", func(t *testing.T) {
+		item := &Item{Name: "camera", Category: strconv.Itoa(phoneID), Images: []string{"front.jpg", "back.png"}}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		if item.ImageFileName != "front.jpg" {
+			t.Errorf("expected the first image to be the cover, got %s", item.ImageFileName)
+		}
+
+		got, err := repo.AddImages(ctx, item.ID, []string{"side.webp"})
+		if err != nil {
+			t.Fatalf("failed to add images: %v", err)
+		}
+		if diff := cmp.Diff([]string{"front.jpg", "back.png", "side.webp"}, got.Images); diff != "" {
+			t.Errorf("unexpected images (-want +got):\n%s", diff)
+		}
+
+		got, err = repo.ReorderImages(ctx, item.ID, []string{"side.webp", "front.jpg", "back.png"})
+		if err != nil {
+			t.Fatalf("failed to reorder images: %v", err)
+		}
+		if got.ImageFileName != "side.webp" {
+			t.Errorf("expected the new first image to be the cover, got %s", got.ImageFileName)
+		}
+
+		got, err = repo.RemoveImage(ctx, item.ID, "front.jpg")
+		if err != nil {
+			t.Fatalf("failed to remove image: %v", err)
+		}
+		selected, err := repo.Select(ctx, item.ID)
+		if err != nil {
+			t.Fatalf("failed to select item: %v", err)
+		}
+		if diff := cmp.Diff(got, selected); diff != "" {
+			t.Errorf("expected the stored item to match the returned one (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"side.webp", "back.png"}, selected.Images); diff != "" {
+			t.Errorf("unexpected images (-want +got):\n%s", diff)
+		}
+
+		cases := map[string]struct {
+			update  func() error
+			wantErr error
+		}{
+			"ng: unknown item": {
+				update:  func() error { _, err := repo.AddImages(ctx, 999999, []string{"x.jpg"}); return err },
+				wantErr: errItemNotFound,
+			},
+			"ng: too many images": {
+				update: func() error {
+					_, err := repo.AddImages(ctx, item.ID, make([]string, maxItemImages-1))
+					return err
+				},
+				wantErr: errTooManyImages,
+			},
+			"ng: not a permutation": {
+				update: func() error {
+					_, err := repo.ReorderImages(ctx, item.ID, []string{"side.webp", "side.webp"})
+					return err
+				},
+				wantErr: errImageOrderMismatch,
+			},
+			"ng: unknown image": {
+				update:  func() error { _, err := repo.RemoveImage(ctx, item.ID, "front.jpg"); return err },
+				wantErr: errImageNotFound,
+			},
+		}
+		for name, tt := range cases {
+			t.Run(name, func(t *testing.T) {
+				if err := tt.update(); !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected %v, got %v", tt.wantErr, err)
+				}
+			})
+		}
+
+		if _, err := repo.RemoveImage(ctx, item.ID, "side.webp"); err != nil {
+			t.Fatalf("failed to remove image: %v", err)
+		}
+		if _, err := repo.RemoveImage(ctx, item.ID, "back.png"); !errors.Is(err, errLastImage) {
+			t.Errorf("expected errLastImage, got %v", err)
+		}
+
+		// deleting the item deletes its images
+		if err := repo.Delete(ctx, item.ID); err != nil {
+			t.Fatalf("failed to delete item: %v", err)
+		}
+		var n int
+		if err := repo.db.QueryRow(repo.dialect.rebind(`SELECT COUNT(*) FROM item_images WHERE item_id = ?`), item.ID).Scan(&n); err != nil {
+			t.Fatalf("failed to count images: %v", err)
+		}
+		if n != 0 {
+			t.Errorf("expected the images of the deleted item to be gone, got %d", n)
+		}
+	})
+
+	t.Run("Images", func(t *testing.T) {
+		images := &imageRepository{db: repo.db, dialect: repo.dialect}
+
+		image := &ImageMetadata{Name: "0123.png", Format: ImageFormatPNG, Width: 640, Height: 480, Size: 12345}
+		if err := images.Insert(ctx, image); err != nil {
+			t.Fatalf("failed to insert image: %v", err)
+		}
+		// the same content-addressed image may be stored again, gaining what it lacked
+		image.PHash = "00000000ffffffff"
+		image.BlurHash, image.DominantColor = "LcE..23Ea|%5zRNMfQnUeqf7fQf7", "#1e2832"
+		if err := images.Insert(ctx, image); err != nil {
+			t.Fatalf("failed to insert image twice: %v", err)
+		}
+
+		got, err := images.Select(ctx, image.Name)
+		if err != nil {
+			t.Fatalf("failed to select image: %v", err)
+		}
+		if diff := cmp.Diff(image, got); diff != "" {
+			t.Errorf("unexpected image (-want +got):\n%s", diff)
+		}
+
+		if _, err := images.Select(ctx, "missing.png"); !errors.Is(err, errImageNotFound) {
+			t.Errorf("expected errImageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteUnreferenced", func(t *testing.T) {
+		images := &imageRepository{db: repo.db, dialect: repo.dialect}
+		for _, name := range []string{"kept.png", "orphan.png"} {
+			if err := images.Insert(ctx, &ImageMetadata{Name: name, Format: ImageFormatPNG, Width: 1, Height: 1, Size: 1}); err != nil {
+				t.Fatalf("failed to insert image: %v", err)
+			}
+		}
+		item := &Item{Name: "shawl", Category: strconv.Itoa(fashionID), Images: []string{"kept.png"}}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		deleted, err := images.DeleteUnreferenced(ctx, []string{"kept.png", "orphan.png", "unrecorded.png"})
+		if err != nil {
+			t.Fatalf("failed to delete unreferenced images: %v", err)
+		}
+		if diff := cmp.Diff([]string{"orphan.png", "unrecorded.png"}, deleted); diff != "" {
+			t.Errorf("unexpected deleted images (-want +got):\n%s", diff)
+		}
+		if _, err := images.Select(ctx, "orphan.png"); !errors.Is(err, errImageNotFound) {
+			t.Errorf("expected the metadata of the orphan to be deleted, got %v", err)
+		}
+		if _, err := images.Select(ctx, "kept.png"); err != nil {
+			t.Errorf("expected the metadata of the referenced image to be kept: %v", err)
+		}
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		images := &imageRepository{db: repo.db, dialect: repo.dialect}
+
+		item := &Item{Name: "watch", Category: strconv.Itoa(fashionID), Images: []string{"watch.jpg", "shared.jpg"}}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		if item.Status != ItemStatusPublic {
+			t.Errorf("expected a new item to be public, got %q", item.Status)
+		}
+		other := &Item{Name: "strap", Category: strconv.Itoa(fashionID), Images: []string{"shared.jpg"}, Status: ItemStatusDraft}
+		if err := repo.Insert(ctx, other); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		isPrivate := func(name string) bool {
+			t.Helper()
+			private, err := images.IsPrivate(ctx, name)
+			if err != nil {
+				t.Fatalf("failed to check image visibility: %v", err)
+			}
+			return private
+		}
+		if isPrivate("watch.jpg") || isPrivate("shared.jpg") || isPrivate("unused.jpg") {
+			t.Errorf("expected the images of a public item and unused images not to be private")
+		}
+
+		got, err := repo.UpdateStatus(ctx, item.ID, ItemStatusReserved)
+		if err != nil {
+			t.Fatalf("failed to update status: %v", err)
+		}
+		if got.Status != ItemStatusReserved || !cmp.Equal(got.Images, item.Images) {
+			t.Errorf("expected the reserved item with its images, got %+v", got)
+		}
+		if !isPrivate("watch.jpg") || !isPrivate("shared.jpg") {
+			t.Errorf("expected the images of items that are not public to be private")
+		}
+		if _, err := repo.UpdateStatus(ctx, 9999, ItemStatusDraft); !errors.Is(err, errItemNotFound) {
+			t.Errorf("expected errItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("SelectBySeller", func(t *testing.T) {
+		users := &userRepository{db: repo.db, dialect: repo.dialect}
+		seller := &User{Email: "seller@example.com", Name: "Seller", PasswordHash: "hash"}
+		if err := users.Insert(ctx, seller); err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+
+		first := &Item{Name: "belt", Category: strconv.Itoa(fashionID), ImageFileName: "belt.jpg", SellerID: seller.ID}
+		second := &Item{Name: "hat", Category: strconv.Itoa(fashionID), ImageFileName: "hat.jpg", SellerID: seller.ID, Status: ItemStatusDraft}
+		unowned := &Item{Name: "gloves", Category: strconv.Itoa(fashionID), ImageFileName: "gloves.jpg"}
+		for _, item := range []*Item{first, second, unowned} {
+			if err := repo.Insert(ctx, item); err != nil {
+				t.Fatalf("failed to insert item: %v", err)
+			}
+		}
+
+		got, err := repo.SelectBySeller(ctx, seller.ID)
+		if err != nil {
+			t.Fatalf("failed to select items of seller: %v", err)
+		}
+		if diff := cmp.Diff([]*Item{first, second}, got); diff != "" {
+			t.Errorf("unexpected items (-want +got):\n%s", diff)
+		}
+		if got, err := repo.Select(ctx, unowned.ID); err != nil || got.SellerID != 0 {
+			t.Errorf("expected an item without seller, got %+v: %v", got, err)
+		}
+	})
+
+	t.Run("SetHidden", func(t *testing.T) {
+		images := &imageRepository{db: repo.db, dialect: repo.dialect}
+		item := &Item{Name: "ring", Category: strconv.Itoa(fashionID), ImageFileName: "ring.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		got, err := repo.SetHidden(ctx, item.ID, true, "counterfeit")
+		if err != nil {
+			t.Fatalf("failed to hide item: %v", err)
+		}
+		if !got.Hidden || got.HiddenReason != "counterfeit" || got.Status != ItemStatusPublic {
+			t.Errorf("expected a hidden public item, got %+v", got)
+		}
+		if private, err := images.IsPrivate(ctx, "ring.jpg"); err != nil || !private {
+			t.Errorf("expected the images of a hidden item to be private, got %v, %v", private, err)
+		}
+
+		got, err = repo.SetHidden(ctx, item.ID, false, "")
+		if err != nil {
+			t.Fatalf("failed to show item: %v", err)
+		}
+		if got.Hidden || got.HiddenReason != "" {
+			t.Errorf("expected a visible item, got %+v", got)
+		}
+		if _, err := repo.SetHidden(ctx, 9999, true, "spam"); !errors.Is(err, errItemNotFound) {
+			t.Errorf("expected errItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Placeholder", func(t *testing.T) {
+		images := &imageRepository{db: repo.db, dialect: repo.dialect}
+
+		cover := &ImageMetadata{Name: "scarf.jpg", Format: ImageFormatJPEG, Width: 2, Height: 2, Size: 1,
+			BlurHash: "L~TSUA~qfQ~q~q%MfQ%MfQfQfQfQ", DominantColor: "#ffffff"}
+		if err := images.Insert(ctx, cover); err != nil {
+			t.Fatalf("failed to insert image: %v", err)
+		}
+		item := &Item{Name: "scarf", Category: strconv.Itoa(fashionID), Images: []string{"scarf.jpg", "scarf-back.jpg"}}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		unknown := &Item{Name: "scarf (no metadata)", Category: strconv.Itoa(fashionID), Images: []string{"scarf-back.jpg"}}
+		if err := repo.Insert(ctx, unknown); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+
+		got, err := repo.Select(ctx, item.ID)
+		if err != nil {
+			t.Fatalf("failed to select item: %v", err)
+		}
+		if got.BlurHash != cover.BlurHash || got.DominantColor != cover.DominantColor {
+			t.Errorf("expected the placeholder of the cover, got %q, %q", got.BlurHash, got.DominantColor)
+		}
+		found, err := repo.SearchItemsByName(ctx, "scarf")
+		if err != nil {
+			t.Fatalf("failed to search items: %v", err)
+		}
+		if len(found) != 2 || found[0].BlurHash != cover.BlurHash || found[1].BlurHash != "" {
+			t.Errorf("expected the placeholder of the first item only, got %+v", found)
+		}
+	})
+
+	t.Run("FindSimilar", func(t *testing.T) {
+		images := &imageRepository{db: repo.db, dialect: repo.dialect}
+
+		phashes := map[string]string{
+			"original.jpg": "f0f0f0f0f0f0f0f0",
+			"resized.jpg":  "f0f0f0f0f0f0f0f1",
+			"other.jpg":    "0f0f0f0f0f0f0f0f",
+			"legacy.jpg":   "",
+		}
+		for name, phash := range phashes {
+			image := &ImageMetadata{Name: name, Format: ImageFormatJPEG, Width: 2, Height: 2, Size: 1, PHash: phash}
+			if err := images.Insert(ctx, image); err != nil {
+				t.Fatalf("failed to insert image: %v", err)
+			}
+		}
+		original := &Item{Name: "camera", Category: strconv.Itoa(phoneID), ImageFileName: "original.jpg", Images: []string{"original.jpg"}}
+		// drafts are found too, and left for the handlers to filter out
+		copied := &Item{Name: "camera!", Category: strconv.Itoa(phoneID), ImageFileName: "resized.jpg", Images: []string{"resized.jpg", "other.jpg", "legacy.jpg"}, Status: ItemStatusDraft}
+		for _, item := range []*Item{original, copied} {
+			if err := repo.Insert(ctx, item); err != nil {
+				t.Fatalf("failed to insert item: %v", err)
+			}
+		}
+
+		got, err := images.FindSimilar(ctx, []string{"original.jpg"}, similarImageMaxDistance)
+		if err != nil {
+			t.Fatalf("failed to find similar images: %v", err)
+		}
+		want := []SimilarImage{
+			{ItemID: original.ID, ImageName: "original.jpg", SimilarTo: "original.jpg", Distance: 0, Status: ItemStatusPublic},
+			{ItemID: copied.ID, ImageName: "resized.jpg", SimilarTo: "original.jpg", Distance: 1, Status: ItemStatusDraft},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected similar images (-want +got):\n%s", diff)
+		}
+
+		// every image is compared with the closest of the names
+		got, err = images.FindSimilar(ctx, []string{"other.jpg", "original.jpg", "legacy.jpg"}, similarImageMaxDistance)
+		if err != nil {
+			t.Fatalf("failed to find similar images: %v", err)
+		}
+		want = []SimilarImage{
+			{ItemID: original.ID, ImageName: "original.jpg", SimilarTo: "original.jpg", Distance: 0, Status: ItemStatusPublic},
+			{ItemID: copied.ID, ImageName: "other.jpg", SimilarTo: "other.jpg", Distance: 0, Status: ItemStatusDraft},
+			{ItemID: copied.ID, ImageName: "resized.jpg", SimilarTo: "original.jpg", Distance: 1, Status: ItemStatusDraft},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected similar images (-want +got):\n%s", diff)
+		}
+
+		if _, err := repo.SetHidden(ctx, original.ID, true, "counterfeit"); err != nil {
+			t.Fatalf("failed to hide item: %v", err)
+		}
+		got, err = images.FindSimilar(ctx, []string{"original.jpg"}, similarImageMaxDistance)
+		if err != nil {
+			t.Fatalf("failed to find similar images: %v", err)
+		}
+		if len(got) == 0 || got[0].ItemID != original.ID || !got[0].Hidden {
+			t.Errorf("expected the hidden item to be flagged, got %+v", got)
+		}
+
+		if got, err := images.FindSimilar(ctx, []string{"legacy.jpg"}, similarImageMaxDistance); err != nil || len(got) != 0 {
+			t.Errorf("expected nothing for an image without a perceptual hash, got %v, %v", got, err)
+		}
+		if _, err := images.FindSimilar(ctx, []string{"original.jpg", "missing.jpg"}, similarImageMaxDistance); !errors.Is(err, errImageNotFound) {
+			t.Errorf("expected errImageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Categories", func(t *testing.T) {
+		categories := &categoryRepository{db: repo.db, dialect: repo.dialect}
+
+		c := &Category{Name: "books"}
+		if err := categories.Insert(ctx, c); err != nil {
+			t.Fatalf("failed to insert category: %v", err)
+		}
+		if c.ID == 0 {
+			t.Errorf("expected category ID to be set")
+		}
+		if err := categories.Insert(ctx, &Category{Name: "books"}); !errors.Is(err, errCategoryExists) {
+			t.Errorf("expected errCategoryExists, got %v", err)
+		}
+
+		got, err := categories.List(ctx)
+		if err != nil {
+			t.Fatalf("failed to list categories: %v", err)
+		}
+		want := []*Category{{ID: fashionID, Name: "fashion"}, {ID: phoneID, Name: "phone"}, c}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected categories (-want +got):\n%s", diff)
+		}
+	})
+}
+
+// newTestItemRepository opens dsn, applies the schema and returns a repository on top of it.
+func newTestItemRepository(t *testing.T, dsn string) *itemRepository {
+	t.Helper()
+
+	db, d, err := openDatabase(DatabaseConfig{DSN: dsn, BusyTimeout: 5 * time.Second, MaxOpenConns: 4})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := migrateUp(context.Background(), db, d, testMigrationsDir); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return &itemRepository{db: db, dialect: d}
+}
+
+func insertTestCategory(t *testing.T, repo *itemRepository, name string) int {
+	t.Helper()
+
+	var id int
+	query := repo.dialect.rebind(`INSERT INTO categories (name) VALUES (?) RETURNING id`)
+	if err := repo.db.QueryRow(query, name).Scan(&id); err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+	return id
+}
+
+// sqliteTestDSN returns the path of a fresh SQLite database file.
+func sqliteTestDSN(t *testing.T) string {
+	t.Helper()
+	return filepath.Join(t.TempDir(), "mercari.sqlite3")
+}
+
+// postgresTestDSN returns the DSN of a PostgreSQL database for tests.
+// POSTGRES_TEST_DSN is used when set; otherwise a throwaway server is started
+// with initdb and pg_ctl from PATH. The test is skipped when neither is available.
+func postgresTestDSN(t *testing.T) string {
+	t.Helper()
+
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		return dsn
+	}
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		t.Skip("postgres is not available: initdb not found in PATH")
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		t.Skip("postgres is not available: pg_ctl not found in PATH")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("postgres is not available: initdb refuses to run as root")
+	}
+
+	dataDir := t.TempDir()
+	port := freeTCPPort(t)
+
+	out, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to run initdb: %v\n%s", err, out)
+	}
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dataDir)
+	out, err = exec.Command(pgCtl, "-D", dataDir, "-o", opts, "-l", filepath.Join(dataDir, "server.log"), "-w", "start").CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to start postgres: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		exec.Command(pgCtl, "-D", dataDir, "-m", "immediate", "-w", "stop").Run()
+	})
+
+	dsn := fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	db, err := sql.Open(dialectPostgres.driverName(), dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %v", err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatalf("failed to ping postgres: %v", err)
+	}
+	return dsn
+}
+
+func freeTCPPort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestDialectRebind(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		dialect dialect
+		query   string
+		want    string
+	}{
+		"sqlite3 keeps question marks": {
+			dialect: dialectSQLite,
+			query:   "SELECT * FROM items WHERE id = ? AND name = ?",
+			want:    "SELECT * FROM items WHERE id = ? AND name = ?",
+		},
+		"postgres numbers placeholders": {
+			dialect: dialectPostgres,
+			query:   "SELECT * FROM items WHERE id = ? AND name = ?",
+			want:    "SELECT * FROM items WHERE id = $1 AND name = $2",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.dialect.rebind(tt.query); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		dsn         string
+		wantDialect dialect
+		wantSource  string
+		wantErr     bool
+	}{
+		"ok: bare path":      {dsn: "db/mercari.sqlite3", wantDialect: dialectSQLite, wantSource: "db/mercari.sqlite3"},
+		"ok: sqlite3 scheme": {dsn: "sqlite3://db/mercari.sqlite3", wantDialect: dialectSQLite, wantSource: "db/mercari.sqlite3"},
+		"ok: postgres":       {dsn: "postgres://u@localhost/db", wantDialect: dialectPostgres, wantSource: "postgres://u@localhost/db"},
+		"ok: postgresql":     {dsn: "postgresql://u@localhost/db", wantDialect: dialectPostgres, wantSource: "postgresql://u@localhost/db"},
+		"ng: unknown scheme": {dsn: "mysql://u@localhost/db", wantErr: true},
+		"ng: empty":          {dsn: "", wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d, source, err := parseDSN(tt.dsn)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatalf("expected an error, got dialect %s", d)
+			}
+			if d != tt.wantDialect || source != tt.wantSource {
+				t.Errorf("expected (%s, %q), got (%s, %q)", tt.wantDialect, tt.wantSource, d, source)
+			}
+		})
+	}
+}
+
+// TestSQLiteFeatures checks that the SQLite driver selected at build time
+// (mattn/go-sqlite3 by default, modernc.org/sqlite with -tags purego)
+// provides the full-text search and JSON functions the application relies on.
+// mattn/go-sqlite3 only ships FTS5 when built with -tags sqlite_fts5, as the Dockerfile does.
+func TestSQLiteFeatures(t *testing.T) {
+	t.Parallel()
+
+	db, err := sql.Open(sqliteDriverName, filepath.Join(t.TempDir(), "features.sqlite3"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	t.Run("full-text search", func(t *testing.T) {
+		var fts5 bool
+		if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+			t.Fatalf("failed to read compile options: %v", err)
+		}
+		if !fts5 {
+			t.Skip("FTS5 is not compiled in; build with -tags sqlite_fts5")
+		}
+		if _, err := db.Exec(`CREATE VIRTUAL TABLE items_fts USING fts5(name)`); err != nil {
+			t.Fatalf("failed to create fts table: %v", err)
+		}
+		if _, err := db.Exec(`INSERT INTO items_fts (name) VALUES ('used iPhone 16e'), ('vintage jacket')`); err != nil {
+			t.Fatalf("failed to insert into fts table: %v", err)
+		}
+		var name string
+		if err := db.QueryRow(`SELECT name FROM items_fts WHERE items_fts MATCH 'jacket'`).Scan(&name); err != nil {
+			t.Fatalf("failed to query fts table: %v", err)
+		}
+		if name != "vintage jacket" {
+			t.Errorf("expected vintage jacket, got %s", name)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var name string
+		if err := db.QueryRow(`SELECT json_extract('{"item":{"name":"jacket"}}', '$.item.name')`).Scan(&name); err != nil {
+			t.Fatalf("failed to evaluate json_extract: %v", err)
+		}
+		if name != "jacket" {
+			t.Errorf("expected jacket, got %s", name)
+		}
+	})
+}
+
+func TestSQLiteConnectionSettings(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestItemRepository(t, sqliteTestDSN(t))
+	categoryID := insertTestCategory(t, repo, "fashion")
+
+	t.Run("pragmas", func(t *testing.T) {
+		var journalMode string
+		if err := repo.db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil {
+			t.Fatalf("failed to read journal_mode: %v", err)
+		}
+		if journalMode != "wal" {
+			t.Errorf("expected journal_mode wal, got %s", journalMode)
+		}
+
+		var foreignKeys int
+		if err := repo.db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+			t.Fatalf("failed to read foreign_keys: %v", err)
+		}
+		if foreignKeys != 1 {
+			t.Errorf("expected foreign_keys 1, got %d", foreignKeys)
+		}
+	})
+
+	t.Run("foreign keys are enforced", func(t *testing.T) {
+		_, err := repo.db.Exec(`INSERT INTO items (name, category_id, image_name) VALUES ('ghost', 9999, 'ghost.jpg')`)
+		if err == nil {
+			t.Errorf("expected a foreign key violation")
+		}
+	})
+
+	t.Run("concurrent inserts", func(t *testing.T) {
+		const n = 20
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				item := &Item{Name: fmt.Sprintf("item %d", i), Category: strconv.Itoa(categoryID), ImageFileName: "item.jpg"}
+				errs <- repo.Insert(context.Background(), item)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("failed to insert item concurrently: %v", err)
+			}
+		}
+	})
+}
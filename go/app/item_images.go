@@ -0,0 +1,213 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// storeImages stores every image and returns their file names in the same order, along with
+// a function removing the images written by this call, to roll back when the item referencing
+// them cannot be saved. Images reused from earlier uploads are left alone, and so are those
+// that another item came to reference in the meantime (see removeImages).
+// When storing an image fails, the images already written are removed.
+func (s *Handlers) storeImages(ctx context.Context, images [][]byte) ([]string, func(), error) {
+	names := make([]string, 0, len(images))
+	var created []string
+	rollback := func() { s.removeImages(ctx, created) }
+	for i, image := range images {
+		name, ok, err := s.putImage(ctx, image)
+		if err != nil {
+			rollback()
+			return nil, nil, fmt.Errorf("image %d: %w", i+1, err)
+		}
+		names = append(names, name)
+		// the same image may be uploaded twice in a request
+		if ok && !slices.Contains(created, name) {
+			created = append(created, name)
+		}
+	}
+	return names, rollback, nil
+}
+
+// removeImages deletes images stored for a request that failed, together with their variants.
+// Another request may have uploaded the same content and saved an item showing it since, so
+// only the images that no item references are deleted; if that cannot be checked, they are
+// left to the garbage collection. It runs even when ctx is cancelled, which is often why the
+// request failed.
+func (s *Handlers) removeImages(ctx context.Context, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	names, err := s.imageRepo.DeleteUnreferenced(ctx, names)
+	if err != nil {
+		slog.Warn("failed to check the images of a failed request, leaving them", "error", err)
+		return
+	}
+	for _, name := range names {
+		keys := []string{name}
+		for _, width := range thumbnailWidths {
+			if key, err := variantKey(name, width); err == nil {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			if err := s.images.Delete(ctx, key); err != nil {
+				slog.Warn("failed to remove image", "name", key, "error", err)
+			}
+		}
+		slog.Info("removed image of a failed request", "name", name)
+	}
+}
+
+// writeStoreImageError reports an error returned by storeImages:
+// 415 for uploads that are not supported images, 422 for images over the pixel budget
+// and 500 otherwise.
+func writeStoreImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedImage):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, errImageTooLarge):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	slog.Error("failed to save image", "error", err)
+	http.Error(w, "Failed to save image", http.StatusInternalServerError)
+}
+
+// itemImagesErrorStatus maps an error returned by the image methods of ItemRepository, or by
+// authorizeItemChange, to an HTTP status code.
+func itemImagesErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errItemNotFound), errors.Is(err, errImageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errTooManyImages), errors.Is(err, errImageOrderMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, errLastImage):
+		return http.StatusConflict
+	default:
+		return repositoryErrorStatus(err)
+	}
+}
+
+// parseItemID reads the item_id path value.
+func parseItemID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("item_id"))
+	if err != nil {
+		return 0, errors.New("invalid item_id")
+	}
+	return id, nil
+}
+
+// writeItem writes the item, with its image URLs, as the JSON response.
+func (s *Handlers) writeItem(w http.ResponseWriter, item *Item) {
+	s.setImageURLs(item)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"item": item}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// AddItemImages is a handler to append the images uploaded as "image" parts of a
+// multipart form to an item for POST /items/{item_id}/images .
+func (s *Handlers) AddItemImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseItemID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// before storing anything for an item the user may not change
+	if _, err := s.authorizeItemChange(ctx, id); err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+	s.limitUploadBody(w, r)
+	if err := r.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		writeUploadError(w, fmt.Errorf("failed to parse multipart form: %w", err))
+		return
+	}
+	images, err := readImageParts(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	names, rollback, err := s.storeImages(ctx, images)
+	if err != nil {
+		writeStoreImageError(w, err)
+		return
+	}
+
+	item, err := s.itemRepo.AddImages(ctx, id, names)
+	if err != nil {
+		rollback()
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+	s.writeItem(w, item)
+}
+
+// RemoveItemImage is a handler to remove an image from an item for
+// DELETE /items/{item_id}/images/{filename} . The last image of an item cannot be removed.
+func (s *Handlers) RemoveItemImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseItemID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.authorizeItemChange(ctx, id); err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+
+	item, err := s.itemRepo.RemoveImage(ctx, id, r.PathValue("filename"))
+	if err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+	s.writeItem(w, item)
+}
+
+// ReorderItemImagesRequest lists every image of an item in the new display order.
+type ReorderItemImagesRequest struct {
+	Images []string `json:"images"`
+}
+
+// ReorderItemImages is a handler to change the display order of the images of an item
+// for PUT /items/{item_id}/images . The first image becomes the cover.
+func (s *Handlers) ReorderItemImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseItemID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req ReorderItemImagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.authorizeItemChange(ctx, id); err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+
+	item, err := s.itemRepo.ReorderImages(ctx, id, req.Images)
+	if err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+	s.writeItem(w, item)
+}
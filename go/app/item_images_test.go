@@ -0,0 +1,473 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddItemImages(t *testing.T) {
+	t.Parallel()
+
+	image := testPNG(t, 4, 4)
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemID   string
+		images   [][]byte
+		injector func(m *MockItemRepository, mi *MockImageRepository)
+		wants
+	}{
+		"ok: images appended": {
+			itemID: "1",
+			images: [][]byte{image, image},
+			injector: func(m *MockItemRepository, mi *MockImageRepository) {
+				mi.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+				m.EXPECT().
+					AddImages(gomock.Any(), 1, gomock.Len(2)).
+					Return(&Item{ID: 1, Images: []string{"a.jpg", "b.png", "b.png"}}, nil).Times(1)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: too many images": {
+			itemID: "1",
+			images: [][]byte{image},
+			injector: func(m *MockItemRepository, mi *MockImageRepository) {
+				mi.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				m.EXPECT().AddImages(gomock.Any(), 1, gomock.Any()).Return(nil, errTooManyImages).Times(1)
+			},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: item not found": {
+			itemID: "42",
+			images: [][]byte{image},
+			injector: func(m *MockItemRepository, mi *MockImageRepository) {
+				mi.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				m.EXPECT().AddImages(gomock.Any(), 42, gomock.Any()).Return(nil, errItemNotFound).Times(1)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: not an image": {
+			itemID:   "1",
+			images:   [][]byte{[]byte("plain text")},
+			injector: func(m *MockItemRepository, mi *MockImageRepository) {},
+			wants: wants{
+				code: http.StatusUnsupportedMediaType,
+			},
+		},
+		"ng: no image": {
+			itemID:   "1",
+			injector: func(m *MockItemRepository, mi *MockImageRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			expectOwnedItems(mockIR)
+			mockImR := NewMockImageRepository(ctrl)
+			expectUnreferencedImages(mockImR)
+			tt.injector(mockIR, mockImR)
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR, imageRepo: mockImR}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			for _, image := range tt.images {
+				part, err := writer.CreateFormFile("image", "photo")
+				if err != nil {
+					t.Fatalf("failed to create form file: %v", err)
+				}
+				part.Write(image)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("failed to close writer: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/items/"+tt.itemID+"/images", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.SetPathValue("item_id", tt.itemID)
+			res := httptest.NewRecorder()
+
+			h.AddItemImages(res, asUser(req, testSeller))
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+		})
+	}
+}
+
+func TestRemoveItemImage(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemID   string
+		filename string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: image removed": {
+			itemID:   "1",
+			filename: "b.png",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					RemoveImage(gomock.Any(), 1, "b.png").
+					Return(&Item{ID: 1, ImageFileName: "a.jpg", Images: []string{"a.jpg"}}, nil).Times(1)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: last image": {
+			itemID:   "1",
+			filename: "a.jpg",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().RemoveImage(gomock.Any(), 1, "a.jpg").Return(nil, errLastImage).Times(1)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: image not on item": {
+			itemID:   "1",
+			filename: "c.gif",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().RemoveImage(gomock.Any(), 1, "c.gif").Return(nil, errImageNotFound).Times(1)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: invalid item_id": {
+			itemID:   "abc",
+			filename: "a.jpg",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			expectOwnedItems(mockIR)
+			tt.injector(mockIR)
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+
+			req := httptest.NewRequest("DELETE", "/items/"+tt.itemID+"/images/"+tt.filename, nil)
+			req.SetPathValue("item_id", tt.itemID)
+			req.SetPathValue("filename", tt.filename)
+			res := httptest.NewRecorder()
+
+			h.RemoveItemImage(res, asUser(req, testSeller))
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+		})
+	}
+}
+
+func TestReorderItemImages(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		body     string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: images reordered": {
+			body: `{"images": ["b.png", "a.jpg"]}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					ReorderImages(gomock.Any(), 1, []string{"b.png", "a.jpg"}).
+					Return(&Item{ID: 1, ImageFileName: "b.png", Images: []string{"b.png", "a.jpg"}}, nil).Times(1)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: not a permutation": {
+			body: `{"images": ["b.png"]}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().ReorderImages(gomock.Any(), 1, []string{"b.png"}).Return(nil, errImageOrderMismatch).Times(1)
+			},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: invalid body": {
+			body:     `["b.png"`,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			expectOwnedItems(mockIR)
+			tt.injector(mockIR)
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+
+			req := httptest.NewRequest("PUT", "/items/1/images", strings.NewReader(tt.body))
+			req.SetPathValue("item_id", "1")
+			res := httptest.NewRecorder()
+
+			h.ReorderItemImages(res, asUser(req, testSeller))
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+		})
+	}
+}
+
+// expectUnreferencedImages makes m report that no item references the images of a failed request.
+func expectUnreferencedImages(m *MockImageRepository) {
+	m.EXPECT().DeleteUnreferenced(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, names []string) ([]string, error) {
+		return names, nil
+	}).AnyTimes()
+}
+
+func TestStoreImages(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	image := testPNG(t, 400, 200)
+	name := fmt.Sprintf("%x.png", sha256.Sum256(image))
+	variant, err := variantKey(name, 150)
+	if err != nil {
+		t.Fatalf("failed to get variant key: %v", err)
+	}
+
+	setup := func(t *testing.T, insertErr error) (*Handlers, ImageStore) {
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+		mockImR := NewMockImageRepository(ctrl)
+		mockImR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(insertErr).AnyTimes()
+		expectUnreferencedImages(mockImR)
+		images := NewFileImageStore(t.TempDir())
+		return &Handlers{images: images, imageRepo: mockImR}, images
+	}
+	exists := func(t *testing.T, images ImageStore, key string) bool {
+		t.Helper()
+		ok, err := images.Exists(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to check %s: %v", key, err)
+		}
+		return ok
+	}
+
+	t.Run("ok: rolled back", func(t *testing.T) {
+		t.Parallel()
+
+		h, images := setup(t, nil)
+		names, rollback, err := h.storeImages(ctx, [][]byte{image, image})
+		if err != nil {
+			t.Fatalf("failed to store images: %v", err)
+		}
+		if diff := cmp.Diff([]string{name, name}, names); diff != "" {
+			t.Errorf("unexpected names (-want +got):\n%s", diff)
+		}
+		if !exists(t, images, name) || !exists(t, images, variant) {
+			t.Fatalf("expected the image and its variant to be stored")
+		}
+		rollback()
+		if exists(t, images, name) || exists(t, images, variant) {
+			t.Errorf("expected the image and its variant to be removed")
+		}
+	})
+
+	t.Run("ok: intact copy reused and kept", func(t *testing.T) {
+		t.Parallel()
+
+		h, images := setup(t, nil)
+		if err := images.Put(ctx, name, image); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+		_, rollback, err := h.storeImages(ctx, [][]byte{image})
+		if err != nil {
+			t.Fatalf("failed to store images: %v", err)
+		}
+		rollback()
+		if !exists(t, images, name) {
+			t.Errorf("expected the image stored earlier to be kept")
+		}
+	})
+
+	t.Run("ok: image referenced since kept", func(t *testing.T) {
+		t.Parallel()
+
+		repo := newTestItemRepository(t, sqliteTestDSN(t))
+		categoryID := insertTestCategory(t, repo, "fashion")
+		images := NewFileImageStore(t.TempDir())
+		h := &Handlers{images: images, imageRepo: &imageRepository{db: repo.db, dialect: repo.dialect}}
+
+		names, rollback, err := h.storeImages(ctx, [][]byte{image})
+		if err != nil {
+			t.Fatalf("failed to store images: %v", err)
+		}
+		// another request uploads the same image and saves its item before the rollback
+		item := &Item{Name: "jacket", Category: strconv.Itoa(categoryID), Images: names}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		rollback()
+		if !exists(t, images, name) || !exists(t, images, variant) {
+			t.Errorf("expected the image of the other item and its variant to be kept")
+		}
+	})
+
+	t.Run("ok: reused copy made young again", func(t *testing.T) {
+		t.Parallel()
+
+		h, images := setup(t, nil)
+		if err := images.Put(ctx, name, image); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+		p := filepath.Join(images.(*fileImageStore).dir, name)
+		old := time.Now().Add(-48 * time.Hour)
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatalf("failed to age image: %v", err)
+		}
+		if _, _, err := h.storeImages(ctx, [][]byte{image}); err != nil {
+			t.Fatalf("failed to store images: %v", err)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("failed to stat image: %v", err)
+		}
+		if !info.ModTime().After(time.Now().Add(-time.Hour)) {
+			t.Errorf("expected the reused image to be spared by the garbage collection, modified at %v", info.ModTime())
+		}
+	})
+
+	t.Run("ok: damaged copy replaced", func(t *testing.T) {
+		t.Parallel()
+
+		h, images := setup(t, nil)
+		if err := images.Put(ctx, name, image[:len(image)/2]); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+		if _, _, err := h.storeImages(ctx, [][]byte{image}); err != nil {
+			t.Fatalf("failed to store images: %v", err)
+		}
+		stored, err := images.Get(ctx, name)
+		if err != nil {
+			t.Fatalf("failed to get image: %v", err)
+		}
+		if !bytes.Equal(stored, image) {
+			t.Errorf("expected the truncated copy to be replaced, got %d of %d bytes", len(stored), len(image))
+		}
+	})
+
+	t.Run("ng: metadata not recorded", func(t *testing.T) {
+		t.Parallel()
+
+		h, images := setup(t, errors.New("insert failed"))
+		if _, _, err := h.storeImages(ctx, [][]byte{image}); err == nil {
+			t.Fatalf("expected an error")
+		}
+		if exists(t, images, name) || exists(t, images, variant) {
+			t.Errorf("expected nothing to be left behind")
+		}
+	})
+}
+
+func TestAddItemRollback(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(errors.New("insert failed")).Times(1)
+	mockImR := NewMockImageRepository(ctrl)
+	mockImR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	expectUnreferencedImages(mockImR)
+	imgDirPath := t.TempDir()
+	h := &Handlers{images: NewFileImageStore(imgDirPath), itemRepo: mockIR, imageRepo: mockImR}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("name", "jacket")
+	writer.WriteField("category", "fashion")
+	part, err := writer.CreateFormFile("image", "photo.png")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(testPNG(t, 400, 200))
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/items", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	res := httptest.NewRecorder()
+
+	h.AddItem(res, asUser(req, testSeller))
+
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, res.Code)
+	}
+	var left []string
+	filepath.WalkDir(imgDirPath, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			left = append(left, p)
+		}
+		return nil
+	})
+	if len(left) != 0 {
+		t.Errorf("expected the stored images to be removed, got %v", left)
+	}
+}
@@ -6,6 +6,7 @@ package app
 
 import (
 	context "context"
+	sql "database/sql"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,6 +35,49 @@ func (m *MockItemRepository) EXPECT() *MockItemRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AddImages mocks base method.
+func (m *MockItemRepository) AddImages(ctx context.Context, id int, names []string) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddImages", ctx, id, names)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddImages indicates an expected call of AddImages.
+func (mr *MockItemRepositoryMockRecorder) AddImages(ctx, id, names interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddImages", reflect.TypeOf((*MockItemRepository)(nil).AddImages), ctx, id, names)
+}
+
+// Delete mocks base method.
+func (m *MockItemRepository) Delete(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockItemRepositoryMockRecorder) Delete(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockItemRepository)(nil).Delete), ctx, id)
+}
+
+// EachItem mocks base method.
+func (m *MockItemRepository) EachItem(ctx context.Context, keyword string, fn func(*Item) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EachItem", ctx, keyword, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EachItem indicates an expected call of EachItem.
+func (mr *MockItemRepositoryMockRecorder) EachItem(ctx, keyword, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EachItem", reflect.TypeOf((*MockItemRepository)(nil).EachItem), ctx, keyword, fn)
+}
+
 // Insert mocks base method.
 func (m *MockItemRepository) Insert(ctx context.Context, item *Item) error {
 	m.ctrl.T.Helper()
@@ -48,6 +92,20 @@ func (mr *MockItemRepositoryMockRecorder) Insert(ctx, item interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockItemRepository)(nil).Insert), ctx, item)
 }
 
+// InsertBatch mocks base method.
+func (m *MockItemRepository) InsertBatch(ctx context.Context, items []*Item) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertBatch", ctx, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertBatch indicates an expected call of InsertBatch.
+func (mr *MockItemRepositoryMockRecorder) InsertBatch(ctx, items interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertBatch", reflect.TypeOf((*MockItemRepository)(nil).InsertBatch), ctx, items)
+}
+
 // LoadItems mocks base method.
 func (m *MockItemRepository) LoadItems(ctx context.Context) ([]*Item, error) {
 	m.ctrl.T.Helper()
@@ -63,17 +121,299 @@ func (mr *MockItemRepositoryMockRecorder) LoadItems(ctx interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadItems", reflect.TypeOf((*MockItemRepository)(nil).LoadItems), ctx)
 }
 
+// RemoveImage mocks base method.
+func (m *MockItemRepository) RemoveImage(ctx context.Context, id int, name string) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveImage", ctx, id, name)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveImage indicates an expected call of RemoveImage.
+func (mr *MockItemRepositoryMockRecorder) RemoveImage(ctx, id, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveImage", reflect.TypeOf((*MockItemRepository)(nil).RemoveImage), ctx, id, name)
+}
+
+// ReorderImages mocks base method.
+func (m *MockItemRepository) ReorderImages(ctx context.Context, id int, names []string) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReorderImages", ctx, id, names)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReorderImages indicates an expected call of ReorderImages.
+func (mr *MockItemRepositoryMockRecorder) ReorderImages(ctx, id, names interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReorderImages", reflect.TypeOf((*MockItemRepository)(nil).ReorderImages), ctx, id, names)
+}
+
 // SearchItemsByName mocks base method.
-func (m *MockItemRepository) SearchItemsByName(keyword string) ([]*Item, error) {
+func (m *MockItemRepository) SearchItemsByName(ctx context.Context, keyword string) ([]*Item, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SearchItemsByName", keyword)
+	ret := m.ctrl.Call(m, "SearchItemsByName", ctx, keyword)
 	ret0, _ := ret[0].([]*Item)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // SearchItemsByName indicates an expected call of SearchItemsByName.
-func (mr *MockItemRepositoryMockRecorder) SearchItemsByName(keyword interface{}) *gomock.Call {
+func (mr *MockItemRepositoryMockRecorder) SearchItemsByName(ctx, keyword interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchItemsByName", reflect.TypeOf((*MockItemRepository)(nil).SearchItemsByName), ctx, keyword)
+}
+
+// Select mocks base method.
+func (m *MockItemRepository) Select(ctx context.Context, id int) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Select", ctx, id)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Select indicates an expected call of Select.
+func (mr *MockItemRepositoryMockRecorder) Select(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Select", reflect.TypeOf((*MockItemRepository)(nil).Select), ctx, id)
+}
+
+// SelectBySeller mocks base method.
+func (m *MockItemRepository) SelectBySeller(ctx context.Context, sellerID int) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SelectBySeller", ctx, sellerID)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SelectBySeller indicates an expected call of SelectBySeller.
+func (mr *MockItemRepositoryMockRecorder) SelectBySeller(ctx, sellerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SelectBySeller", reflect.TypeOf((*MockItemRepository)(nil).SelectBySeller), ctx, sellerID)
+}
+
+// SetHidden mocks base method.
+func (m *MockItemRepository) SetHidden(ctx context.Context, id int, hidden bool, reason string) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHidden", ctx, id, hidden, reason)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetHidden indicates an expected call of SetHidden.
+func (mr *MockItemRepositoryMockRecorder) SetHidden(ctx, id, hidden, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHidden", reflect.TypeOf((*MockItemRepository)(nil).SetHidden), ctx, id, hidden, reason)
+}
+
+// UpdateStatus mocks base method.
+func (m *MockItemRepository) UpdateStatus(ctx context.Context, id int, status ItemStatus) (*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateStatus", ctx, id, status)
+	ret0, _ := ret[0].(*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateStatus indicates an expected call of UpdateStatus.
+func (mr *MockItemRepositoryMockRecorder) UpdateStatus(ctx, id, status interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateStatus", reflect.TypeOf((*MockItemRepository)(nil).UpdateStatus), ctx, id, status)
+}
+
+// MockCategoryRepository is a mock of CategoryRepository interface.
+type MockCategoryRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockCategoryRepositoryMockRecorder
+}
+
+// MockCategoryRepositoryMockRecorder is the mock recorder for MockCategoryRepository.
+type MockCategoryRepositoryMockRecorder struct {
+	mock *MockCategoryRepository
+}
+
+// NewMockCategoryRepository creates a new mock instance.
+func NewMockCategoryRepository(ctrl *gomock.Controller) *MockCategoryRepository {
+	mock := &MockCategoryRepository{ctrl: ctrl}
+	mock.recorder = &MockCategoryRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCategoryRepository) EXPECT() *MockCategoryRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Insert mocks base method.
+func (m *MockCategoryRepository) Insert(ctx context.Context, category *Category) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Insert", ctx, category)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Insert indicates an expected call of Insert.
+func (mr *MockCategoryRepositoryMockRecorder) Insert(ctx, category interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockCategoryRepository)(nil).Insert), ctx, category)
+}
+
+// List mocks base method.
+func (m *MockCategoryRepository) List(ctx context.Context) ([]*Category, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]*Category)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockCategoryRepositoryMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockCategoryRepository)(nil).List), ctx)
+}
+
+// MockImageRepository is a mock of ImageRepository interface.
+type MockImageRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockImageRepositoryMockRecorder
+}
+
+// MockImageRepositoryMockRecorder is the mock recorder for MockImageRepository.
+type MockImageRepositoryMockRecorder struct {
+	mock *MockImageRepository
+}
+
+// NewMockImageRepository creates a new mock instance.
+func NewMockImageRepository(ctrl *gomock.Controller) *MockImageRepository {
+	mock := &MockImageRepository{ctrl: ctrl}
+	mock.recorder = &MockImageRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImageRepository) EXPECT() *MockImageRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteUnreferenced mocks base method.
+func (m *MockImageRepository) DeleteUnreferenced(ctx context.Context, names []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUnreferenced", ctx, names)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteUnreferenced indicates an expected call of DeleteUnreferenced.
+func (mr *MockImageRepositoryMockRecorder) DeleteUnreferenced(ctx, names interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUnreferenced", reflect.TypeOf((*MockImageRepository)(nil).DeleteUnreferenced), ctx, names)
+}
+
+// FindSimilar mocks base method.
+func (m *MockImageRepository) FindSimilar(ctx context.Context, names []string, maxDistance int) ([]SimilarImage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindSimilar", ctx, names, maxDistance)
+	ret0, _ := ret[0].([]SimilarImage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindSimilar indicates an expected call of FindSimilar.
+func (mr *MockImageRepositoryMockRecorder) FindSimilar(ctx, names, maxDistance interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindSimilar", reflect.TypeOf((*MockImageRepository)(nil).FindSimilar), ctx, names, maxDistance)
+}
+
+// Insert mocks base method.
+func (m *MockImageRepository) Insert(ctx context.Context, image *ImageMetadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Insert", ctx, image)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Insert indicates an expected call of Insert.
+func (mr *MockImageRepositoryMockRecorder) Insert(ctx, image interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockImageRepository)(nil).Insert), ctx, image)
+}
+
+// IsPrivate mocks base method.
+func (m *MockImageRepository) IsPrivate(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsPrivate", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsPrivate indicates an expected call of IsPrivate.
+func (mr *MockImageRepositoryMockRecorder) IsPrivate(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsPrivate", reflect.TypeOf((*MockImageRepository)(nil).IsPrivate), ctx, name)
+}
+
+// Select mocks base method.
+func (m *MockImageRepository) Select(ctx context.Context, name string) (*ImageMetadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Select", ctx, name)
+	ret0, _ := ret[0].(*ImageMetadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Select indicates an expected call of Select.
+func (mr *MockImageRepositoryMockRecorder) Select(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Select", reflect.TypeOf((*MockImageRepository)(nil).Select), ctx, name)
+}
+
+// Mockqueryer is a mock of queryer interface.
+type Mockqueryer struct {
+	ctrl     *gomock.Controller
+	recorder *MockqueryerMockRecorder
+}
+
+// MockqueryerMockRecorder is the mock recorder for Mockqueryer.
+type MockqueryerMockRecorder struct {
+	mock *Mockqueryer
+}
+
+// NewMockqueryer creates a new mock instance.
+func NewMockqueryer(ctrl *gomock.Controller) *Mockqueryer {
+	mock := &Mockqueryer{ctrl: ctrl}
+	mock.recorder = &MockqueryerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockqueryer) EXPECT() *MockqueryerMockRecorder {
+	return m.recorder
+}
+
+// QueryContext mocks base method.
+func (m *Mockqueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
+	ret0, _ := ret[0].(*sql.Rows)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryContext indicates an expected call of QueryContext.
+func (mr *MockqueryerMockRecorder) QueryContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchItemsByName", reflect.TypeOf((*MockItemRepository)(nil).SearchItemsByName), keyword)
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*Mockqueryer)(nil).QueryContext), varargs...)
 }
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxHiddenReasonLength bounds the reason given for hiding an item.
+const maxHiddenReasonLength = 500
+
+var (
+	errStaffNotBannable = errors.New("admins and moderators cannot be banned: change their role first")
+	errLastAdmin        = errors.New("the last admin cannot be demoted")
+)
+
+// moderationErrorStatus maps an error of the moderation handlers to an HTTP status code.
+func moderationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errItemNotFound), errors.Is(err, errUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errStaffNotBannable):
+		return http.StatusForbidden
+	case errors.Is(err, errLastAdmin), errors.Is(err, errCategoryExists):
+		return http.StatusConflict
+	default:
+		return repositoryErrorStatus(err)
+	}
+}
+
+// parseUserID reads the user_id path value.
+func parseUserID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		return 0, errors.New("invalid user_id")
+	}
+	return id, nil
+}
+
+// SetItemHiddenRequest hides an item, or shows it again. Reason is required to hide it.
+type SetItemHiddenRequest struct {
+	Hidden bool   `json:"hidden"`
+	Reason string `json:"reason"`
+}
+
+// SetItemHidden is a handler to hide an item from everyone but its seller and the moderators,
+// or to show it again, for PUT /items/{item_id}/hidden .
+func (s *Handlers) SetItemHidden(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseItemID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req SetItemHiddenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Hidden && req.Reason == "" {
+		http.Error(w, "reason is required to hide an item", http.StatusBadRequest)
+		return
+	}
+	if len(req.Reason) > maxHiddenReasonLength {
+		http.Error(w, fmt.Sprintf("reason must be at most %d bytes", maxHiddenReasonLength), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.itemRepo.SetHidden(ctx, id, req.Hidden, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+	if req.Hidden {
+		s.audit(ctx, AuditItemHide, "item", id, req.Reason)
+	} else {
+		s.audit(ctx, AuditItemUnhide, "item", id, "")
+	}
+	s.writeItem(w, item)
+}
+
+// BanUserRequest bans a user, or lifts their ban.
+type BanUserRequest struct {
+	Banned bool `json:"banned"`
+}
+
+// BanUser is a handler to ban a user, or to lift their ban, for PUT /users/{user_id}/ban .
+// Banning ends every session of the user and refuses their logins.
+func (s *Handlers) BanUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req BanUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	target, err := s.userRepo.Select(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+	// a moderator banning an admin, or another moderator, would be a way around the roles
+	if req.Banned && target.isStaff() {
+		http.Error(w, errStaffNotBannable.Error(), moderationErrorStatus(errStaffNotBannable))
+		return
+	}
+	if err := s.userRepo.SetBanned(ctx, id, req.Banned); err != nil {
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+	if !req.Banned {
+		s.audit(ctx, AuditUserUnban, "user", id, "")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.audit(ctx, AuditUserBan, "user", id, "")
+	if err := s.userRepo.DeleteUserSessions(ctx, id); err != nil {
+		// the ban holds anyway: authenticate refuses the remaining sessions
+		slog.Error("failed to end the sessions of a banned user", "user_id", id, "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRoleRequest changes the role of a user.
+type SetUserRoleRequest struct {
+	Role Role `json:"role"`
+}
+
+// SetUserRole is a handler to change the role of a user for PUT /users/{user_id}/role .
+// It refuses to demote the last admin, which would leave no one able to give the role back.
+func (s *Handlers) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req SetUserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	role, err := parseRole(string(req.Role))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target, err := s.userRepo.Select(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+	// the repository checks that an admin remains, atomically with the change
+	if err := s.userRepo.SetRole(ctx, id, role); err != nil {
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+	s.audit(ctx, AuditUserRole, "user", id, fmt.Sprintf("%s -> %s", target.Role, role))
+	target.Role = role
+	writeJSON(w, http.StatusOK, target)
+}
+
+// AddCategoryRequest adds a category.
+type AddCategoryRequest struct {
+	Name string `json:"name"`
+}
+
+// AddCategory is a handler to add a category for POST /categories .
+func (s *Handlers) AddCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req AddCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	category := &Category{Name: strings.TrimSpace(req.Name)}
+	if category.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.categoryRepo.Insert(ctx, category); err != nil {
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+	s.audit(ctx, AuditCategoryCreate, "category", category.ID, category.Name)
+	writeJSON(w, http.StatusCreated, category)
+}
+
+// GetCategories is a handler to list the categories for GET /categories .
+func (s *Handlers) GetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.categoryRepo.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	if categories == nil {
+		categories = []*Category{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"categories": categories})
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+// expectAudit makes m expect one entry with the given action, done by actor on the target of ID targetID.
+func expectAudit(t *testing.T, m *MockAuditRepository, action AuditAction, actor *User, targetID int) {
+	t.Helper()
+	m.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, entry *AuditEntry) error {
+		if entry.Action != action || entry.ActorID != actor.ID || entry.TargetID != targetID {
+			t.Errorf("unexpected audit entry %+v", entry)
+		}
+		return nil
+	})
+}
+
+func TestSetItemHidden(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body       string
+		updateErr  error
+		wantAction AuditAction
+		wantCode   int
+	}{
+		"ok: hide":                {body: `{"hidden": true, "reason": " counterfeit "}`, wantAction: AuditItemHide, wantCode: http.StatusOK},
+		"ok: show":                {body: `{"hidden": false}`, wantAction: AuditItemUnhide, wantCode: http.StatusOK},
+		"ng: hide without reason": {body: `{"hidden": true, "reason": "  "}`, wantCode: http.StatusBadRequest},
+		"ng: reason too long":     {body: `{"hidden": true, "reason": "` + strings.Repeat("a", maxHiddenReasonLength+1) + `"}`, wantCode: http.StatusBadRequest},
+		"ng: invalid body":        {body: `{"hidden": "yes"}`, wantCode: http.StatusBadRequest},
+		"ng: unknown item":        {body: `{"hidden": true, "reason": "spam"}`, updateErr: errItemNotFound, wantCode: http.StatusNotFound},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			mockAR := NewMockAuditRepository(ctrl)
+			if tt.wantCode == http.StatusOK || tt.updateErr != nil {
+				mockIR.EXPECT().SetHidden(gomock.Any(), 1, gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, id int, hidden bool, reason string) (*Item, error) {
+					if tt.updateErr != nil {
+						return nil, tt.updateErr
+					}
+					if hidden && reason != "counterfeit" {
+						t.Errorf("expected the trimmed reason, got %q", reason)
+					}
+					return &Item{ID: id, Status: ItemStatusPublic, SellerID: testSeller.ID, Hidden: hidden, HiddenReason: reason, ImageFileName: "a.jpg", Images: []string{"a.jpg"}}, nil
+				})
+			}
+			if tt.wantAction != "" {
+				expectAudit(t, mockAR, tt.wantAction, testModerator, 1)
+			}
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR, auditRepo: mockAR, imageURLs: testImageURLSigner(t, time.Now())}
+			req := httptest.NewRequest("PUT", "/items/1/hidden", strings.NewReader(tt.body))
+			req.SetPathValue("item_id", "1")
+			res := httptest.NewRecorder()
+
+			h.SetItemHidden(res, asUser(req, testModerator))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestBanUser(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		target     *User
+		body       string
+		wantAction AuditAction
+		wantCode   int
+	}{
+		"ok: ban":               {target: testBuyer, body: `{"banned": true}`, wantAction: AuditUserBan, wantCode: http.StatusNoContent},
+		"ok: unban":             {target: testBuyer, body: `{"banned": false}`, wantAction: AuditUserUnban, wantCode: http.StatusNoContent},
+		"ok: unban a moderator": {target: testModerator, body: `{"banned": false}`, wantAction: AuditUserUnban, wantCode: http.StatusNoContent},
+		"ng: ban a moderator":   {target: testModerator, body: `{"banned": true}`, wantCode: http.StatusForbidden},
+		"ng: ban an admin":      {target: testAdmin, body: `{"banned": true}`, wantCode: http.StatusForbidden},
+		"ng: unknown user":      {target: &User{ID: 42}, body: `{"banned": true}`, wantCode: http.StatusNotFound},
+		"ng: invalid body":      {target: testBuyer, body: `banned`, wantCode: http.StatusBadRequest},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().Select(gomock.Any(), tt.target.ID).DoAndReturn(func(_ context.Context, id int) (*User, error) {
+				if id == 42 {
+					return nil, errUserNotFound
+				}
+				return tt.target, nil
+			}).AnyTimes()
+			mockAR := NewMockAuditRepository(ctrl)
+			if tt.wantAction != "" {
+				mockUR.EXPECT().SetBanned(gomock.Any(), tt.target.ID, tt.wantAction == AuditUserBan).Return(nil)
+				expectAudit(t, mockAR, tt.wantAction, testModerator, tt.target.ID)
+			}
+			if tt.wantAction == AuditUserBan {
+				mockUR.EXPECT().DeleteUserSessions(gomock.Any(), tt.target.ID).Return(nil)
+			}
+			h := &Handlers{userRepo: mockUR, auditRepo: mockAR}
+			req := httptest.NewRequest("PUT", "/users/id/ban", strings.NewReader(tt.body))
+			req.SetPathValue("user_id", strconv.Itoa(tt.target.ID))
+			res := httptest.NewRecorder()
+
+			h.BanUser(res, asUser(req, testModerator))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	t.Parallel()
+
+	otherAdmin := &User{ID: 11, Email: "other-admin@example.com", Name: "Other Admin", Role: RoleAdmin}
+	cases := map[string]struct {
+		target   *User
+		body     string
+		wantRole Role
+		setErr   error
+		wantCode int
+	}{
+		"ok: promote to moderator":     {target: testBuyer, body: `{"role": "moderator"}`, wantRole: RoleModerator, wantCode: http.StatusOK},
+		"ok: demote one of two admins": {target: otherAdmin, body: `{"role": "user"}`, wantRole: RoleUser, wantCode: http.StatusOK},
+		"ng: demote the last admin":    {target: testAdmin, body: `{"role": "moderator"}`, wantRole: RoleModerator, setErr: errLastAdmin, wantCode: http.StatusConflict},
+		"ng: unknown role":             {target: testBuyer, body: `{"role": "root"}`, wantCode: http.StatusBadRequest},
+		"ng: unknown user":             {target: &User{ID: 42}, body: `{"role": "admin"}`, wantCode: http.StatusNotFound},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			target := *tt.target
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().Select(gomock.Any(), target.ID).DoAndReturn(func(_ context.Context, id int) (*User, error) {
+				if id == 42 {
+					return nil, errUserNotFound
+				}
+				return &target, nil
+			}).AnyTimes()
+			mockAR := NewMockAuditRepository(ctrl)
+			if tt.wantRole != "" {
+				mockUR.EXPECT().SetRole(gomock.Any(), target.ID, tt.wantRole).Return(tt.setErr)
+			}
+			if tt.wantCode == http.StatusOK {
+				expectAudit(t, mockAR, AuditUserRole, testAdmin, target.ID)
+			}
+			h := &Handlers{userRepo: mockUR, auditRepo: mockAR}
+			req := httptest.NewRequest("PUT", "/users/id/role", strings.NewReader(tt.body))
+			req.SetPathValue("user_id", strconv.Itoa(target.ID))
+			res := httptest.NewRecorder()
+
+			h.SetUserRole(res, asUser(req, testAdmin))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestAddCategory(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		body      string
+		insertErr error
+		wantCode  int
+	}{
+		"ok: new category":  {body: `{"name": " toys "}`, wantCode: http.StatusCreated},
+		"ng: existing name": {body: `{"name": "toys"}`, insertErr: errCategoryExists, wantCode: http.StatusConflict},
+		"ng: empty name":    {body: `{"name": " "}`, wantCode: http.StatusBadRequest},
+		"ng: invalid body":  {body: `toys`, wantCode: http.StatusBadRequest},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockCR := NewMockCategoryRepository(ctrl)
+			mockAR := NewMockAuditRepository(ctrl)
+			if tt.wantCode != http.StatusBadRequest {
+				mockCR.EXPECT().Insert(gomock.Any(), &Category{Name: "toys"}).DoAndReturn(func(_ context.Context, c *Category) error {
+					c.ID = 5
+					return tt.insertErr
+				})
+			}
+			if tt.wantCode == http.StatusCreated {
+				expectAudit(t, mockAR, AuditCategoryCreate, testAdmin, 5)
+			}
+			h := &Handlers{categoryRepo: mockCR, auditRepo: mockAR}
+			req := httptest.NewRequest("POST", "/categories", strings.NewReader(tt.body))
+			res := httptest.NewRecorder()
+
+			h.AddCategory(res, asUser(req, testAdmin))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
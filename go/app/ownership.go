@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// errForbidden is returned when a user changes an item they may see but do not own.
+var errForbidden = errors.New("the item belongs to another seller")
+
+// isSeller reports whether user, nil when anonymous, is the seller of item.
+func isSeller(user *User, item *Item) bool {
+	return user != nil && item.SellerID != 0 && item.SellerID == user.ID
+}
+
+// canViewItem reports whether user, nil when anonymous, may see item. Drafts are only
+// visible to their seller and to admins, and hidden items to their seller and to moderators.
+func canViewItem(user *User, item *Item) bool {
+	if isSeller(user, item) {
+		return true
+	}
+	if item.Hidden && !user.can(PermissionHideItems) {
+		return false
+	}
+	return item.Status != ItemStatusDraft || user.can(PermissionManageItems)
+}
+
+// canModifyItem reports whether user may change or delete item: its seller or an admin.
+func canModifyItem(user *User, item *Item) bool {
+	return isSeller(user, item) || user.can(PermissionManageItems)
+}
+
+// visibleItems returns the items that user may see, dropping the drafts and hidden items
+// of other sellers.
+func visibleItems(user *User, items []*Item) []*Item {
+	return slices.DeleteFunc(items, func(item *Item) bool { return !canViewItem(user, item) })
+}
+
+// selectVisibleItem returns the item with the given ID if the user of ctx may see it. The
+// drafts of other sellers are reported as errItemNotFound, so that they cannot be told
+// apart from items that do not exist. Hidden items are reported the same way.
+func (s *Handlers) selectVisibleItem(ctx context.Context, id int) (*Item, error) {
+	item, err := s.itemRepo.Select(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !canViewItem(userFromContext(ctx), item) {
+		return nil, errItemNotFound
+	}
+	return item, nil
+}
+
+// authorizeItemChange returns the item with the given ID if the user of ctx may change it,
+// errForbidden if they may only see it, and errItemNotFound if they may not even see it.
+func (s *Handlers) authorizeItemChange(ctx context.Context, id int) (*Item, error) {
+	user := userFromContext(ctx)
+	if user == nil {
+		return nil, errUnauthenticated
+	}
+	item, err := s.selectVisibleItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !canModifyItem(user, item) {
+		return nil, errForbidden
+	}
+	return item, nil
+}
+
+// DeleteItem is a handler to delete an item for DELETE /items/{item_id} . Its images are
+// left to the image garbage collection, as other items may show them.
+func (s *Handlers) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseItemID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item, err := s.authorizeItemChange(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+	if err := s.itemRepo.Delete(ctx, id); err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+	user := userFromContext(ctx)
+	slog.Info("item deleted", "item_id", id, "user_id", user.ID)
+	if !isSeller(user, item) {
+		s.audit(ctx, AuditItemDelete, "item", id, item.Name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Seller is the public profile of a user shown with their items: unlike User, it leaves
+// out the email address.
+type Seller struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetUserItems is a handler to return the storefront of a seller, their profile and their
+// items, for GET /users/{user_id}/items . Drafts are only listed for the seller and admins,
+// and hidden items for the seller and moderators.
+func (s *Handlers) GetUserItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seller, err := s.userRepo.Select(ctx, id)
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+
+	items, err := s.itemRepo.SelectBySeller(ctx, seller.ID)
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	items = visibleItems(userFromContext(ctx), items)
+	s.setImageURLs(items...)
+	writeJSON(w, http.StatusOK, map[string]any{"seller": Seller{ID: seller.ID, Name: seller.Name}, "items": items})
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	// testSeller is the user test requests are sent as, and the seller of the items of expectOwnedItems.
+	testSeller = &User{ID: 7, Email: "seller@example.com", Name: "Seller", Role: RoleUser}
+	testBuyer  = &User{ID: 8, Email: "buyer@example.com", Name: "Buyer", Role: RoleUser}
+	testAdmin  = &User{ID: 9, Email: "admin@example.com", Name: "Admin", Role: RoleAdmin}
+	// testModerator may hide items and ban users, but not manage categories or roles.
+	testModerator = &User{ID: 10, Email: "moderator@example.com", Name: "Moderator", Role: RoleModerator}
+)
+
+// asUser returns req as sent by user.
+func asUser(req *http.Request, user *User) *http.Request {
+	return req.WithContext(withUser(req.Context(), user))
+}
+
+// expectOwnedItems makes m find every item, as a public listing of testSeller.
+func expectOwnedItems(m *MockItemRepository) {
+	m.EXPECT().Select(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, id int) (*Item, error) {
+		return &Item{ID: id, Status: ItemStatusPublic, SellerID: testSeller.ID}, nil
+	}).AnyTimes()
+}
+
+func TestAuthorizeItemChange(t *testing.T) {
+	t.Parallel()
+
+	public := &Item{ID: 1, Status: ItemStatusPublic, SellerID: testSeller.ID}
+	reserved := &Item{ID: 2, Status: ItemStatusReserved, SellerID: testSeller.ID}
+	draft := &Item{ID: 3, Status: ItemStatusDraft, SellerID: testSeller.ID}
+	// items listed before accounts existed have no seller
+	legacy := &Item{ID: 4, Status: ItemStatusPublic}
+
+	cases := map[string]struct {
+		user    *User
+		item    *Item
+		wantErr error
+	}{
+		"ok: seller":                      {user: testSeller, item: public},
+		"ok: seller of a draft":           {user: testSeller, item: draft},
+		"ok: admin":                       {user: testAdmin, item: draft},
+		"ok: admin on an item of no one":  {user: testAdmin, item: legacy},
+		"ng: other user":                  {user: testBuyer, item: public, wantErr: errForbidden},
+		"ng: other user on reserved item": {user: testBuyer, item: reserved, wantErr: errForbidden},
+		"ng: other user on draft":         {user: testBuyer, item: draft, wantErr: errItemNotFound},
+		"ng: user on an item of no one":   {user: testBuyer, item: legacy, wantErr: errForbidden},
+		"ng: anonymous":                   {item: public, wantErr: errUnauthenticated},
+		"ng: unknown item":                {user: testSeller, item: &Item{ID: 42}, wantErr: errItemNotFound},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			mockIR.EXPECT().Select(gomock.Any(), tt.item.ID).DoAndReturn(func(_ context.Context, id int) (*Item, error) {
+				if id == 42 {
+					return nil, errItemNotFound
+				}
+				return tt.item, nil
+			}).AnyTimes()
+			h := &Handlers{itemRepo: mockIR}
+			ctx := context.Background()
+			if tt.user != nil {
+				ctx = withUser(ctx, tt.user)
+			}
+
+			got, err := h.authorizeItemChange(ctx, tt.item.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && got != tt.item {
+				t.Errorf("expected item %d, got %+v", tt.item.ID, got)
+			}
+		})
+	}
+}
+
+func TestGetItemDraft(t *testing.T) {
+	t.Parallel()
+
+	draft := &Item{ID: 3, Name: "jacket", Status: ItemStatusDraft, SellerID: testSeller.ID, ImageFileName: "a.jpg", Images: []string{"a.jpg"}}
+	cases := map[string]struct {
+		user     *User
+		wantCode int
+	}{
+		"ok: seller":     {user: testSeller, wantCode: http.StatusOK},
+		"ok: admin":      {user: testAdmin, wantCode: http.StatusOK},
+		"ng: other user": {user: testBuyer, wantCode: http.StatusNotFound},
+		"ng: anonymous":  {wantCode: http.StatusNotFound},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			mockIR.EXPECT().Select(gomock.Any(), draft.ID).Return(draft, nil)
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR, imageURLs: testImageURLSigner(t, time.Now())}
+			req := httptest.NewRequest("GET", "/items/3", nil)
+			req.SetPathValue("item_id", "3")
+			if tt.user != nil {
+				req = asUser(req, tt.user)
+			}
+			res := httptest.NewRecorder()
+
+			h.GetItem(res, req)
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		user      *User
+		deleteErr error
+		wantCode  int
+		// wantAudit is set when the deletion is recorded in the audit log
+		wantAudit bool
+	}{
+		"ok: seller":            {user: testSeller, wantCode: http.StatusNoContent},
+		"ok: admin":             {user: testAdmin, wantCode: http.StatusNoContent, wantAudit: true},
+		"ng: other user":        {user: testBuyer, wantCode: http.StatusForbidden},
+		"ng: deleted meanwhile": {user: testSeller, deleteErr: errItemNotFound, wantCode: http.StatusNotFound},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			expectOwnedItems(mockIR)
+			if tt.wantCode != http.StatusForbidden {
+				mockIR.EXPECT().Delete(gomock.Any(), 1).Return(tt.deleteErr)
+			}
+			mockAR := NewMockAuditRepository(ctrl)
+			if tt.wantAudit {
+				mockAR.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, entry *AuditEntry) error {
+					if entry.Action != AuditItemDelete || entry.ActorID != tt.user.ID || entry.TargetID != 1 {
+						t.Errorf("unexpected audit entry %+v", entry)
+					}
+					return nil
+				})
+			}
+			h := &Handlers{itemRepo: mockIR, auditRepo: mockAR}
+			req := httptest.NewRequest("DELETE", "/items/1", nil)
+			req.SetPathValue("item_id", "1")
+			res := httptest.NewRecorder()
+
+			h.DeleteItem(res, asUser(req, tt.user))
+
+			if res.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetUserItems(t *testing.T) {
+	t.Parallel()
+
+	public := &Item{ID: 1, Name: "jacket", Status: ItemStatusPublic, SellerID: testSeller.ID, ImageFileName: "a.jpg", Images: []string{"a.jpg"}}
+	draft := &Item{ID: 2, Name: "chair", Status: ItemStatusDraft, SellerID: testSeller.ID, ImageFileName: "b.jpg", Images: []string{"b.jpg"}}
+
+	type wants struct {
+		code  int
+		items []int
+	}
+	cases := map[string]struct {
+		userID string
+		viewer *User
+		wants
+	}{
+		"ok: anonymous sees public items":  {userID: "7", wants: wants{code: http.StatusOK, items: []int{1}}},
+		"ok: other user sees public items": {userID: "7", viewer: testBuyer, wants: wants{code: http.StatusOK, items: []int{1}}},
+		"ok: seller sees drafts":           {userID: "7", viewer: testSeller, wants: wants{code: http.StatusOK, items: []int{1, 2}}},
+		"ok: admin sees drafts":            {userID: "7", viewer: testAdmin, wants: wants{code: http.StatusOK, items: []int{1, 2}}},
+		"ng: unknown user":                 {userID: "42", wants: wants{code: http.StatusNotFound}},
+		"ng: invalid user_id":              {userID: "abc", wants: wants{code: http.StatusBadRequest}},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockUR := NewMockUserRepository(ctrl)
+			mockUR.EXPECT().Select(gomock.Any(), testSeller.ID).Return(testSeller, nil).AnyTimes()
+			mockUR.EXPECT().Select(gomock.Any(), 42).Return(nil, errUserNotFound).AnyTimes()
+			mockIR := NewMockItemRepository(ctrl)
+			mockIR.EXPECT().SelectBySeller(gomock.Any(), testSeller.ID).DoAndReturn(func(context.Context, int) ([]*Item, error) {
+				// each call gets its own slice, which the handler filters in place
+				return []*Item{public, draft}, nil
+			}).AnyTimes()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR, userRepo: mockUR, imageURLs: testImageURLSigner(t, time.Now())}
+			req := httptest.NewRequest("GET", "/users/"+tt.userID+"/items", nil)
+			req.SetPathValue("user_id", tt.userID)
+			if tt.viewer != nil {
+				req = asUser(req, tt.viewer)
+			}
+			res := httptest.NewRecorder()
+
+			h.GetUserItems(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			var got struct {
+				Seller map[string]any `json:"seller"`
+				Items  []*Item        `json:"items"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			var ids []int
+			for _, item := range got.Items {
+				ids = append(ids, item.ID)
+			}
+			if diff := cmp.Diff(tt.wants.items, ids); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+			if _, ok := got.Seller["email"]; ok || got.Seller["name"] != testSeller.Name {
+				t.Errorf("expected the public profile of the seller, got %v", got.Seller)
+			}
+		})
+	}
+}
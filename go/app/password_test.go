@@ -0,0 +1,59 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testArgon2Params are cheap enough to hash passwords in every test case.
+var testArgon2Params = argon2Params{Memory: 64, Time: 1, Threads: 1, SaltLen: 8, KeyLen: 16}
+
+func TestPassword(t *testing.T) {
+	t.Parallel()
+
+	hash, err := hashPassword("correct horse", testArgon2Params)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("expected a PHC string with the parameters, got %s", hash)
+	}
+	again, err := hashPassword("correct horse", testArgon2Params)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if again == hash {
+		t.Errorf("expected a random salt to give different hashes")
+	}
+
+	cases := map[string]struct {
+		password string
+		hash     string
+		want     bool
+		wantErr  error
+	}{
+		"ok: right password":       {password: "correct horse", hash: hash, want: true},
+		"ok: wrong password":       {password: "battery staple", hash: hash, want: false},
+		"ng: other algorithm":      {password: "correct horse", hash: strings.Replace(hash, "argon2id", "argon2i", 1), wantErr: errInvalidPasswordHash},
+		"ng: zero passes":          {password: "correct horse", hash: strings.Replace(hash, "t=1", "t=0", 1), wantErr: errInvalidPasswordHash},
+		"ng: truncated":            {password: "correct horse", hash: hash[:strings.LastIndex(hash, "$")], wantErr: errInvalidPasswordHash},
+		"ng: not a password hash":  {password: "correct horse", hash: "correct horse", wantErr: errInvalidPasswordHash},
+		"ng: unsupported version":  {password: "correct horse", hash: strings.Replace(hash, "v=19", "v=16", 1), wantErr: errInvalidPasswordHash},
+		"ng: malformed parameters": {password: "correct horse", hash: strings.Replace(hash, "m=64", "m=lots", 1), wantErr: errInvalidPasswordHash},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := verifyPassword(tt.password, tt.hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// similarImageMaxDistance is the largest Hamming distance between the perceptual hashes
+// of two images considered near-duplicates: about one bit in six may differ.
+const similarImageMaxDistance = 10
+
+// SimilarImage is an image of an item that looks like another image.
+type SimilarImage struct {
+	ItemID    int    `json:"item_id"`
+	ImageName string `json:"image_name"`
+	// SimilarTo is the image it was compared with.
+	SimilarTo string `json:"similar_to"`
+	// Distance is the number of bits their perceptual hashes differ in, 0 for the same picture.
+	Distance int `json:"distance"`
+
+	// the item showing the image, to tell who may see it
+	SellerID int        `json:"-"`
+	Status   ItemStatus `json:"-"`
+	Hidden   bool       `json:"-"`
+}
+
+// item returns the item showing the image, with only what canViewItem needs.
+func (s SimilarImage) item() *Item {
+	return &Item{ID: s.ItemID, SellerID: s.SellerID, Status: s.Status, Hidden: s.Hidden}
+}
+
+// perceptualHash returns the difference hash (dHash) of img: the image is shrunk to 9×8
+// gray pixels and each bit tells whether a pixel is darker than its right neighbour.
+// Unlike a SHA-256, the hash barely changes when the picture is resized, recompressed,
+// lightly cropped or has its colors adjusted.
+func perceptualHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	small := image.NewGray(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// formatPHash formats hash as stored in ImageMetadata.
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func parsePHash(s string) (uint64, error) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q: %w", s, err)
+	}
+	return hash, nil
+}
+
+// phashDistance returns the number of bits the perceptual hashes a and b differ in.
+func phashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// findSimilarImages returns the images of other items than itemID that look like any of
+// names, closest first, leaving out the items the user of ctx may not see, such as the drafts
+// of other sellers. An image shown several times by an item is listed once.
+func (s *Handlers) findSimilarImages(ctx context.Context, itemID int, names []string) ([]SimilarImage, error) {
+	similar, err := s.imageRepo.FindSimilar(ctx, names, similarImageMaxDistance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find similar images: %w", err)
+	}
+	user := userFromContext(ctx)
+	type key struct {
+		itemID int
+		name   string
+	}
+	closest := map[key]SimilarImage{}
+	for _, img := range similar {
+		k := key{img.ItemID, img.ImageName}
+		if img.ItemID == itemID || !canViewItem(user, img.item()) {
+			continue
+		}
+		if prev, ok := closest[k]; ok && prev.Distance <= img.Distance {
+			continue
+		}
+		closest[k] = img
+	}
+
+	found := make([]SimilarImage, 0, len(closest))
+	for _, img := range closest {
+		found = append(found, img)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		if a.ItemID != b.ItemID {
+			return a.ItemID < b.ItemID
+		}
+		return a.ImageName < b.ImageName
+	})
+	return found, nil
+}
+
+// GetSimilarImages is a handler to list the images of other items that look like the
+// images of an item for GET /items/{item_id}/similar-images .
+func (s *Handlers) GetSimilarImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseItemID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item, err := s.selectVisibleItem(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+
+	similar, err := s.findSimilarImages(ctx, item.ID, item.Images)
+	if err != nil {
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"similar_images": similar}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
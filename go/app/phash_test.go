@@ -0,0 +1,324 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/image/draw"
+)
+
+// testRecompressed returns data decoded, scaled by scale and encoded again as a JPEG of the given quality.
+func testRecompressed(t *testing.T, data []byte, scale float64, quality int) []byte {
+	t.Helper()
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, int(float64(b.Dx())*scale), int(float64(b.Dy())*scale)))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPerceptualHash(t *testing.T) {
+	t.Parallel()
+
+	photo, err := os.ReadFile(defaultImagePath)
+	if err != nil {
+		t.Fatalf("failed to read image file: %v", err)
+	}
+	gradient := testPNG(t, 400, 300)
+
+	cases := map[string]struct {
+		a, b    []byte
+		similar bool
+	}{
+		"ok: same image":           {a: photo, b: photo, similar: true},
+		"ok: resized":              {a: photo, b: testRecompressed(t, photo, 0.5, 90), similar: true},
+		"ok: recompressed":         {a: photo, b: testRecompressed(t, photo, 1, 30), similar: true},
+		"ok: other format":         {a: gradient, b: testJPEG(t, 200, 150), similar: true},
+		"ng: different pictures":   {a: photo, b: gradient},
+		"ng: different pictures 2": {a: testRecompressed(t, photo, 0.25, 50), b: testJPEG(t, 100, 75)},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var a, b ImageMetadata
+			if err := describeImage(&a, tt.a); err != nil {
+				t.Fatalf("failed to describe image: %v", err)
+			}
+			if err := describeImage(&b, tt.b); err != nil {
+				t.Fatalf("failed to describe image: %v", err)
+			}
+			ha, err := parsePHash(a.PHash)
+			if err != nil {
+				t.Fatalf("failed to parse hash: %v", err)
+			}
+			hb, err := parsePHash(b.PHash)
+			if err != nil {
+				t.Fatalf("failed to parse hash: %v", err)
+			}
+			d := phashDistance(ha, hb)
+			if similar := d <= similarImageMaxDistance; similar != tt.similar {
+				t.Errorf("expected similar %v, got distance %d between %s and %s", tt.similar, d, a.PHash, b.PHash)
+			}
+		})
+	}
+
+	if err := describeImage(&ImageMetadata{}, []byte("not an image")); err == nil {
+		t.Errorf("expected an error for data that is not an image")
+	}
+}
+
+func TestGetSimilarImages(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{ID: 1, Name: "camera", Category: "phone", ImageFileName: "a.jpg", Images: []string{"a.jpg", "b.jpg"}}
+	type wants struct {
+		code    int
+		similar []SimilarImage
+	}
+	cases := map[string]struct {
+		itemID string
+		// user sends the request, anonymously when nil
+		user          *User
+		injector      func(m *MockItemRepository)
+		imageInjector func(m *MockImageRepository)
+		wants
+	}{
+		"ok: closest first, without the item itself": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(item, nil).Times(1)
+			},
+			imageInjector: func(m *MockImageRepository) {
+				m.EXPECT().FindSimilar(gomock.Any(), []string{"a.jpg", "b.jpg"}, similarImageMaxDistance).Return([]SimilarImage{
+					{ItemID: 1, ImageName: "a.jpg", SimilarTo: "a.jpg", Distance: 0},
+					{ItemID: 1, ImageName: "b.jpg", SimilarTo: "b.jpg", Distance: 0},
+					{ItemID: 3, ImageName: "d.jpg", SimilarTo: "b.jpg", Distance: 2},
+					{ItemID: 2, ImageName: "c.jpg", SimilarTo: "a.jpg", Distance: 3},
+				}, nil).Times(1)
+			},
+			wants: wants{code: http.StatusOK, similar: []SimilarImage{
+				{ItemID: 3, ImageName: "d.jpg", SimilarTo: "b.jpg", Distance: 2},
+				{ItemID: 2, ImageName: "c.jpg", SimilarTo: "a.jpg", Distance: 3},
+			}},
+		},
+		"ok: drafts and hidden items of other sellers left out": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(item, nil).Times(1)
+			},
+			imageInjector: func(m *MockImageRepository) {
+				m.EXPECT().FindSimilar(gomock.Any(), []string{"a.jpg", "b.jpg"}, similarImageMaxDistance).Return([]SimilarImage{
+					{ItemID: 2, ImageName: "c.jpg", SimilarTo: "a.jpg", Distance: 1, SellerID: testSeller.ID, Status: ItemStatusDraft},
+					{ItemID: 3, ImageName: "d.jpg", SimilarTo: "a.jpg", Distance: 2, SellerID: testSeller.ID, Status: ItemStatusPublic, Hidden: true},
+					{ItemID: 4, ImageName: "e.jpg", SimilarTo: "b.jpg", Distance: 3, SellerID: testSeller.ID, Status: ItemStatusPublic},
+				}, nil).Times(1)
+			},
+			wants: wants{code: http.StatusOK, similar: []SimilarImage{
+				{ItemID: 4, ImageName: "e.jpg", SimilarTo: "b.jpg", Distance: 3},
+			}},
+		},
+		"ok: own draft shown to its seller": {
+			itemID: "1",
+			user:   testSeller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(item, nil).Times(1)
+			},
+			imageInjector: func(m *MockImageRepository) {
+				m.EXPECT().FindSimilar(gomock.Any(), []string{"a.jpg", "b.jpg"}, similarImageMaxDistance).Return([]SimilarImage{
+					{ItemID: 2, ImageName: "c.jpg", SimilarTo: "a.jpg", Distance: 1, SellerID: testSeller.ID, Status: ItemStatusDraft},
+					{ItemID: 3, ImageName: "d.jpg", SimilarTo: "a.jpg", Distance: 2, SellerID: testBuyer.ID, Status: ItemStatusDraft},
+				}, nil).Times(1)
+			},
+			wants: wants{code: http.StatusOK, similar: []SimilarImage{
+				{ItemID: 2, ImageName: "c.jpg", SimilarTo: "a.jpg", Distance: 1},
+			}},
+		},
+		"ok: nothing similar": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(item, nil).Times(1)
+			},
+			imageInjector: func(m *MockImageRepository) {
+				m.EXPECT().FindSimilar(gomock.Any(), []string{"a.jpg", "b.jpg"}, similarImageMaxDistance).Return(nil, nil).Times(1)
+			},
+			wants: wants{code: http.StatusOK, similar: []SimilarImage{}},
+		},
+		"ng: unknown item": {
+			itemID: "9",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 9).Return(nil, errItemNotFound).Times(1)
+			},
+			wants: wants{code: http.StatusNotFound},
+		},
+		"ng: invalid item ID": {
+			itemID:   "camera",
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: lookup failed": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Select(gomock.Any(), 1).Return(item, nil).Times(1)
+			},
+			imageInjector: func(m *MockImageRepository) {
+				m.EXPECT().FindSimilar(gomock.Any(), gomock.Any(), similarImageMaxDistance).Return(nil, errQueryTimeout).Times(1)
+			},
+			wants: wants{code: http.StatusGatewayTimeout},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			mockImR := NewMockImageRepository(ctrl)
+			if tt.imageInjector != nil {
+				tt.imageInjector(mockImR)
+			}
+			h := &Handlers{itemRepo: mockIR, imageRepo: mockImR}
+
+			req := httptest.NewRequest("GET", "/items/"+tt.itemID+"/similar-images", nil)
+			req.SetPathValue("item_id", tt.itemID)
+			if tt.user != nil {
+				req = asUser(req, tt.user)
+			}
+			res := httptest.NewRecorder()
+
+			h.GetSimilarImages(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+			var got struct {
+				SimilarImages []SimilarImage `json:"similar_images"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.similar, got.SimilarImages); diff != "" {
+				t.Errorf("unexpected similar images (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// newAddItemRequest returns a POST /items request listing a jacket with image.
+func newAddItemRequest(t *testing.T, image []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("name", "jacket")
+	writer.WriteField("category", "fashion")
+	part, err := writer.CreateFormFile("image", "photo.png")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(image)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/items", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestAddItemPossibleDuplicate(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ any, item *Item) error {
+		item.ID = 2
+		return nil
+	}).Times(1)
+	mockImR := NewMockImageRepository(ctrl)
+	mockImR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockImR.EXPECT().FindSimilar(gomock.Any(), gomock.Any(), similarImageMaxDistance).DoAndReturn(
+		func(_ any, names []string, _ int) ([]SimilarImage, error) {
+			return []SimilarImage{
+				{ItemID: 2, ImageName: names[0], SimilarTo: names[0], Distance: 0},
+				{ItemID: 1, ImageName: "original.png", SimilarTo: names[0], Distance: 4},
+			}, nil
+		}).Times(1)
+	h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR, imageRepo: mockImR}
+
+	req := newAddItemRequest(t, testPNG(t, 40, 30))
+	res := httptest.NewRecorder()
+	h.AddItem(res, asUser(req, testSeller))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	var got struct {
+		PossibleDuplicate bool           `json:"possible_duplicate"`
+		SimilarImages     []SimilarImage `json:"similar_images"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !got.PossibleDuplicate || len(got.SimilarImages) != 1 || got.SimilarImages[0].ItemID != 1 {
+		t.Errorf("expected a warning about item 1, got %+v", got)
+	}
+
+	t.Run("drafts of other sellers are not reported", func(t *testing.T) {
+		mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+		mockImR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+		mockImR.EXPECT().FindSimilar(gomock.Any(), gomock.Any(), similarImageMaxDistance).Return([]SimilarImage{
+			{ItemID: 1, ImageName: "original.png", SimilarTo: "photo.png", Distance: 4, SellerID: testBuyer.ID, Status: ItemStatusDraft},
+		}, nil).Times(1)
+
+		res := httptest.NewRecorder()
+		h.AddItem(res, asUser(newAddItemRequest(t, testPNG(t, 40, 30)), testSeller))
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, res.Code)
+		}
+		var got map[string]any
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if got["possible_duplicate"] != false || got["similar_images"] != nil {
+			t.Errorf("expected no warning about the draft, got %v", got)
+		}
+	})
+
+	t.Run("lookup failure is not fatal", func(t *testing.T) {
+		mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+		mockImR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+		mockImR.EXPECT().FindSimilar(gomock.Any(), gomock.Any(), similarImageMaxDistance).Return(nil, errors.New("db down")).Times(1)
+
+		res := httptest.NewRecorder()
+		h.AddItem(res, asUser(newAddItemRequest(t, testPNG(t, 40, 30)), testSeller))
+		if res.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, res.Code)
+		}
+	})
+}
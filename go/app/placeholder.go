@@ -0,0 +1,165 @@
+package app
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// blurHashComponentsX and blurHashComponentsY are the number of horizontal and vertical
+	// cosine components of a BlurHash: 4×3 keeps the hash at 28 characters, enough for a
+	// recognizable blur of a landscape photo.
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+
+	// placeholderSampleSize bounds the longest side of the copy placeholders are computed
+	// from. Placeholders are blurry anyway, and the cost of a BlurHash grows with the pixels.
+	placeholderSampleSize = 64
+)
+
+// imagePlaceholder returns the BlurHash and the dominant color, as #rrggbb, of img: what
+// clients show while the image itself loads.
+func imagePlaceholder(img image.Image) (blurHash, dominantColor string) {
+	sample := placeholderSample(img)
+	return encodeBlurHash(sample, blurHashComponentsX, blurHashComponentsY), dominantImageColor(sample)
+}
+
+// placeholderSample returns img scaled down to fit in placeholderSampleSize pixels.
+func placeholderSample(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if longest := max(w, h); longest > placeholderSampleSize {
+		w = max(w*placeholderSampleSize/longest, 1)
+		h = max(h*placeholderSampleSize/longest, 1)
+	}
+	sample := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(sample, sample.Bounds(), img, b, draw.Src, nil)
+	return sample
+}
+
+// dominantImageColor returns the most common color of img, as #rrggbb. Colors are counted
+// with 4 bits per channel, so that shades of the same color add up, and the winner is the
+// average of the pixels of its shade. Transparent pixels are ignored.
+func dominantImageColor(img *image.RGBA) string {
+	type shade struct {
+		count   int
+		r, g, b int
+	}
+	var shades [1 << 12]shade
+	best := -1
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			px := img.RGBAAt(x, y)
+			if px.A == 0 {
+				continue
+			}
+			// un-premultiply, so that translucent pixels count with their own color
+			c := color.NRGBAModel.Convert(px).(color.NRGBA)
+			i := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			s := &shades[i]
+			s.count++
+			s.r, s.g, s.b = s.r+int(c.R), s.g+int(c.G), s.b+int(c.B)
+			if best < 0 || s.count > shades[best].count {
+				best = i
+			}
+		}
+	}
+	if best < 0 {
+		return "#000000"
+	}
+	s := shades[best]
+	return fmt.Sprintf("#%02x%02x%02x", s.r/s.count, s.g/s.count, s.b/s.count)
+}
+
+// base83 is the alphabet of BlurHash digits.
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBlurHash returns the BlurHash of img with x×y components, following the reference
+// implementation at https://github.com/woltapp/blurhash: the image is approximated by a sum
+// of cosines whose coefficients are quantized and written in base 83.
+func encodeBlurHash(img *image.RGBA, x, y int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	factors := make([][3]float64, 0, x*y)
+	for j := 0; j < y; j++ {
+		for i := 0; i < x; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for py := 0; py < h; py++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(py) / float64(h))
+				for px := 0; px < w; px++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(px)/float64(w)) * cy
+					c := img.RGBAAt(b.Min.X+px, b.Min.Y+py)
+					f[0] += basis * srgbToLinear(c.R)
+					f[1] += basis * srgbToLinear(c.G)
+					f[2] += basis * srgbToLinear(c.B)
+				}
+			}
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	writeBase83(&hash, (x-1)+(y-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 1.0
+	if len(ac) > 0 {
+		var actual float64
+		for _, f := range ac {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantised := int(max(0, min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		writeBase83(&hash, quantised, 1)
+	} else {
+		writeBase83(&hash, 0, 1)
+	}
+
+	writeBase83(&hash, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		quantise := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		writeBase83(&hash, quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2)
+	}
+	return hash.String()
+}
+
+// writeBase83 writes value as length base 83 digits, most significant first.
+func writeBase83(b *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		b.WriteByte(base83[value/int(math.Pow(83, float64(i)))%83])
+	}
+}
+
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = max(0, min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow raises the magnitude of v to exp, keeping its sign.
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
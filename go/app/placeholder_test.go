@@ -0,0 +1,108 @@
+package app
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testSolidImage returns a w x h image filled with c.
+func testSolidImage(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeBlurHash(t *testing.T) {
+	t.Parallel()
+
+	gradient := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			gradient.SetRGBA(x, y, color.RGBA{R: uint8(x * 30), G: uint8(y * 40), B: 200, A: 255})
+		}
+	}
+
+	// the expected hashes are those of the reference implementation
+	cases := map[string]struct {
+		img  *image.RGBA
+		want string
+	}{
+		"ok: gradient": {img: gradient, want: "LcE..23Ea|%5zRNMfQnUeqf7fQf7"},
+		"ok: white":    {img: testSolidImage(4, 4, color.RGBA{R: 255, G: 255, B: 255, A: 255}), want: "L~TSUA~qfQ~q~q%MfQ%MfQfQfQfQ"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := encodeBlurHash(tt.img, blurHashComponentsX, blurHashComponentsY); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDominantImageColor(t *testing.T) {
+	t.Parallel()
+
+	red := color.RGBA{R: 250, G: 10, B: 10, A: 255}
+	striped := testSolidImage(10, 10, red)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 3; x++ {
+			striped.SetRGBA(x, y, color.RGBA{B: 255, A: 255})
+		}
+	}
+	// two close shades of red outnumber the blue together, and are averaged
+	shaded := testSolidImage(10, 10, red)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 4; x++ {
+			shaded.SetRGBA(x, y, color.RGBA{B: 255, A: 255})
+		}
+		for x := 4; x < 7; x++ {
+			shaded.SetRGBA(x, y, color.RGBA{R: 252, G: 12, B: 12, A: 255})
+		}
+	}
+	// fully transparent pixels do not count, whatever their color
+	transparent := testSolidImage(10, 10, color.RGBA{})
+	transparent.SetRGBA(0, 0, color.RGBA{G: 255, A: 255})
+
+	cases := map[string]struct {
+		img  *image.RGBA
+		want string
+	}{
+		"ok: most common color": {img: striped, want: "#fa0a0a"},
+		"ok: shades add up":     {img: shaded, want: "#fb0b0b"},
+		"ok: transparency":      {img: transparent, want: "#00ff00"},
+		"ok: fully transparent": {img: testSolidImage(2, 2, color.RGBA{}), want: "#000000"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := dominantImageColor(tt.img); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestImagePlaceholder(t *testing.T) {
+	t.Parallel()
+
+	blurHash, dominant := imagePlaceholder(testImage(4000, 3000))
+	if len(blurHash) != 28 {
+		t.Errorf("expected a BlurHash of 28 characters, got %q", blurHash)
+	}
+	if len(dominant) != 7 || dominant[0] != '#' {
+		t.Errorf("expected a #rrggbb color, got %q", dominant)
+	}
+	if sample := placeholderSample(testImage(4000, 3000)); sample.Bounds().Dx() != placeholderSampleSize || sample.Bounds().Dy() != 48 {
+		t.Errorf("expected the sample to keep the aspect ratio within %d pixels, got %v", placeholderSampleSize, sample.Bounds())
+	}
+}
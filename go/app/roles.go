@@ -0,0 +1,67 @@
+package app
+
+import (
+	"errors"
+	"slices"
+)
+
+// Permission is an operation that only some roles may perform.
+type Permission string
+
+const (
+	// PermissionManageCategories allows adding categories.
+	PermissionManageCategories Permission = "categories:manage"
+	// PermissionManageItems allows changing and deleting the items of every seller.
+	PermissionManageItems Permission = "items:manage"
+	// PermissionHideItems allows hiding items from everyone but their seller, and seeing them.
+	PermissionHideItems Permission = "items:hide"
+	// PermissionBanUsers allows banning users, which ends their sessions and refuses their logins.
+	PermissionBanUsers Permission = "users:ban"
+	// PermissionManageRoles allows changing the role of users.
+	PermissionManageRoles Permission = "users:roles"
+	// PermissionViewAuditLog allows reading the audit log of the operations above.
+	PermissionViewAuditLog Permission = "audit:view"
+)
+
+// rolePermissions lists the permissions of each role. Users have none: they only manage
+// their own account and items.
+var rolePermissions = map[Role][]Permission{
+	RoleUser: nil,
+	RoleModerator: {
+		PermissionHideItems,
+		PermissionBanUsers,
+		PermissionViewAuditLog,
+	},
+	RoleAdmin: {
+		PermissionManageCategories,
+		PermissionManageItems,
+		PermissionHideItems,
+		PermissionBanUsers,
+		PermissionManageRoles,
+		PermissionViewAuditLog,
+	},
+}
+
+var (
+	errInvalidRole      = errors.New("invalid role: must be user, moderator or admin")
+	errPermissionDenied = errors.New("permission denied")
+)
+
+// parseRole validates a role given by a client.
+func parseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", errInvalidRole
+	}
+	return role, nil
+}
+
+// can reports whether user, nil when anonymous, has permission p.
+func (u *User) can(p Permission) bool {
+	return u != nil && slices.Contains(rolePermissions[u.Role], p)
+}
+
+// isStaff reports whether user has any permission, that is whether they are a moderator or an admin.
+func (u *User) isStaff() bool {
+	return u != nil && len(rolePermissions[u.Role]) > 0
+}
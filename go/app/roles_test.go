@@ -0,0 +1,68 @@
+package app
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRole(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		role    string
+		want    Role
+		wantErr error
+	}{
+		"ok: user":       {role: "user", want: RoleUser},
+		"ok: moderator":  {role: "moderator", want: RoleModerator},
+		"ok: admin":      {role: "admin", want: RoleAdmin},
+		"ng: empty":      {role: "", wantErr: errInvalidRole},
+		"ng: unknown":    {role: "root", wantErr: errInvalidRole},
+		"ng: wrong case": {role: "Admin", wantErr: errInvalidRole},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseRole(tt.role)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected role %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCanViewItem(t *testing.T) {
+	t.Parallel()
+
+	hidden := &Item{ID: 1, Status: ItemStatusPublic, SellerID: testSeller.ID, Hidden: true, HiddenReason: "spam"}
+	draft := &Item{ID: 2, Status: ItemStatusDraft, SellerID: testSeller.ID}
+
+	cases := map[string]struct {
+		user *User
+		item *Item
+		want bool
+	}{
+		"ok: seller of a hidden item":   {user: testSeller, item: hidden, want: true},
+		"ok: moderator on hidden item":  {user: testModerator, item: hidden, want: true},
+		"ok: admin on hidden item":      {user: testAdmin, item: hidden, want: true},
+		"ok: admin on draft":            {user: testAdmin, item: draft, want: true},
+		"ng: other user on hidden item": {user: testBuyer, item: hidden, want: false},
+		"ng: anonymous on hidden item":  {item: hidden, want: false},
+		"ng: moderator on draft":        {user: testModerator, item: draft, want: false},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := canViewItem(tt.user, tt.item); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
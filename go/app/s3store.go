@@ -0,0 +1,266 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config selects a bucket of an S3-compatible object storage, such as Amazon S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.ap-northeast-1.amazonaws.com
+	// or http://localhost:9000. Buckets are addressed path-style under it.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every key, to share a bucket with other data.
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PublicURL is the base URL the bucket is publicly readable from, such as a CDN.
+	// When empty, images are served through the API.
+	PublicURL string
+
+	// Client sends the requests. http.DefaultClient is used when nil.
+	Client *http.Client
+}
+
+// s3ImageStore stores images as objects of an S3 bucket, signing requests with AWS Signature Version 4.
+type s3ImageStore struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	// now returns the signing time; tests replace it.
+	now func() time.Time
+}
+
+// NewS3ImageStore returns an ImageStore keeping the images in the bucket selected by cfg.
+func NewS3ImageStore(cfg S3Config) (ImageStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3 image store needs an endpoint and a bucket")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &s3ImageStore{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+// objectKey returns the key of the object holding image key.
+func (s *s3ImageStore) objectKey(key string) (string, error) {
+	if err := checkImageKey(key); err != nil {
+		return "", err
+	}
+	return path.Join(s.cfg.Prefix, key), nil
+}
+
+// do sends a signed request for the object holding image key.
+func (s *s3ImageStore) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	objectKey, err := s.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	u := *s.endpoint
+	u.Path = path.Join("/", s.endpoint.Path, s.cfg.Bucket, objectKey)
+	u.RawPath = s3EscapePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	if method == http.MethodPut {
+		if format, ok := imageFormatFromExtension(path.Ext(key)); ok {
+			req.Header.Set("Content-Type", format.ContentType())
+		}
+	}
+	s.sign(req, body)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send S3 request: %w", err)
+	}
+	return res, nil
+}
+
+// s3Error reads the error response res and closes its body.
+func s3Error(op, key string, res *http.Response) error {
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errImageNotFound, key)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	return fmt.Errorf("failed to %s image %s: S3 responded %s: %s", op, key, res.Status, bytes.TrimSpace(msg))
+}
+
+func (s *s3ImageStore) Put(ctx context.Context, key string, data []byte) error {
+	res, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 {
+		return s3Error("put", key, res)
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *s3ImageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	res, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, s3Error("get", key, res)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", key, err)
+	}
+	return data, nil
+}
+
+func (s *s3ImageStore) Exists(ctx context.Context, key string) (bool, error) {
+	res, err := s.do(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, s3Error("stat", key, res)
+	}
+	res.Body.Close()
+	return true, nil
+}
+
+// Delete removes the image key. Like S3 itself, deleting a missing image is not an error.
+func (s *s3ImageStore) Delete(ctx context.Context, key string) error {
+	res, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusNotFound {
+		return s3Error("delete", key, res)
+	}
+	res.Body.Close()
+	return nil
+}
+
+// URL returns the public URL of the object when the bucket is publicly readable,
+// and the path the API serves key under otherwise.
+func (s *s3ImageStore) URL(key string) string {
+	if s.cfg.PublicURL == "" {
+		return apiImageURL(key)
+	}
+	objectKey, err := s.objectKey(key)
+	if err != nil {
+		return apiImageURL(key)
+	}
+	return strings.TrimSuffix(s.cfg.PublicURL, "/") + "/" + s3EscapePath(objectKey)
+}
+
+const (
+	s3SigningAlgorithm = "AWS4-HMAC-SHA256"
+	s3TimeFormat       = "20060102T150405Z"
+)
+
+// sign adds the AWS Signature Version 4 headers to req, whose body is body.
+func (s *s3ImageStore) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// every header set so far is signed, along with the host
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.cfg.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3SigningAlgorithm,
+		now.Format(s3TimeFormat),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningAlgorithm, s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath percent-encodes every byte of p but the unreserved characters and slashes,
+// as Signature Version 4 expects of the canonical URI.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
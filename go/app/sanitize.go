@@ -0,0 +1,361 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+// sanitizedJPEGQuality is the quality JPEG images are re-encoded with when they have to be rotated.
+const sanitizedJPEGQuality = 92
+
+// sanitizeImage removes the metadata of an image in format, such as EXIF (including GPS
+// coordinates), XMP, IPTC and comments, and returns the cleaned image with its format.
+//
+// Metadata is dropped losslessly by rewriting the container when the image needs no rotation.
+// When EXIF orientation asks for one, the pixels are decoded, turned upright and re-encoded:
+// JPEG as JPEG, and everything else as PNG, since there is no WebP encoder.
+func sanitizeImage(data []byte, format ImageFormat) ([]byte, ImageFormat, error) {
+	var (
+		clean       []byte
+		orientation int
+		err         error
+	)
+	switch format {
+	case ImageFormatJPEG:
+		clean, orientation, err = stripJPEGMetadata(data)
+	case ImageFormatPNG:
+		clean, orientation, err = stripPNGMetadata(data)
+	case ImageFormatGIF:
+		clean, err = stripGIFMetadata(data)
+	case ImageFormatWebP:
+		clean, orientation, err = stripWebPMetadata(data)
+	default:
+		return nil, "", errUnsupportedImage
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: invalid %s image: %v", errUnsupportedImage, format, err)
+	}
+	if orientation <= 1 || orientation > 8 {
+		return clean, format, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(clean))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: invalid %s image: %v", errUnsupportedImage, format, err)
+	}
+	upright := orientImage(img, orientation)
+
+	var buf bytes.Buffer
+	if format == ImageFormatJPEG {
+		err = jpeg.Encode(&buf, upright, &jpeg.Options{Quality: sanitizedJPEGQuality})
+	} else {
+		format = ImageFormatPNG
+		err = png.Encode(&buf, upright)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode upright image: %w", err)
+	}
+	return buf.Bytes(), format, nil
+}
+
+// orientImage applies an EXIF orientation (2 to 8) to img so that it displays upright.
+func orientImage(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// orientations 5 to 8 swap the axes
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			i, j := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// exifOrientation reads the orientation tag (0x0112) from the first IFD of an EXIF
+// TIFF structure. It returns 0 when there is none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// tag, type SHORT (3), count 1, then the value in the first two bytes
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// JPEG application segments kept by stripJPEGMetadata, with the prefix their payload must
+// start with: JFIF (APP0), ICC color profiles (APP2, shared with other data such as the
+// Multi-Picture Format index) and the Adobe color transform (APP14).
+var keptJPEGAppMarkers = map[byte]string{0xe0: "", 0xe2: "ICC_PROFILE\x00", 0xee: ""}
+
+// stripJPEGMetadata drops the EXIF and XMP (APP1), IPTC (APP13), comment and other
+// application segments of a JPEG image, leaving the compressed data untouched.
+// Anything after the end of image marker, such as the secondary images of the Multi-Picture
+// Format which carry their own EXIF, is dropped as well.
+// It returns the EXIF orientation found in the dropped segments.
+func stripJPEGMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, errors.New("missing start of image")
+	}
+	out := append(make([]byte, 0, len(data)), 0xff, 0xd8)
+	orientation := 0
+
+	for i := 2; ; {
+		// markers may be preceded by fill bytes
+		for i < len(data) && data[i] == 0xff && i+1 < len(data) && data[i+1] == 0xff {
+			i++
+		}
+		if i+2 <= len(data) && data[i] == 0xff && data[i+1] == 0xd9 {
+			return append(out, 0xff, 0xd9), orientation, nil
+		}
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, 0, errors.New("truncated segment")
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errors.New("truncated segment")
+		}
+		segment := data[i:end]
+		payload := data[i+4 : end]
+
+		isApp := marker >= 0xe0 && marker <= 0xef
+		prefix, kept := keptJPEGAppMarkers[marker]
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			if o := exifOrientation(payload[6:]); o != 0 {
+				orientation = o
+			}
+		case marker == 0xfe, isApp && !(kept && bytes.HasPrefix(payload, []byte(prefix))):
+			// comments and other application data
+		default:
+			out = append(out, segment...)
+		}
+		i = end
+
+		if marker == 0xda {
+			// start of scan: the entropy-coded data runs up to the next marker, skipping
+			// stuffed zero bytes and restart markers
+			for ; i+1 < len(data); i++ {
+				if next := data[i+1]; data[i] == 0xff && next != 0 && next != 0xff && (next < 0xd0 || next > 0xd7) {
+					break
+				}
+			}
+			if i+1 >= len(data) {
+				return nil, 0, errors.New("missing end of image")
+			}
+			out = append(out, data[end:i]...)
+		}
+	}
+}
+
+// PNG chunks kept by stripPNGMetadata besides the critical ones, which are always kept:
+// those affecting how pixels display.
+var keptPNGChunks = map[string]bool{
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true, "sBIT": true,
+	"bKGD": true, "pHYs": true, "acTL": true, "fcTL": true, "fdAT": true,
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// stripPNGMetadata drops the text (tEXt, zTXt, iTXt, where XMP lives), time and EXIF
+// (eXIf) chunks of a PNG image and any other ancillary chunk not affecting display.
+// It returns the EXIF orientation found in the dropped chunks.
+func stripPNGMetadata(data []byte) ([]byte, int, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, 0, errors.New("missing signature")
+	}
+	out := append(make([]byte, 0, len(data)), pngSignature...)
+	orientation := 0
+
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, 0, errors.New("truncated chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, 0, errors.New("truncated chunk")
+		}
+		typ := string(data[i+4 : i+8])
+		// critical chunks start with an upper case letter
+		critical := typ[0] >= 'A' && typ[0] <= 'Z'
+		switch {
+		case typ == "eXIf":
+			orientation = exifOrientation(data[i+8 : i+8+length])
+		case critical || keptPNGChunks[typ]:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if typ == "IEND" {
+			break
+		}
+	}
+	return out, orientation, nil
+}
+
+// stripGIFMetadata drops the comment and XMP extensions of a GIF image.
+// Other application extensions, such as the animation loop count, are kept.
+func stripGIFMetadata(data []byte) ([]byte, error) {
+	if len(data) < 13 {
+		return nil, errors.New("truncated header")
+	}
+	i := 13
+	// global color table
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 0x07) + 1)
+	}
+	if i > len(data) {
+		return nil, errors.New("truncated color table")
+	}
+	out := append(make([]byte, 0, len(data)), data[:i]...)
+
+	// subBlocks returns the end of the data sub-blocks starting at j.
+	subBlocks := func(j int) (int, error) {
+		for j < len(data) {
+			size := int(data[j])
+			j += 1 + size
+			if size == 0 {
+				return j, nil
+			}
+		}
+		return 0, errors.New("truncated block")
+	}
+
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3b: // trailer
+			return append(out, 0x3b), nil
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, errors.New("truncated extension")
+			}
+			label := data[i+1]
+			end, err := subBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			xmp := label == 0xff && bytes.HasPrefix(data[i+2:], []byte("\x0bXMP DataXMP"))
+			if label != 0xfe && !xmp {
+				out = append(out, data[start:end]...)
+			}
+			i = end
+		case 0x2c: // image descriptor, local color table, then the image data
+			if i+10 > len(data) {
+				return nil, errors.New("truncated image descriptor")
+			}
+			j := i + 10
+			if flags := data[i+9]; flags&0x80 != 0 {
+				j += 3 << ((flags & 0x07) + 1)
+			}
+			// skip the LZW minimum code size
+			end, err := subBlocks(j + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[start:end]...)
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected block 0x%02x", data[i])
+		}
+	}
+	return nil, errors.New("missing trailer")
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks of a WebP image and clears their flags
+// in the VP8X header. It returns the EXIF orientation found in the dropped chunks.
+func stripWebPMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, errors.New("missing RIFF header")
+	}
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	orientation := 0
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, 0, errors.New("truncated chunk")
+		}
+		typ := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		// chunks are padded to an even size
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			return nil, 0, errors.New("truncated chunk")
+		}
+		switch typ {
+		case "EXIF":
+			payload := data[i+8 : i+8+size]
+			// some encoders keep the JPEG APP1 identifier
+			payload = bytes.TrimPrefix(payload, []byte("Exif\x00\x00"))
+			orientation = exifOrientation(payload)
+		case "XMP ":
+		case "VP8X":
+			chunk := bytes.Clone(data[i:end])
+			if size > 0 {
+				// clear the EXIF (0x08) and XMP (0x04) flags
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, orientation, nil
+}
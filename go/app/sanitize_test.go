@@ -0,0 +1,311 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+// testEXIF returns an EXIF TIFF structure holding an orientation and a GPS IFD pointer.
+func testEXIF(order binary.AppendByteOrder, orientation int) []byte {
+	var b []byte
+	if order == binary.LittleEndian {
+		b = append(b, "II"...)
+	} else {
+		b = append(b, "MM"...)
+	}
+	b = order.AppendUint16(b, 42)
+	b = order.AppendUint32(b, 8)
+	b = order.AppendUint16(b, 2)
+	// orientation: SHORT, count 1
+	b = order.AppendUint16(b, 0x0112)
+	b = order.AppendUint16(b, 3)
+	b = order.AppendUint32(b, 1)
+	b = order.AppendUint16(b, uint16(orientation))
+	b = order.AppendUint16(b, 0)
+	// GPS IFD pointer: LONG, count 1
+	b = order.AppendUint16(b, 0x8825)
+	b = order.AppendUint16(b, 4)
+	b = order.AppendUint32(b, 1)
+	b = order.AppendUint32(b, 0)
+	// no next IFD
+	b = order.AppendUint32(b, 0)
+	return append(b, "GPS 35.6812N 139.7671E"...)
+}
+
+// withJPEGSegment inserts a segment right after the start of image marker.
+func withJPEGSegment(jpeg []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+	return append(append(append([]byte{}, jpeg[:2]...), segment...), jpeg[2:]...)
+}
+
+// pngChunk encodes a PNG chunk.
+func pngChunk(typ string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// withPNGChunks inserts chunks right after the IHDR chunk, which always comes first.
+func withPNGChunks(png []byte, chunks ...[]byte) []byte {
+	const ihdrEnd = 8 + 12 + 13
+	out := append([]byte{}, png[:ihdrEnd]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, png[ihdrEnd:]...)
+}
+
+func decodeTestImage(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	return img
+}
+
+func sameTestPixels(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			if color.RGBAModel.Convert(a.At(x, y)) != color.RGBAModel.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestSanitizeJPEG(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok: metadata dropped losslessly", func(t *testing.T) {
+		t.Parallel()
+
+		// the placeholder carries EXIF, XMP, Photoshop/IPTC and an ICC profile
+		original, err := os.ReadFile(defaultImagePath)
+		if err != nil {
+			t.Fatalf("failed to read image: %v", err)
+		}
+		got, format, err := sanitizeImage(original, ImageFormatJPEG)
+		if err != nil {
+			t.Fatalf("failed to sanitize: %v", err)
+		}
+		if format != ImageFormatJPEG {
+			t.Errorf("expected jpeg, got %s", format)
+		}
+		for _, leak := range []string{"Exif\x00\x00", "http://ns.adobe.com/xap", "Photoshop"} {
+			if bytes.Contains(got, []byte(leak)) {
+				t.Errorf("expected %q to be stripped", leak)
+			}
+		}
+		if !bytes.Contains(got, []byte("ICC_PROFILE")) {
+			t.Errorf("expected the color profile to be kept")
+		}
+		if !sameTestPixels(decodeTestImage(t, original), decodeTestImage(t, got)) {
+			t.Errorf("expected the pixels to be unchanged")
+		}
+	})
+
+	t.Run("ok: orientation applied", func(t *testing.T) {
+		t.Parallel()
+
+		original := withJPEGSegment(testJPEG(t, 40, 20), 0xe1, append([]byte("Exif\x00\x00"), testEXIF(binary.BigEndian, 6)...))
+		got, _, err := sanitizeImage(original, ImageFormatJPEG)
+		if err != nil {
+			t.Fatalf("failed to sanitize: %v", err)
+		}
+		if bytes.Contains(got, []byte("Exif")) || bytes.Contains(got, []byte("GPS")) {
+			t.Errorf("expected EXIF and GPS data to be stripped")
+		}
+		if b := decodeTestImage(t, got).Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+			t.Errorf("expected an upright 20x40 image, got %dx%d", b.Dx(), b.Dy())
+		}
+	})
+
+	t.Run("ok: multi-picture data dropped", func(t *testing.T) {
+		t.Parallel()
+
+		// phones index the secondary images in an APP2 segment and append them after the
+		// end of image marker, each with its own EXIF
+		primary := testJPEG(t, 8, 8)
+		secondary := withJPEGSegment(testJPEG(t, 4, 4), 0xe1, append([]byte("Exif\x00\x00"), testEXIF(binary.LittleEndian, 1)...))
+		original := withJPEGSegment(primary, 0xe2, []byte("MPF\x00MM\x00\x2a\x00\x00\x00\x08"))
+		original = append(original, secondary...)
+
+		got, _, err := sanitizeImage(original, ImageFormatJPEG)
+		if err != nil {
+			t.Fatalf("failed to sanitize: %v", err)
+		}
+		if !bytes.Equal(primary, got) {
+			t.Errorf("expected only the primary image to be kept")
+		}
+		for _, leak := range []string{"MPF", "Exif", "GPS"} {
+			if bytes.Contains(got, []byte(leak)) {
+				t.Errorf("expected %q to be stripped", leak)
+			}
+		}
+	})
+
+	t.Run("ng: truncated", func(t *testing.T) {
+		t.Parallel()
+
+		if _, _, err := sanitizeImage([]byte{0xff, 0xd8, 0xff, 0xe1, 0x10}, ImageFormatJPEG); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
+
+func TestSanitizePNG(t *testing.T) {
+	t.Parallel()
+
+	plain := testPNG(t, 6, 3)
+	text := pngChunk("tEXt", []byte("Comment\x00taken at home"))
+	xmp := pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))
+
+	t.Run("ok: metadata dropped losslessly", func(t *testing.T) {
+		t.Parallel()
+
+		got, _, err := sanitizeImage(withPNGChunks(plain, text, xmp, pngChunk("eXIf", testEXIF(binary.LittleEndian, 1))), ImageFormatPNG)
+		if err != nil {
+			t.Fatalf("failed to sanitize: %v", err)
+		}
+		if !bytes.Equal(plain, got) {
+			t.Errorf("expected the metadata chunks to be removed and nothing else")
+		}
+	})
+
+	t.Run("ok: orientation applied", func(t *testing.T) {
+		t.Parallel()
+
+		got, format, err := sanitizeImage(withPNGChunks(plain, pngChunk("eXIf", testEXIF(binary.LittleEndian, 8))), ImageFormatPNG)
+		if err != nil {
+			t.Fatalf("failed to sanitize: %v", err)
+		}
+		if format != ImageFormatPNG {
+			t.Errorf("expected png, got %s", format)
+		}
+		if b := decodeTestImage(t, got).Bounds(); b.Dx() != 3 || b.Dy() != 6 {
+			t.Errorf("expected an upright 3x6 image, got %dx%d", b.Dx(), b.Dy())
+		}
+	})
+}
+
+func TestSanitizeGIF(t *testing.T) {
+	t.Parallel()
+
+	plain := testGIF(t, 4, 4)
+	// the blocks start after the header, the logical screen descriptor and the global color table
+	start := 13
+	if flags := plain[10]; flags&0x80 != 0 {
+		start += 3 << ((flags & 0x07) + 1)
+	}
+	comment := []byte("\x21\xfe\x05hello\x00")
+	xmp := []byte("\x21\xff\x0bXMP DataXMP\x0c<x:xmpmeta/>\x00")
+	loop := []byte("\x21\xff\x0bNETSCAPE2.0\x03\x01\x00\x00\x00")
+
+	var withMetadata []byte
+	withMetadata = append(withMetadata, plain[:start]...)
+	withMetadata = append(withMetadata, loop...)
+	withMetadata = append(withMetadata, comment...)
+	withMetadata = append(withMetadata, xmp...)
+	withMetadata = append(withMetadata, plain[start:]...)
+
+	got, _, err := sanitizeImage(withMetadata, ImageFormatGIF)
+	if err != nil {
+		t.Fatalf("failed to sanitize: %v", err)
+	}
+	var want []byte
+	want = append(want, plain[:start]...)
+	want = append(want, loop...)
+	want = append(want, plain[start:]...)
+	if !bytes.Equal(want, got) {
+		t.Errorf("expected only the comment and XMP extensions to be removed")
+	}
+}
+
+func TestSanitizeWebP(t *testing.T) {
+	t.Parallel()
+
+	riffChunk := func(typ string, payload []byte) []byte {
+		c := append([]byte(typ), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+		c = append(c, payload...)
+		if len(payload)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	// the padded VP8L chunk of a 2x2 image, as built by testWebP
+	vp8l := riffChunk("VP8L", testWebP(2, 2)[20:])
+	vp8x := func(flags byte) []byte {
+		return riffChunk("VP8X", []byte{flags, 0, 0, 0, 1, 0, 0, 1, 0, 0})
+	}
+	riff := func(chunks ...[]byte) []byte {
+		body := []byte("WEBP")
+		for _, c := range chunks {
+			body = append(body, c...)
+		}
+		return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+	}
+
+	original := riff(vp8x(0x08|0x04), vp8l, riffChunk("EXIF", testEXIF(binary.LittleEndian, 1)), riffChunk("XMP ", []byte("<x:xmpmeta/>")))
+	got, _, err := sanitizeImage(original, ImageFormatWebP)
+	if err != nil {
+		t.Fatalf("failed to sanitize: %v", err)
+	}
+	if want := riff(vp8x(0), vp8l); !bytes.Equal(want, got) {
+		t.Errorf("expected the EXIF and XMP chunks and flags to be removed, got %q", got)
+	}
+	if _, err := inspectImage(got); err != nil {
+		t.Errorf("expected a valid image: %v", err)
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	t.Parallel()
+
+	// a 2x1 image: red on the left, blue on the right
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	cases := map[string]struct {
+		orientation int
+		// want lists the pixels of the result row by row
+		want [][]color.RGBA
+	}{
+		"2: mirrored":                  {orientation: 2, want: [][]color.RGBA{{blue, red}}},
+		"3: upside down":               {orientation: 3, want: [][]color.RGBA{{blue, red}}},
+		"6: rotated clockwise":         {orientation: 6, want: [][]color.RGBA{{red}, {blue}}},
+		"8: rotated counter-clockwise": {orientation: 8, want: [][]color.RGBA{{blue}, {red}}},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := orientImage(src, tt.orientation)
+			if b := got.Bounds(); b.Dy() != len(tt.want) || b.Dx() != len(tt.want[0]) {
+				t.Fatalf("unexpected size %dx%d", b.Dx(), b.Dy())
+			}
+			for y, row := range tt.want {
+				for x, want := range row {
+					if c := color.RGBAModel.Convert(got.At(x, y)); c != want {
+						t.Errorf("pixel (%d, %d): expected %v, got %v", x, y, want, c)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package app
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,13 +15,33 @@ import (
 	"strings"
 	"strconv" 
 	"context"
+	"time"
 )
 
 type Server struct {
 	// Port is the port number to listen on.
 	Port string
-	// ImageDirPath is the path to the directory storing images.
+	// ImageDirPath is the path to the directory storing images, unless Images is set.
 	ImageDirPath string
+	// Images stores the images instead of ImageDirPath, e.g. an S3 bucket shared by every replica.
+	Images ImageStore
+	// Database configures the item database backend.
+	Database DatabaseConfig
+	// Uploads bounds the size of uploaded requests and images.
+	Uploads UploadLimits
+	// ImageGC schedules the removal of unreferenced files from the image directory.
+	// It only runs when the images are stored on the local file system.
+	ImageGC ImageGCConfig
+	// ImageURLKey signs the image URLs of items that are not public. Replicas must share it;
+	// when empty, a random key is generated and the URLs stop working on restart.
+	ImageURLKey []byte
+	// ImageURLTTL is how long signed image URLs stay valid, DefaultImageURLTTL when zero.
+	ImageURLTTL time.Duration
+	// Auth configures user accounts and their sessions.
+	Auth AuthConfig
+	// ImportImageHosts lists the hosts POST /items/import may fetch images from by URL.
+	// When empty, imported images must come in the uploaded archive.
+	ImportImageHosts []string
 }
 
 type Items struct {
@@ -39,26 +61,68 @@ func (s Server) Run() int {
 	}
 
 	// STEP 5-1: set up the database connection
-	
-	itemRepo, err := NewItemRepository()
+	db, d, err := setupDatabase(s.Database)
+	if err != nil {
+		slog.Error("failed to set up database", "error", err)
+		return 1
+	}
+	itemRepo := &itemRepository{db: db, dialect: d, queryTimeout: s.Database.QueryTimeout}
+	categoryRepo := &categoryRepository{db: db, dialect: d, queryTimeout: s.Database.QueryTimeout}
+	imageRepo := &imageRepository{db: db, dialect: d, queryTimeout: s.Database.QueryTimeout}
+	userRepo := &userRepository{db: db, dialect: d, queryTimeout: s.Database.QueryTimeout}
+	auditRepo := &auditRepository{db: db, dialect: d, queryTimeout: s.Database.QueryTimeout}
+	apiKeyRepo := &apiKeyRepository{db: db, dialect: d, queryTimeout: s.Database.QueryTimeout}
+
+	images := s.setupImages(context.Background(), db)
+
+	if len(s.ImageURLKey) == 0 {
+		slog.Warn("no image URL key configured: signed image URLs will not survive a restart")
+	}
+	imageURLs, err := newImageURLSigner(s.ImageURLKey, s.ImageURLTTL)
 	if err != nil {
-		slog.Error("failed to create item repository", "error", err)
+		slog.Error("failed to set up image URL signing", "error", err)
 		return 1
 	}
 
-	h := &Handlers{imgDirPath: s.ImageDirPath, itemRepo: itemRepo}
+	h := &Handlers{images: images, itemRepo: itemRepo, categoryRepo: categoryRepo, imageRepo: imageRepo, uploads: s.Uploads, imageURLs: imageURLs,
+		userRepo: userRepo, auditRepo: auditRepo, apiKeyRepo: apiKeyRepo, auth: s.Auth, importClient: newImportClient(s.ImportImageHosts)}
 
 	// set up routes
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /", h.Hello)
-	mux.HandleFunc("GET /items", h.GetItems)
-	mux.HandleFunc("POST /items", h.AddItem)
-	mux.HandleFunc("GET /images/{filename}", h.GetImage)
-	mux.HandleFunc("GET /items/{item_id}", h.GetItem)
-	mux.HandleFunc("GET /search",h.SearchItems)
+	routes := router{mux: mux}
+	routes.public("GET /", h.Hello)
+	routes.public("GET /items", h.GetItems)
+	routes.scoped("POST /items", ScopeItemsWrite, h.AddItem)
+	routes.scoped("POST /items/import", ScopeItemsWrite, h.ImportItems)
+	routes.public("GET /items/export", h.ExportItems)
+	routes.public("GET /images/{filename}", h.GetImage)
+	routes.public("GET /items/{item_id}", h.GetItem)
+	routes.scoped("DELETE /items/{item_id}", ScopeItemsWrite, h.DeleteItem)
+	routes.scoped("POST /items/{item_id}/images", ScopeItemsWrite, h.AddItemImages)
+	routes.scoped("PUT /items/{item_id}/images", ScopeItemsWrite, h.ReorderItemImages)
+	routes.scoped("DELETE /items/{item_id}/images/{filename}", ScopeItemsWrite, h.RemoveItemImage)
+	routes.public("GET /items/{item_id}/similar-images", h.GetSimilarImages)
+	routes.scoped("PUT /items/{item_id}/status", ScopeItemsWrite, h.UpdateItemStatus)
+	routes.authorized("PUT /items/{item_id}/hidden", PermissionHideItems, h.SetItemHidden)
+	routes.public("GET /search",h.SearchItems)
+	routes.public("POST /users", h.RegisterUser)
+	routes.public("GET /users/{user_id}/items", h.GetUserItems)
+	routes.authorized("PUT /users/{user_id}/ban", PermissionBanUsers, h.BanUser)
+	routes.authorized("PUT /users/{user_id}/role", PermissionManageRoles, h.SetUserRole)
+	routes.public("GET /categories", h.GetCategories)
+	routes.authorized("POST /categories", PermissionManageCategories, h.AddCategory)
+	routes.authorized("GET /audit-log", PermissionViewAuditLog, h.GetAuditLog)
+	routes.public("POST /sessions", h.Login)
+	routes.public("POST /sessions/refresh", h.RefreshSession)
+	routes.authenticated("DELETE /sessions", h.Logout)
+	routes.authenticated("GET /me", h.GetMe)
+	routes.authenticated("DELETE /me/sessions", h.RevokeSessions)
+	routes.authenticated("GET /me/api-keys", h.GetAPIKeys)
+	routes.authenticated("POST /me/api-keys", h.CreateAPIKey)
+	routes.authenticated("DELETE /me/api-keys/{key_id}", h.DeleteAPIKey)
 
 	// start the server
-	err = http.ListenAndServe(":"+s.Port, simpleCORSMiddleware(simpleLoggerMiddleware(mux), frontURL, []string{"GET", "HEAD", "POST", "OPTIONS"}))
+	err = http.ListenAndServe(":"+s.Port, corsMiddleware(simpleLoggerMiddleware(authMiddleware(mux, h.authenticate)), frontURL, []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}))
 	if err != nil {
 		slog.Error("failed to start server: ", "error", err)
 		return 1
@@ -66,10 +130,35 @@ func (s Server) Run() int {
 
 	return 0
 }
+// setupImages returns where images are stored and, when that is a directory of the local file
+// system, starts removing its unreferenced files in the background until ctx is done.
+func (s Server) setupImages(ctx context.Context, db *sql.DB) ImageStore {
+	images := s.Images
+	if images == nil {
+		images = NewFileImageStore(s.ImageDirPath)
+	}
+	if files, ok := images.(*fileImageStore); ok && s.ImageGC.Interval > 0 {
+		go runImageGC(ctx, db, files.dir, s.ImageGC)
+	}
+	return images
+}
+
 type Handlers struct {
-	// imgDirPath is the path to the directory storing images.
-	imgDirPath string
-	itemRepo   ItemRepository
+	// images stores the uploaded images and their variants.
+	images       ImageStore
+	itemRepo     ItemRepository
+	categoryRepo CategoryRepository
+	imageRepo    ImageRepository
+	uploads      UploadLimits
+	// imageURLs signs the image URLs of items that are not public.
+	imageURLs *imageURLSigner
+	userRepo  UserRepository
+	auditRepo AuditRepository
+	// apiKeyRepo holds the API keys of server-to-server integrations.
+	apiKeyRepo APIKeyRepository
+	auth       AuthConfig
+	// importClient fetches the images given by URL in POST /items/import, nil to refuse them.
+	importClient *http.Client
 }
 
 type HelloResponse struct {
@@ -89,7 +178,10 @@ func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
 type AddItemRequest struct {
 	Name			string `form:"name"`
 	Category	 	string `json:"category"`
-	Image 			[]byte
+	// Status is the status of the new item, public unless set.
+	Status			ItemStatus
+	// Images are the uploaded images in display order, the first one being the cover.
+	Images			[][]byte
 }
 
 type AddItemResponse struct {
@@ -98,7 +190,7 @@ type AddItemResponse struct {
 
 // parseAddItemRequest parses and validates the incoming request for adding an item.
 func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
-    err := r.ParseMultipartForm(10 << 20) // 10MB までのファイルを処理
+    err := r.ParseMultipartForm(uploadMemoryBytes) // 10MB までメモリで処理し、残りはディスクへ
 	if err != nil {
     	return nil, fmt.Errorf("failed to parse multipart form: %w", err)
 	}
@@ -106,45 +198,94 @@ func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
     	Name:     r.Form.Get("name"), // ここを修正
     	Category: r.Form.Get("category"),
 	}
-
-    // Read the image file (Note: this should happen in the AddItem handler, not here)
-    imageFile, _, err := r.FormFile("image")
+    req.Status, err = parseItemStatus(r.Form.Get("status"))
     if err != nil {
-        return nil, fmt.Errorf("failed to retrieve image file: %w", err)
+        return nil, err
     }
-    defer imageFile.Close()
 
-    imageData, err := io.ReadAll(imageFile)
+    // Read the image files, in the order of the parts
+    images, err := readImageParts(r)
     if err != nil {
-        return nil, fmt.Errorf("failed to read image data: %w", err)
+        return nil, err
     }
-    req.Image = imageData
+    req.Images = images
 
     // Input validation
+    if err := validateAddItemRequest(req); err != nil {
+        return nil, err
+    }
+
+    return req, nil
+}
+
+// readImageParts reads the content of every "image" file part of a parsed multipart form.
+func readImageParts(r *http.Request) ([][]byte, error) {
+    if r.MultipartForm == nil || len(r.MultipartForm.File["image"]) == 0 {
+        return nil, errors.New("failed to retrieve image file: no image part")
+    }
+    headers := r.MultipartForm.File["image"]
+    if len(headers) > maxItemImages {
+        return nil, errTooManyImages
+    }
+    images := make([][]byte, 0, len(headers))
+    for _, header := range headers {
+        f, err := header.Open()
+        if err != nil {
+            return nil, fmt.Errorf("failed to retrieve image file: %w", err)
+        }
+        data, err := io.ReadAll(f)
+        f.Close()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read image data: %w", err)
+        }
+        images = append(images, data)
+    }
+    return images, nil
+}
+
+// validateAddItemRequest checks the fields every new item needs.
+// It is shared by AddItem and the bulk importer.
+func validateAddItemRequest(req *AddItemRequest) error {
     if req.Name == "" {
-        return nil, errors.New("name is required")
+        return errors.New("name is required")
     }
     if req.Category == "" {
-        return nil, errors.New("category is required")
+        return errors.New("category is required")
     }
-
-    return req, nil
+    if len(req.Images) == 0 {
+        return errors.New("image is required")
+    }
+    if len(req.Images) > maxItemImages {
+        return errTooManyImages
+    }
+    for _, image := range req.Images {
+        if len(image) == 0 {
+            return errors.New("image is required")
+        }
+    }
+    return nil
 }
 
 // AddItem handles the POST request to add a new item
 func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
+    seller := userFromContext(ctx)
+    if seller == nil {
+        writeAuthError(w, errUnauthenticated)
+        return
+    }
 
+    s.limitUploadBody(w, r)
     req, err := parseAddItemRequest(r)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        writeUploadError(w, err)
         return
     }
 	
     // ハッシュ化して画像を保存
-    imageFileName, err := s.storeImage(req.Image)
+    imageFileNames, rollback, err := s.storeImages(ctx, req.Images)
     if err != nil {
-        http.Error(w, "Failed to save image", http.StatusInternalServerError)
+        writeStoreImageError(w, err)
         return
     }
 
@@ -152,34 +293,66 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
     item := &Item{
         Name:          req.Name,
         Category:      req.Category,
-        ImageFileName: imageFileName, // ハッシュ化したファイル名を使用
+        ImageFileName: imageFileNames[0], // ハッシュ化したファイル名を使用
+        Images:        imageFileNames,
+        Status:        req.Status,
+        SellerID:      seller.ID,
     }
 
     // データベースにアイテムを挿入
     err = s.itemRepo.Insert(ctx, item)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        // the images stored for the item would stay unreferenced
+        rollback()
+        http.Error(w, err.Error(), repositoryErrorStatus(err))
         return
     }
 
     // レスポンスの準備
+    s.setImageURLs(item)
     resp := map[string]interface{}{
         "item": item,
     }
+    // a listing showing the same pictures as another one may be a duplicate; this is only
+    // a warning, so a failed lookup does not fail the request
+    similar, err := s.findSimilarImages(ctx, item.ID, imageFileNames)
+    if err != nil {
+        slog.Warn("failed to look for similar images", "item_id", item.ID, "error", err)
+    }
+    resp["possible_duplicate"] = len(similar) > 0
+    if len(similar) > 0 {
+        resp["similar_images"] = similar
+    }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(resp)
 }
 
 
+// repositoryErrorStatus maps an error returned by ItemRepository to an HTTP status code.
+// A query that ran past its deadline is reported as 504, and a query cancelled
+// because the client went away is reported as 503.
+func repositoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errQueryTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *Handlers) GetItems(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	items, err := s.itemRepo.LoadItems(ctx)
 	if err != nil {
 		slog.Error("failed to get items from DB", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
 		return
 	}
+	items = visibleItems(userFromContext(ctx), items)
+	s.setImageURLs(items...)
 
 	resp := map[string]interface{}{
 		"items": items,
@@ -210,39 +383,90 @@ func ensureImageDirExists(dir string) error {
     return nil
 }
 
-// storeImage stores an image and returns the file path and an error if any.
+// putImage stores an image and returns the file name and an error if any.
 // this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
-// and stores it in the image directory.
-// storeImage stores an image and returns the file path and an error if any.
-func (s *Handlers) storeImage(image []byte) (string, error) {
-	if err := ensureImageDirExists(s.imgDirPath); err != nil {
-        return "", err
-    }
-    hash := sha256.Sum256(image)
-	fileName := fmt.Sprintf("%x.jpg", hash)
-    filePath := filepath.Join(s.imgDirPath, fileName)
-    // Create hashed image file name
-    //hash.Write(image)  // Hash the image
-    //hashValue := hash.Sum256(nil)
-    //hashedFileName := fmt.Sprintf("%s.jpg", hex.EncodeToString(hashValue))
-    // Create the save path
-    //filePath = filepath.Join(s.imgDirPath, hashedFileName)
-    // Save the file
-    outFile, err := os.Create(filePath)
-    if err != nil {
-        return "", fmt.Errorf("failed to create image file: %w", err)
-    }
-    defer outFile.Close()
-	// Write the image data
-    _, err = outFile.Write(image)
-    if err != nil {
-        return "", fmt.Errorf("failed to save image: %w", err)
-    }
-	slog.Info("image saved to", "path", filePath)
-    return fileName, nil
+// and stores it in the image store, with the extension of the format detected from its content.
+// It returns errUnsupportedImage if image is not a JPEG, PNG, GIF or WebP image,
+// and errImageTooLarge if it declares more pixels than the upload limits allow.
+// The image is stripped of its metadata and turned upright first (see sanitizeImage),
+// and the stored image gets its metadata recorded and its resized variants written.
+// Nothing is left behind when recording the metadata fails. It also reports whether the image
+// was written by this call, as opposed to an intact copy stored earlier being reused.
+func (s *Handlers) putImage(ctx context.Context, image []byte) (string, bool, error) {
+	meta, err := inspectImage(image)
+	if err != nil {
+		return "", false, err
+	}
+	// the dimensions come from the header, so this runs before any decoding
+	if err := s.uploads.checkImageDimensions(meta); err != nil {
+		return "", false, err
+	}
+	// drop EXIF/GPS and other metadata before hashing, so that the name matches what is served
+	image, _, err = sanitizeImage(image, meta.Format)
+	if err != nil {
+		return "", false, err
+	}
+	if meta, err = inspectImage(image); err != nil {
+		return "", false, err
+	}
+	hash := sha256.Sum256(image)
+	fileName := fmt.Sprintf("%x%s", hash, meta.Format.Extension())
+	created, err := s.writeImage(ctx, fileName, hash, image)
+	if err != nil {
+		return "", false, err
+	}
+
+	meta.Name = fileName
+	// without a perceptual hash or placeholder, the image is only left out of duplicate
+	// detection and shown without a placeholder
+	if err := describeImage(meta, image); err != nil {
+		slog.Warn("failed to describe image", "name", fileName, "error", err)
+	}
+	if err := s.imageRepo.Insert(ctx, meta); err != nil {
+		if created {
+			s.removeImages(ctx, []string{fileName})
+		}
+		return "", false, fmt.Errorf("failed to record image metadata: %w", err)
+	}
+	return fileName, created, nil
+}
+
+// writeImage stores image under fileName, the name derived from its hash, unless a copy
+// whose content still matches the hash is already stored, and reports whether it wrote it.
+// A copy that no longer matches, such as one truncated by a crash, is replaced.
+func (s *Handlers) writeImage(ctx context.Context, fileName string, hash [sha256.Size]byte, image []byte) (bool, error) {
+	if files, ok := s.images.(*fileImageStore); ok {
+		// an unreferenced copy may be old enough for the garbage collection to remove it
+		// before the item referencing it is saved
+		if err := files.touch(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+	}
+	existing, err := s.images.Get(ctx, fileName)
+	switch {
+	case err == nil && sha256.Sum256(existing) == hash:
+		slog.Info("image already stored", "name", fileName)
+		return false, nil
+	case err == nil:
+		slog.Warn("stored image does not match its hash, replacing it", "name", fileName)
+	case !errors.Is(err, errImageNotFound):
+		return false, fmt.Errorf("failed to check stored image: %w", err)
+	}
+
+	if err := s.images.Put(ctx, fileName, image); err != nil {
+		return false, fmt.Errorf("failed to save image: %w", err)
+	}
+	slog.Info("image saved", "name", fileName)
+
+	// variants missing here are generated on first request
+	if err := generateVariants(ctx, s.images, fileName, image); err != nil {
+		slog.Warn("failed to generate image variants", "name", fileName, "error", err)
+	}
+	return true, nil
 }
 type GetImageRequest struct {
 	FileName string // path value
+	Width    int    // query parameter w, zero for the original image
 }
 // parseGetImageRequest parses and validates the request to get an image.
 func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
@@ -253,10 +477,18 @@ func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
 	if req.FileName == "" {
 		return nil, errors.New("filename is required")
 	}
+	width, err := parseThumbnailWidth(r.URL.Query().Get("w"))
+	if err != nil {
+		return nil, err
+	}
+	req.Width = width
 	return req, nil
 }
 // GetImage is a handler to return an image for GET /images/{filename} .
-// If the specified image is not found, it returns the default image.
+// If the specified image is not found, it returns the default image, which is not cached.
+// Images named by their content hash are cacheable forever and revalidated with their ETag.
+// With ?w=, it returns the variant of the image resized to that width.
+// The images of items that are not public are only served through signed URLs (see imageURLSigner).
 func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
 	req, err := parseGetImageRequest(r)
 	if err != nil {
@@ -264,45 +496,68 @@ func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	imgPath, err := s.buildImagePath(req.FileName)
+	if err := checkImageName(req.FileName); err != nil {
+		slog.Warn("invalid image name: ", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the images of items that are not public need a signed URL, even to revalidate a cached copy
+	expires, err := s.authorizeImage(r, req.FileName)
 	if err != nil {
-		if !errors.Is(err, errImageNotFound) {
-			slog.Warn("failed to build image path: ", "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.Warn("image access denied", "filename", req.FileName, "error", err)
+		http.Error(w, err.Error(), imageAuthorizationStatus(err))
+		return
+	}
+	// content-addressed images never change, so a cached copy of one still stored is always
+	// fresh; the ETag is derived from the name, so a match alone does not tell that it is
+	etag, immutable := imageETag(req.FileName, req.Width)
+	if immutable && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		if exists, err := s.images.Exists(r.Context(), req.FileName); err == nil && exists {
+			setImageCacheHeaders(w.Header(), etag, immutable, false, expires)
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
+	}
+	name, data, err := s.loadImage(r.Context(), req.FileName, req.Width)
+	fallback := errors.Is(err, errImageNotFound)
+	if fallback {
 		slog.Debug("image not found", "filename", req.FileName)
+		// the default image does not validate a cached copy of the requested one
+		r.Header.Del("If-None-Match")
 		// return the default image
-		imgPath = filepath.Join(s.imgDirPath, "default.jpg")
+		name, data, err = s.loadImage(r.Context(), defaultImageName, req.Width)
 	}
-	slog.Info("returned image", "path", imgPath)
-	http.ServeFile(w, r, imgPath)
+	if err != nil {
+		if errors.Is(err, errImageNotFound) {
+			http.Error(w, "image not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to load image", "filename", req.FileName, "error", err)
+		http.Error(w, "failed to load image", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("returned image", "name", name)
+	// the extension is checked by checkImageName and matches the stored content
+	format, _ := imageFormatFromExtension(filepath.Ext(name))
+	w.Header().Set("Content-Type", format.ContentType())
+	setImageCacheHeaders(w.Header(), etag, immutable, fallback, expires)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
 }
 
 
 func (r *itemRepository) LoadItems(ctx context.Context) ([]*Item, error) {
-	query := `
-		SELECT items.id, items.name, categories.name, items.image_name 
-        FROM items 
-        JOIN categories ON items.category_id = categories.id
-		`
-	rows, err := r.db.QueryContext(ctx,query)
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(itemColumns+itemOrder))
 	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve items: %w", err)
+		return nil, queryError(ctx, fmt.Errorf("Failed to retrieve items: %w", err))
 	}
 	defer rows.Close()
 
-	var items []*Item
-	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.ImageFileName); err != nil {
-			return nil, fmt.Errorf("Failed to scan item: %w", err)
-		}
-		items = append(items, &item)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("Error occurred while loading items: %w", err)
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("Error occurred while loading items: %w", err))
 	}
 
 	return items, nil
@@ -311,28 +566,16 @@ func (r *itemRepository) LoadItems(ctx context.Context) ([]*Item, error) {
 
 
 
-// buildImagePath builds the image path and validates it.
-func (s *Handlers) buildImagePath(imageFileName string) (string, error) {
-	imgPath := filepath.Join(s.imgDirPath, filepath.Clean(imageFileName))
-	// to prevent directory traversal attacks
-	rel, err := filepath.Rel(s.imgDirPath, imgPath)
-	if err != nil || strings.HasPrefix(rel, "..") {
-		return "", fmt.Errorf("invalid image path: %s", imgPath)
+// checkImageName validates the name of an image requested by a client:
+// a plain file name, without directories, with a supported image extension.
+func checkImageName(name string) error {
+	if strings.Contains(name, "/") || checkImageKey(name) != nil {
+		return fmt.Errorf("invalid image name: %s", name)
 	}
-	// validate the image suffix
-	if !strings.HasSuffix(imgPath, ".jpg") && !strings.HasSuffix(imgPath, ".jpeg") {
-		return "", fmt.Errorf("image path does not end with .jpg or .jpeg: %s", imgPath)
+	if _, ok := imageFormatFromExtension(filepath.Ext(name)); !ok {
+		return fmt.Errorf("image name does not end with a supported image extension: %s", name)
 	}
-	_, err = os.Stat(imgPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// log when the image is not found
-			slog.Info("Image not found: ", "path", imgPath)
-			return filepath.Join(s.imgDirPath, "default.jpg"), nil  // Default image path
-		}
-		return "", errImageNotFound
-	}
-	return imgPath, nil
+	return nil
 }
 
 func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
@@ -346,63 +589,82 @@ func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
     }
 	slog.Info("Received item_id:", "item_id", id)
 
-   // Get item and category name
-    items, err := s.itemRepo.LoadItems(ctx)
+   // Get item and category name; the drafts of other sellers are not found
+    item, err := s.selectVisibleItem(ctx, id)
     if err != nil {
-        if err.Error() == "item not found" {
+        if errors.Is(err, errItemNotFound) {
             http.Error(w, "item not found", http.StatusNotFound)
         } else {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
+            http.Error(w, err.Error(), repositoryErrorStatus(err))
         }
         return
     }
+	s.setImageURLs(item)
 
-    
-    if len(items) > 0 {
-		item := items[id-1] 
-	
-		resp := Item{
-			ID:             item.ID,
-			Name:           item.Name,
-			Category:       item.Category,
-			ImageFileName:  item.ImageFileName,
-		}
-	
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	} else {
-		http.Error(w, "Item not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// UpdateItemStatusRequest is the new status of an item.
+type UpdateItemStatusRequest struct {
+	Status ItemStatus `json:"status"`
+}
+
+// UpdateItemStatus is a handler to change the status of an item for
+// PUT /items/{item_id}/status . The images of items that are not public are only served
+// through signed URLs from then on.
+func (s *Handlers) UpdateItemStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := parseItemID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req UpdateItemStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
 	}
+	// unlike when adding an item, the status must be given
+	status, err := parseItemStatus(string(req.Status))
+	if err != nil || req.Status == "" {
+		http.Error(w, errInvalidItemStatus.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.authorizeItemChange(ctx, id); err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+
+	item, err := s.itemRepo.UpdateStatus(ctx, id, status)
+	if err != nil {
+		http.Error(w, err.Error(), itemImagesErrorStatus(err))
+		return
+	}
+	s.writeItem(w, item)
 }
 
 // ItemRepository adds the SearchItemsByName method
-func (r *itemRepository) SearchItemsByName(keyword string) ([]*Item, error) {
+func (r *itemRepository) SearchItemsByName(ctx context.Context, keyword string) ([]*Item, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
 	// SQL query using LIKE for partial match search
-	query := ` SELECT items.id, items.name, categories.name, items.image_name 
-        FROM items 
-        JOIN categories ON items.category_id = categories.id
-        WHERE LOWER(items.name) LIKE LOWER(?)`
+	query := itemColumns + `
+        WHERE LOWER(items.name) LIKE LOWER(?)` + itemOrder
 	likeKeyword := "%" + strings.ToLower(keyword) + "%"
 
-	rows, err := r.db.Query(query, likeKeyword)
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(query), likeKeyword)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve items: %w", err)
+		return nil, queryError(ctx, fmt.Errorf("Failed to retrieve items: %w", err))
 	}
 	defer rows.Close()
 
-	var items []*Item
-	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.ImageFileName); err != nil {
-			return nil, fmt.Errorf("Failed to scan item: %w", err)
-		}
-		items = append(items, &item)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("Error occurred while loading items: %w", err)
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, queryError(ctx, fmt.Errorf("Error occurred while loading items: %w", err))
 	}
 
 	return items, nil
@@ -410,6 +672,7 @@ func (r *itemRepository) SearchItemsByName(keyword string) ([]*Item, error) {
 
 // SearchItems is the handler for the GET /search endpoint
 func (s *Handlers) SearchItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	//  Get keyword from query parameter
 	keyword := r.URL.Query().Get("keyword")
 	if keyword == "" {
@@ -418,11 +681,13 @@ func (s *Handlers) SearchItems(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Search items by keyword
-	items, err := s.itemRepo.SearchItemsByName(keyword)
+	items, err := s.itemRepo.SearchItemsByName(ctx, keyword)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), repositoryErrorStatus(err))
 		return
 	}
+	items = visibleItems(userFromContext(ctx), items)
+	s.setImageURLs(items...)
 
 	// Create response structure
 	resp := map[string]interface{}{
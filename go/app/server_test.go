@@ -13,13 +13,21 @@ import (
 	"database/sql"
 	//"io"
 	"fmt"
+	"path/filepath"
+	"crypto/sha256"
+	"context"
+	"time"
+	"slices"
+	"encoding/json"
 	
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/golang/mock/gomock" 
-	_ "github.com/mattn/go-sqlite3"
 )
 
+// defaultImagePath is the placeholder image shipped with the repository, relative to this package.
+const defaultImagePath = "../images/default.jpg"
+
 func TestParseAddItemRequest(t *testing.T) {
 	t.Parallel()
 
@@ -33,7 +41,7 @@ func TestParseAddItemRequest(t *testing.T) {
 		err bool
 	}
 
-	imageBytes, err := os.ReadFile("/home/saway/mercari-build-training/go/images/default.jpg")
+	imageBytes, err := os.ReadFile(filepath.Join(cwd, "..", "images", "default.jpg"))
 	if err != nil {
 		t.Fatalf("failed to read image file: %v", err)
 	} 
@@ -41,6 +49,8 @@ func TestParseAddItemRequest(t *testing.T) {
 	cases := map[string]struct {
 		args 		map[string]string
 		imageData	[]byte
+		// moreImages are sent as additional image parts after imageData
+		moreImages	[][]byte
 		wants
 	}{
 		"ok: valid request": {
@@ -54,11 +64,68 @@ func TestParseAddItemRequest(t *testing.T) {
 				req: &AddItemRequest{
 					Name: 		"jacket", // fill here
 					Category:	"fashion", // fill here
-					Image:		imageBytes,
+					Status:		ItemStatusPublic,
+					Images:		[][]byte{imageBytes},
 				},
 				err: false,
 			},
 		},
+		"ok: several images in order": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+			},
+			imageData:  imageBytes,
+			moreImages: [][]byte{[]byte("second"), []byte("third")},
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "jacket",
+					Category: "fashion",
+					Status:   ItemStatusPublic,
+					Images:   [][]byte{imageBytes, []byte("second"), []byte("third")},
+				},
+			},
+		},
+		"ok: draft": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"status":   "draft",
+			},
+			imageData: imageBytes,
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "jacket",
+					Category: "fashion",
+					Status:   ItemStatusDraft,
+					Images:   [][]byte{imageBytes},
+				},
+			},
+		},
+		"ng: unknown status": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"status":   "sold",
+			},
+			imageData: imageBytes,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
+		"ng: too many images": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+			},
+			imageData:  imageBytes,
+			moreImages: slices.Repeat([][]byte{imageBytes}, maxItemImages),
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
 		"ng: empty request": {
 			args:		map[string]string{},
 			imageData:	nil,
@@ -124,6 +191,13 @@ func TestParseAddItemRequest(t *testing.T) {
 				}
 				part.Write(tt.imageData)
 			}
+			for _, image := range tt.moreImages {
+				part, err := writer.CreateFormFile("image", "more.jpg")
+				if err != nil {
+					t.Fatalf("failed to create form file: %v", err)
+				}
+				part.Write(image)
+			}
 
 			writer.Close()
 
@@ -189,10 +263,27 @@ func TestHelloHandler(t *testing.T) {
 func TestAddItem(t *testing.T) {
     t.Parallel()
 
-	imageBytes, err := os.ReadFile("/home/saway/mercari-build-training/go/images/default.jpg")
+	imageBytes, err := os.ReadFile(defaultImagePath)
 	if err != nil {
     	t.Fatalf("failed to read image file: %v", err)
 	}
+	// the image is stored without its metadata, and named after the stored bytes
+	storedBytes, _, err := sanitizeImage(imageBytes, ImageFormatJPEG)
+	if err != nil {
+		t.Fatalf("failed to sanitize image: %v", err)
+	}
+	expectedImageFileName := fmt.Sprintf("%x.jpg", sha256.Sum256(storedBytes))
+	expectedImage, err := inspectImage(storedBytes)
+	if err != nil {
+		t.Fatalf("failed to inspect image: %v", err)
+	}
+	expectedImage.Name = expectedImageFileName
+	if err := describeImage(expectedImage, storedBytes); err != nil {
+		t.Fatalf("failed to describe image: %v", err)
+	}
+	recordImage := func(m *MockImageRepository) {
+		m.EXPECT().Insert(gomock.Any(), expectedImage).Return(nil).Times(1)
+	}
     type wants struct {
         code int
     }
@@ -200,6 +291,8 @@ func TestAddItem(t *testing.T) {
         args       map[string]string
         imageData  []byte
         injector   func(m *MockItemRepository)
+        // imageInjector defines the expectations on the image repository, if any
+        imageInjector func(m *MockImageRepository)
         wants
     }{
         "ok: correctly inserted": {
@@ -209,14 +302,20 @@ func TestAddItem(t *testing.T) {
                 "image":    "default.jpg",
             },
             imageData: imageBytes,
+            imageInjector: func(m *MockImageRepository) {
+                recordImage(m)
+                m.EXPECT().FindSimilar(gomock.Any(), []string{expectedImageFileName}, similarImageMaxDistance).Return(nil, nil).Times(1)
+            },
             injector: func(m *MockItemRepository) {
 				// STEP 6-3: define mock expectation
 				// succeeded to insert
-				expectedImageFileName := "a1ebfdb99c936f09c57dee11ab7fb3a27dcf3b1dd5ef107bd650345c01ee8f11.jpg"
 				expectedItem := &Item{
 					Name:          "used iPhone 16e",
 					Category:      "phone",
 					ImageFileName: expectedImageFileName,
+					Images:        []string{expectedImageFileName},
+					Status:        ItemStatusPublic,
+					SellerID:      testSeller.ID,
 				}
 			
 				m.EXPECT().
@@ -234,14 +333,17 @@ func TestAddItem(t *testing.T) {
                 "image":    "default.jpg",
             },
             imageData: imageBytes,
+            imageInjector: recordImage,
             injector: func(m *MockItemRepository) {
 				// STEP 6-3: define mock expectation
 				// failed to insert
-				expectedImageFileName := "a1ebfdb99c936f09c57dee11ab7fb3a27dcf3b1dd5ef107bd650345c01ee8f11.jpg"
 				expectedItem := &Item{
 					Name:          "used iPhone 16e",
 					Category:      "phone",
 					ImageFileName: expectedImageFileName,  // 画像ファイル名を使用
+					Images:        []string{expectedImageFileName},
+					Status:        ItemStatusPublic,
+					SellerID:      testSeller.ID,
 				}
 			
 				m.EXPECT().
@@ -252,6 +354,31 @@ func TestAddItem(t *testing.T) {
                 code: http.StatusInternalServerError,
             },
         },
+        "ng: not an image": {
+            args: map[string]string{
+                "name":     "used iPhone 16e",
+                "category": "phone",
+            },
+            imageData: []byte("this is a text file"),
+            injector:  func(m *MockItemRepository) {},
+            wants: wants{
+                code: http.StatusUnsupportedMediaType,
+            },
+        },
+        "ng: failed to record image": {
+            args: map[string]string{
+                "name":     "used iPhone 16e",
+                "category": "phone",
+            },
+            imageData: imageBytes,
+            imageInjector: func(m *MockImageRepository) {
+                m.EXPECT().Insert(gomock.Any(), expectedImage).Return(errors.New("insert failed")).Times(1)
+            },
+            injector: func(m *MockItemRepository) {},
+            wants: wants{
+                code: http.StatusInternalServerError,
+            },
+        },
     }
 
     for name, tt := range cases {
@@ -273,7 +400,12 @@ func TestAddItem(t *testing.T) {
 
             mockIR := NewMockItemRepository(ctrl)
             tt.injector(mockIR)
-            h := &Handlers{imgDirPath: tmpDir,itemRepo: mockIR}
+            mockImR := NewMockImageRepository(ctrl)
+            expectUnreferencedImages(mockImR)
+            if tt.imageInjector != nil {
+                tt.imageInjector(mockImR)
+            }
+            h := &Handlers{images: NewFileImageStore(tmpDir),itemRepo: mockIR, imageRepo: mockImR}
 
 			reqbody := &bytes.Buffer{}
             writer := multipart.NewWriter(reqbody)
@@ -301,7 +433,7 @@ func TestAddItem(t *testing.T) {
 
             res := httptest.NewRecorder()
 
-			h.AddItem(res, req)
+			h.AddItem(res, asUser(req, testSeller))
 
 			if res.Code != tt.wants.code {
 				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
@@ -325,15 +457,17 @@ func TestAddItemE2e(t *testing.T) {
     })
 
     // 画像読み込み処理
-    imageBytes, err := os.ReadFile("/home/saway/mercari-build-training/go/images/default.jpg")
+    imageBytes, err := os.ReadFile(defaultImagePath)
     if err != nil {
         t.Fatalf("failed to read image file: %v", err)
     }
-
-    imgDirPath := "./images"
-    if err := createImageDir(); err != nil {
-        t.Fatalf("failed to create image directory: %v", err)
+    storedBytes, _, err := sanitizeImage(imageBytes, ImageFormatJPEG)
+    if err != nil {
+        t.Fatalf("failed to sanitize image: %v", err)
     }
+    expectedImageFileName := fmt.Sprintf("%x.jpg", sha256.Sum256(storedBytes))
+
+    imgDirPath := t.TempDir()
 
     // カテゴリIDを取得またはカテゴリを挿入
     var categoryId int64
@@ -390,7 +524,7 @@ func TestAddItemE2e(t *testing.T) {
 
     for name, tt := range cases {
         t.Run(name, func(t *testing.T) {
-            h := &Handlers{itemRepo: &itemRepository{db: db}, imgDirPath: imgDirPath}
+            h := &Handlers{itemRepo: &itemRepository{db: db}, imageRepo: &imageRepository{db: db}, images: NewFileImageStore(imgDirPath)}
 
             body := &bytes.Buffer{}
             writer := multipart.NewWriter(body)
@@ -437,7 +571,7 @@ func TestAddItemE2e(t *testing.T) {
             rr := httptest.NewRecorder()
 
             // ハンドラーにリクエストを渡す
-            h.AddItem(rr, req)
+            h.AddItem(rr, asUser(req, testSeller))
 
             // レスポンスコードの確認
             if tt.wants.code != rr.Code {
@@ -449,12 +583,15 @@ func TestAddItemE2e(t *testing.T) {
 
             // アイテムが挿入されたか確認
             var item Item
-            query := `SELECT items.id, items.name, items.category_id, items.image_name FROM items WHERE LOWER(items.name) LIKE LOWER(?)`
+            query := `SELECT items.id, items.name, items.category_id, items.image_name, items.seller_id FROM items WHERE LOWER(items.name) LIKE LOWER(?)`
             row := db.QueryRow(query, tt.args["name"])
-            err = row.Scan(&item.ID, &item.Name, &item.Category, &item.ImageFileName)
+            err = row.Scan(&item.ID, &item.Name, &item.Category, &item.ImageFileName, &item.SellerID)
             if err != nil {
                 t.Fatalf("failed to query inserted item: %v", err)
             }
+            if item.SellerID != testSeller.ID {
+                t.Errorf("expected the item to be listed by user %d, got %d", testSeller.ID, item.SellerID)
+            }
 
             // カテゴリ名の確認
             var categoryName string
@@ -473,8 +610,8 @@ func TestAddItemE2e(t *testing.T) {
                 t.Errorf("expected name %s, got %s", tt.args["name"], item.Name)
             }
 
-            if item.ImageFileName != "default.jpg" {
-                t.Errorf("expected image_name %s, got %s", "default.jpg", item.ImageFileName)
+            if item.ImageFileName != expectedImageFileName {
+                t.Errorf("expected image_name %s, got %s", expectedImageFileName, item.ImageFileName)
             }
         })
     }
@@ -503,7 +640,7 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
  	})
 
  	// set up tables
- 	db, err = sql.Open("sqlite3", f.Name())
+ 	db, err = sql.Open(sqliteDriverName, f.Name())
  	if err != nil {
  		return nil, nil, err
  	}
@@ -523,7 +660,30 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
     	name TEXT NOT NULL,
     	category_id INTEGER,
     	image_name TEXT,
+    	status TEXT NOT NULL DEFAULT 'public',
+    	seller_id INTEGER,
+    	hidden_at TEXT,
+    	hidden_reason TEXT,
     	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE SET NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS item_images (
+    	id INTEGER PRIMARY KEY AUTOINCREMENT,
+    	item_id INTEGER NOT NULL,
+    	image_name TEXT NOT NULL,
+    	position INTEGER NOT NULL,
+    	FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS images (
+    	name TEXT PRIMARY KEY,
+    	format TEXT NOT NULL,
+    	width INTEGER NOT NULL,
+    	height INTEGER NOT NULL,
+    	size INTEGER NOT NULL,
+    	phash TEXT,
+    	blurhash TEXT,
+    	dominant_color TEXT
 	);`
  	_, err = db.Exec(cmd)
  	if err != nil {
@@ -531,4 +691,267 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
  	}
 
  	return db, closers, nil
-}
\ No newline at end of file
+}
+func TestSearchItems(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		keyword  string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: items found": {
+			keyword: "jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					SearchItemsByName(gomock.Any(), "jacket").
+					Return([]*Item{{ID: 1, Name: "jacket", Category: "fashion"}}, nil).Times(1)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: query timed out": {
+			keyword: "jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					SearchItemsByName(gomock.Any(), "jacket").
+					Return(nil, fmt.Errorf("%w: %w", errQueryTimeout, context.DeadlineExceeded)).Times(1)
+			},
+			wants: wants{
+				code: http.StatusGatewayTimeout,
+			},
+		},
+		"ng: client went away": {
+			keyword: "jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					SearchItemsByName(gomock.Any(), "jacket").
+					Return(nil, context.Canceled).Times(1)
+			},
+			wants: wants{
+				code: http.StatusServiceUnavailable,
+			},
+		},
+		"ng: failed to search": {
+			keyword: "jacket",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					SearchItemsByName(gomock.Any(), "jacket").
+					Return(nil, errors.New("search failed")).Times(1)
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+		"ng: empty keyword": {
+			keyword:  "",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/search?keyword="+tt.keyword, nil)
+			res := httptest.NewRecorder()
+
+			h.SearchItems(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+		})
+	}
+}
+
+func TestItemRepositoryQueryTimeout(t *testing.T) {
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db, queryTimeout: time.Nanosecond}
+	_, err = repo.SearchItemsByName(context.Background(), "jacket")
+	if !errors.Is(err, errQueryTimeout) {
+		t.Errorf("expected errQueryTimeout, got %v", err)
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemID   string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: item found": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					Select(gomock.Any(), 1).
+					Return(&Item{ID: 1, Name: "jacket", Category: "fashion", ImageFileName: "default.jpg"}, nil).Times(1)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: item not found": {
+			itemID: "42",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					Select(gomock.Any(), 42).
+					Return(nil, errItemNotFound).Times(1)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: invalid item_id": {
+			itemID:   "abc",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/"+tt.itemID, nil)
+			req.SetPathValue("item_id", tt.itemID)
+			res := httptest.NewRecorder()
+
+			h.GetItem(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateItemStatus(t *testing.T) {
+	t.Parallel()
+
+	signer := testImageURLSigner(t, time.Now())
+	type wants struct {
+		code     int
+		imageURL string
+	}
+	cases := map[string]struct {
+		itemID   string
+		body     string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: reserved": {
+			itemID: "1",
+			body:   `{"status": "reserved"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					UpdateStatus(gomock.Any(), 1, ItemStatusReserved).
+					Return(&Item{ID: 1, ImageFileName: "a.jpg", Images: []string{"a.jpg"}, Status: ItemStatusReserved}, nil).Times(1)
+			},
+			wants: wants{code: http.StatusOK, imageURL: signer.Sign("a.jpg")},
+		},
+		"ok: public again": {
+			itemID: "1",
+			body:   `{"status": "public"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					UpdateStatus(gomock.Any(), 1, ItemStatusPublic).
+					Return(&Item{ID: 1, ImageFileName: "a.jpg", Images: []string{"a.jpg"}, Status: ItemStatusPublic}, nil).Times(1)
+			},
+			wants: wants{code: http.StatusOK, imageURL: "/images/a.jpg"},
+		},
+		"ng: item not found": {
+			itemID: "42",
+			body:   `{"status": "draft"}`,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().UpdateStatus(gomock.Any(), 42, ItemStatusDraft).Return(nil, errItemNotFound).Times(1)
+			},
+			wants: wants{code: http.StatusNotFound},
+		},
+		"ng: unknown status": {
+			itemID:   "1",
+			body:     `{"status": "sold"}`,
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: missing status": {
+			itemID:   "1",
+			body:     `{}`,
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIR := NewMockItemRepository(ctrl)
+			expectOwnedItems(mockIR)
+			tt.injector(mockIR)
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR, imageURLs: signer}
+
+			req := httptest.NewRequest("PUT", "/items/"+tt.itemID+"/status", strings.NewReader(tt.body))
+			req.SetPathValue("item_id", tt.itemID)
+			res := httptest.NewRecorder()
+
+			h.UpdateItemStatus(res, asUser(req, testSeller))
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body.String())
+			}
+			if tt.wants.imageURL == "" {
+				return
+			}
+			var got struct {
+				Item Item `json:"item"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Item.ImageURL != tt.wants.imageURL {
+				t.Errorf("expected image URL %s, got %s", tt.wants.imageURL, got.Item.ImageURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+//go:build cgo && !purego
+
+package app
+
+// This file selects mattn/go-sqlite3, which wraps the C SQLite library through CGO.
+// Build with `-tags purego` or CGO_ENABLED=0 to use the pure-Go driver instead.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriverName is the database/sql driver name registered by the SQLite driver in use.
+const sqliteDriverName = "sqlite3"
+
+// sqliteDSN returns the data source name for the SQLite database at path.
+// Connections use WAL journaling so readers don't block the writer, wait up to busyTimeout
+// for locks, enforce foreign keys, and start transactions with BEGIN IMMEDIATE so that
+// a read-then-write transaction never fails to upgrade its lock.
+func sqliteDSN(path string, busyTimeout time.Duration) string {
+	return appendDSNParams(path, []string{
+		fmt.Sprintf("_busy_timeout=%d", busyTimeout.Milliseconds()),
+		"_journal_mode=WAL",
+		"_foreign_keys=on",
+		"_txlock=immediate",
+	})
+}
+
+// sqliteBackup copies the live database behind src into a new database file at destPath
+// using the SQLite online backup API, so the copy is consistent even while other
+// connections keep writing.
+func sqliteBackup(ctx context.Context, src *sql.Conn, destPath string) error {
+	dest, err := sql.Open(sqliteDriverName, destPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup destination: %w", err)
+	}
+	defer dest.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open backup destination: %w", err)
+	}
+	defer destConn.Close()
+
+	return sqliteCopy(ctx, destConn, src)
+}
+
+// sqliteRestore replaces the database behind dst with the database file at srcPath
+// using the SQLite online backup API. The copy happens under a single write lock,
+// so other connections see either the old or the new database.
+func sqliteRestore(ctx context.Context, dst *sql.Conn, srcPath string) error {
+	src, err := sql.Open(sqliteDriverName, srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open restore source: %w", err)
+	}
+	defer src.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open restore source: %w", err)
+	}
+	defer srcConn.Close()
+
+	return sqliteCopy(ctx, dst, srcConn)
+}
+
+// sqliteCopy copies every page of the main database of src into dst.
+func sqliteCopy(ctx context.Context, dst, src *sql.Conn) error {
+	return dst.Raw(func(dc any) error {
+		return src.Raw(func(sc any) error {
+			destConn, ok := dc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", dc)
+			}
+			srcConn, ok := sc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", sc)
+			}
+
+			b, err := destConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+			for {
+				// Step returns false without an error while the database is busy or locked
+				done, err := b.Step(-1)
+				if err != nil {
+					b.Finish()
+					return fmt.Errorf("failed to copy database: %w", err)
+				}
+				if done {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					b.Finish()
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			if err := b.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,90 @@
+//go:build !cgo || purego
+
+package app
+
+// This file selects modernc.org/sqlite, a CGO-free translation of SQLite to Go.
+// It is used when building with `-tags purego` or CGO_ENABLED=0, which allows static binaries.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteDriverName is the database/sql driver name registered by the SQLite driver in use.
+const sqliteDriverName = "sqlite"
+
+// sqliteDSN returns the data source name for the SQLite database at path.
+// Connections use WAL journaling so readers don't block the writer, wait up to busyTimeout
+// for locks, enforce foreign keys, and start transactions with BEGIN IMMEDIATE so that
+// a read-then-write transaction never fails to upgrade its lock.
+func sqliteDSN(path string, busyTimeout time.Duration) string {
+	return appendDSNParams(path, []string{
+		fmt.Sprintf("_pragma=busy_timeout(%d)", busyTimeout.Milliseconds()),
+		"_pragma=journal_mode(WAL)",
+		"_pragma=foreign_keys(1)",
+		"_txlock=immediate",
+	})
+}
+
+// backuper is implemented by the driver connections of modernc.org/sqlite.
+type backuper interface {
+	NewBackup(dstUri string) (*sqlite.Backup, error)
+	NewRestore(srcUri string) (*sqlite.Backup, error)
+}
+
+// sqliteBackup copies the live database behind src into a new database file at destPath
+// using the SQLite online backup API, so the copy is consistent even while other
+// connections keep writing.
+func sqliteBackup(ctx context.Context, src *sql.Conn, destPath string) error {
+	return src.Raw(func(c any) error {
+		conn, ok := c.(backuper)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", c)
+		}
+		b, err := conn.NewBackup(destPath)
+		if err != nil {
+			return fmt.Errorf("failed to start backup: %w", err)
+		}
+		return sqliteStep(ctx, b)
+	})
+}
+
+// sqliteRestore replaces the database behind dst with the database file at srcPath
+// using the SQLite online backup API. The copy happens under a single write lock,
+// so other connections see either the old or the new database.
+func sqliteRestore(ctx context.Context, dst *sql.Conn, srcPath string) error {
+	return dst.Raw(func(c any) error {
+		conn, ok := c.(backuper)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", c)
+		}
+		b, err := conn.NewRestore(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to start restore: %w", err)
+		}
+		return sqliteStep(ctx, b)
+	})
+}
+
+// sqliteStep copies every remaining page of b and releases it.
+func sqliteStep(ctx context.Context, b *sqlite.Backup) error {
+	for more := true; more; {
+		if err := ctx.Err(); err != nil {
+			b.Finish()
+			return err
+		}
+		var err error
+		if more, err = b.Step(-1); err != nil {
+			b.Finish()
+			return fmt.Errorf("failed to copy database: %w", err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		return fmt.Errorf("failed to finish backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbnailWidths are the widths, in pixels, of the resized variants of every image.
+// GET /images/{filename}?w= accepts only these, so that the cache stays bounded.
+var thumbnailWidths = []int{150, 300, 600}
+
+// variantDirName is the subdirectory of the image directory holding the resized variants.
+// Variants are a cache: they are never backed up and can be regenerated from the originals.
+const variantDirName = "variants"
+
+// thumbnailJPEGQuality is the quality JPEG variants are encoded with.
+const thumbnailJPEGQuality = 85
+
+var errInvalidThumbnailWidth = fmt.Errorf("w must be one of %v", thumbnailWidths)
+
+// parseThumbnailWidth parses the w query parameter. Zero means the original image.
+func parseThumbnailWidth(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	width, err := strconv.Atoi(s)
+	if err != nil || !slices.Contains(thumbnailWidths, width) {
+		return 0, errInvalidThumbnailWidth
+	}
+	return width, nil
+}
+
+// variantFormat returns the format the variants of an image in format f are encoded in.
+// There is no WebP encoder and only the first frame of a GIF is kept,
+// so everything but JPEG becomes PNG, which preserves transparency.
+func variantFormat(f ImageFormat) ImageFormat {
+	if f == ImageFormatJPEG {
+		return ImageFormatJPEG
+	}
+	return ImageFormatPNG
+}
+
+// variantName returns the file name of the variant of image name resized to width,
+// such as <hash>_w300.png for <hash>.webp.
+func variantName(name string, width int) (string, error) {
+	ext := filepath.Ext(name)
+	format, ok := imageFormatFromExtension(ext)
+	if !ok {
+		return "", fmt.Errorf("unsupported image extension: %s", name)
+	}
+	return fmt.Sprintf("%s_w%d%s", strings.TrimSuffix(name, ext), width, variantFormat(format).Extension()), nil
+}
+
+// variantSource returns the file name stem of the image a variant was generated from,
+// or false if name is not a variant name.
+func variantSource(name string) (string, bool) {
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	i := strings.LastIndex(stem, "_w")
+	if i <= 0 {
+		return "", false
+	}
+	if _, err := strconv.Atoi(stem[i+2:]); err != nil {
+		return "", false
+	}
+	return stem[:i], true
+}
+
+// resizeImage scales img down to width, preserving its aspect ratio.
+func resizeImage(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := max(1, (b.Dy()*width+b.Dx()/2)/b.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encodeImage(w io.Writer, img image.Image, f ImageFormat) error {
+	switch f {
+	case ImageFormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	case ImageFormatPNG:
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("cannot encode %s images", f)
+	}
+}
+
+// generateVariants stores the variants of image name, whose content is data, for every
+// thumbnail width narrower than the image. Wider variants would only upscale the original,
+// which is served in their place.
+func generateVariants(ctx context.Context, images ImageStore, name string, data []byte) error {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image %s: %w", name, err)
+	}
+	for _, width := range thumbnailWidths {
+		if width >= img.Bounds().Dx() {
+			continue
+		}
+		if _, err := writeVariant(ctx, images, name, img, ImageFormat(format), width); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantKey returns the image store key of the variant of image name resized to width.
+func variantKey(name string, width int) (string, error) {
+	vName, err := variantName(name, width)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(variantDirName, vName), nil
+}
+
+// writeVariant resizes img to width, stores it under its variant key and returns its content.
+func writeVariant(ctx context.Context, images ImageStore, name string, img image.Image, format ImageFormat, width int) ([]byte, error) {
+	key, err := variantKey(name, width)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, resizeImage(img, width), variantFormat(format)); err != nil {
+		return nil, fmt.Errorf("failed to encode variant %s: %w", key, err)
+	}
+	if err := images.Put(ctx, key, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadImage returns the image name resized to width, or the original image if width is zero,
+// together with the name it is served under. Variants are generated when they are not cached
+// yet, as for images stored before variants existed, and the original is returned when it is
+// not wider than width or when its variant cannot be generated.
+func (s *Handlers) loadImage(ctx context.Context, name string, width int) (string, []byte, error) {
+	if width == 0 {
+		data, err := s.images.Get(ctx, name)
+		return name, data, err
+	}
+	key, err := variantKey(name, width)
+	if err != nil {
+		return "", nil, err
+	}
+	if data, err := s.images.Get(ctx, key); err == nil {
+		return key, data, nil
+	} else if !errors.Is(err, errImageNotFound) {
+		return "", nil, err
+	}
+
+	data, err := s.images.Get(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// the original is still better than nothing
+		slog.Warn("failed to decode image", "name", name, "error", err)
+		return name, data, nil
+	}
+	if width >= cfg.Width {
+		return name, data, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		slog.Warn("failed to decode image", "name", name, "error", err)
+		return name, data, nil
+	}
+	vData, err := writeVariant(ctx, s.images, name, img, ImageFormat(format), width)
+	if err != nil {
+		slog.Warn("failed to generate image variant", "name", name, "width", width, "error", err)
+		return name, data, nil
+	}
+	return key, vData, nil
+}
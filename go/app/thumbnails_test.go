@@ -0,0 +1,195 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseThumbnailWidth(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		w       string
+		want    int
+		wantErr bool
+	}{
+		"ok: original":        {w: "", want: 0},
+		"ok: supported width": {w: "300", want: 300},
+		"ng: other width":     {w: "299", wantErr: true},
+		"ng: not a number":    {w: "large", wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseThumbnailWidth(tt.w)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected width %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVariantName(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		name string
+		want string
+	}{
+		"jpeg stays jpeg":  {name: "abc.jpg", want: "abc_w150.jpg"},
+		"png stays png":    {name: "abc.png", want: "abc_w150.png"},
+		"webp becomes png": {name: "abc.webp", want: "abc_w150.png"},
+		"gif becomes png":  {name: "abc.gif", want: "abc_w150.png"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := variantName(tt.name, 150)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+			if source, ok := variantSource(got); !ok || source != "abc" {
+				t.Errorf("expected the source of %s to be abc, got %q", got, source)
+			}
+		})
+	}
+}
+
+func TestGenerateVariants(t *testing.T) {
+	t.Parallel()
+
+	imgDirPath := t.TempDir()
+	// wider than 300 px but narrower than 600 px
+	if err := generateVariants(context.Background(), NewFileImageStore(imgDirPath), "photo.png", testPNG(t, 400, 200)); err != nil {
+		t.Fatalf("failed to generate variants: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name          string
+		width, height int
+	}{
+		{"photo_w150.png", 150, 75},
+		{"photo_w300.png", 300, 150},
+	} {
+		cfg := decodeTestImageConfig(t, filepath.Join(imgDirPath, variantDirName, tt.name))
+		if cfg.Width != tt.width || cfg.Height != tt.height {
+			t.Errorf("expected %s to be %dx%d, got %dx%d", tt.name, tt.width, tt.height, cfg.Width, cfg.Height)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(imgDirPath, variantDirName, "photo_w600.png")); !os.IsNotExist(err) {
+		t.Errorf("expected no variant wider than the original")
+	}
+}
+
+func TestGetImageVariant(t *testing.T) {
+	t.Parallel()
+
+	imgDirPath := t.TempDir()
+	original := testJPEG(t, 800, 600)
+	if err := os.WriteFile(filepath.Join(imgDirPath, "photo.jpg"), original, 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	small := testPNG(t, 200, 100)
+	if err := os.WriteFile(filepath.Join(imgDirPath, "small.png"), small, 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	type wants struct {
+		code        int
+		contentType string
+		width       int
+		height      int
+	}
+	cases := map[string]struct {
+		target string
+		wants
+	}{
+		"ok: generated on demand": {
+			target: "/images/photo.jpg?w=300",
+			wants:  wants{code: http.StatusOK, contentType: "image/jpeg", width: 300, height: 225},
+		},
+		"ok: original": {
+			target: "/images/photo.jpg",
+			wants:  wants{code: http.StatusOK, contentType: "image/jpeg", width: 800, height: 600},
+		},
+		"ok: not upscaled": {
+			target: "/images/small.png?w=600",
+			wants:  wants{code: http.StatusOK, contentType: "image/png", width: 200, height: 100},
+		},
+		"ng: unsupported width": {
+			target: "/images/photo.jpg?w=1000",
+			wants:  wants{code: http.StatusBadRequest},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{images: NewFileImageStore(imgDirPath), imageRepo: publicImageRepository(t)}
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.SetPathValue("filename", filepath.Base(req.URL.Path))
+			res := httptest.NewRecorder()
+
+			h.GetImage(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Fatalf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+			if got := res.Header().Get("Content-Type"); got != tt.wants.contentType {
+				t.Errorf("expected content type %q, got %q", tt.wants.contentType, got)
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(res.Body.Bytes()))
+			if err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if cfg.Width != tt.wants.width || cfg.Height != tt.wants.height {
+				t.Errorf("expected %dx%d, got %dx%d", tt.wants.width, tt.wants.height, cfg.Width, cfg.Height)
+			}
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		h := &Handlers{images: NewFileImageStore(imgDirPath), imageRepo: publicImageRepository(t)}
+		req := httptest.NewRequest("GET", "/images/photo.jpg?w=150", nil)
+		req.SetPathValue("filename", "photo.jpg")
+		h.GetImage(httptest.NewRecorder(), req)
+
+		if _, err := os.Stat(filepath.Join(imgDirPath, variantDirName, "photo_w150.jpg")); err != nil {
+			t.Errorf("expected the variant to be cached: %v", err)
+		}
+	})
+}
+
+func decodeTestImageConfig(t *testing.T, p string) image.Config {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", p, err)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("failed to decode %s: %v", p, err)
+	}
+	return cfg
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	// DefaultMaxUploadBytes is the default limit on the body of an upload request.
+	DefaultMaxUploadBytes = 20 << 20
+	// DefaultMaxImagePixels is the default limit on the width × height of an uploaded image.
+	DefaultMaxImagePixels = 40_000_000
+
+	// uploadMemoryBytes is how much of a multipart form is kept in memory; the rest spills to disk.
+	uploadMemoryBytes = 10 << 20
+)
+
+// errImageTooLarge is returned when an image declares more pixels than the budget allows.
+// The check runs on the header alone, before the pixels are decoded.
+var errImageTooLarge = errors.New("image too large")
+
+// UploadLimits bounds what clients may upload. Zero fields fall back to the defaults.
+type UploadLimits struct {
+	// MaxBodyBytes is the largest request body accepted by the endpoints uploading images.
+	MaxBodyBytes int64
+	// MaxImagePixels is the largest width × height accepted for an image.
+	MaxImagePixels int64
+}
+
+// withDefaults returns l with its zero fields set to the defaults.
+func (l UploadLimits) withDefaults() UploadLimits {
+	if l.MaxBodyBytes <= 0 {
+		l.MaxBodyBytes = DefaultMaxUploadBytes
+	}
+	if l.MaxImagePixels <= 0 {
+		l.MaxImagePixels = DefaultMaxImagePixels
+	}
+	return l
+}
+
+// checkImageDimensions rejects images whose declared dimensions exceed the pixel budget.
+// Decompression bombs are small files declaring huge dimensions, so this must run before
+// anything decodes the pixels.
+func (l UploadLimits) checkImageDimensions(meta *ImageMetadata) error {
+	limit := l.withDefaults().MaxImagePixels
+	if pixels := int64(meta.Width) * int64(meta.Height); pixels > limit {
+		return fmt.Errorf("%w: %dx%d is more than %d pixels", errImageTooLarge, meta.Width, meta.Height, limit)
+	}
+	return nil
+}
+
+// limitUploadBody makes reading the body of r fail with *http.MaxBytesError past MaxBodyBytes.
+func (s *Handlers) limitUploadBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.uploads.withDefaults().MaxBodyBytes)
+}
+
+// writeUploadError reports an error returned while reading an upload:
+// 413 for a body over the limit and 400 otherwise.
+func writeUploadError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		slog.Warn("upload rejected", "error", err)
+		http.Error(w, fmt.Sprintf("request body is larger than %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+)
+
+// testPNGBomb returns a tiny PNG declaring huge dimensions: only the header is valid.
+func testPNGBomb(w, h int) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(w))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(h))
+	// bit depth 8, RGBA, default compression, filter and interlacing
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	data := append([]byte(pngSignature), pngChunk("IHDR", ihdr)...)
+	data = append(data, pngChunk("IDAT", []byte{0x78, 0x9c})...)
+	return append(data, pngChunk("IEND", nil)...)
+}
+
+func TestCheckImageDimensions(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		limits  UploadLimits
+		meta    ImageMetadata
+		wantErr error
+	}{
+		"ok: within the default budget": {
+			meta: ImageMetadata{Width: 6000, Height: 4000},
+		},
+		"ok: exactly the budget": {
+			limits: UploadLimits{MaxImagePixels: 100},
+			meta:   ImageMetadata{Width: 10, Height: 10},
+		},
+		"ng: over the default budget": {
+			meta:    ImageMetadata{Width: 100000, Height: 100000},
+			wantErr: errImageTooLarge,
+		},
+		"ng: over a custom budget": {
+			limits:  UploadLimits{MaxImagePixels: 100},
+			meta:    ImageMetadata{Width: 11, Height: 10},
+			wantErr: errImageTooLarge,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.limits.checkImageDimensions(&tt.meta)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		limits UploadLimits
+		image  []byte
+		// images adds the image to an existing item instead of creating one
+		images bool
+		// importing sends the image to POST /items/import instead
+		importing bool
+		wants
+	}{
+		"ng: body over the limit": {
+			limits: UploadLimits{MaxBodyBytes: 256},
+			image:  testPNG(t, 64, 64),
+			wants:  wants{code: http.StatusRequestEntityTooLarge},
+		},
+		"ng: body over the limit when adding images": {
+			limits: UploadLimits{MaxBodyBytes: 256},
+			image:  testPNG(t, 64, 64),
+			images: true,
+			wants:  wants{code: http.StatusRequestEntityTooLarge},
+		},
+		"ng: body over the limit when importing": {
+			limits:    UploadLimits{MaxBodyBytes: 256},
+			image:     testPNG(t, 64, 64),
+			importing: true,
+			wants:     wants{code: http.StatusRequestEntityTooLarge},
+		},
+		"ng: decompression bomb": {
+			image: testPNGBomb(100000, 100000),
+			wants: wants{code: http.StatusUnprocessableEntity},
+		},
+		"ng: over the pixel budget when adding images": {
+			limits: UploadLimits{MaxImagePixels: 100},
+			image:  testPNG(t, 20, 20),
+			images: true,
+			wants:  wants{code: http.StatusUnprocessableEntity},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// nothing reaches the repositories
+			imgDirPath := t.TempDir()
+			mockIR := NewMockItemRepository(ctrl)
+			expectOwnedItems(mockIR)
+			h := &Handlers{
+				images:    NewFileImageStore(imgDirPath),
+				itemRepo:  mockIR,
+				imageRepo: NewMockImageRepository(ctrl),
+				uploads:   tt.limits,
+			}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			writer.WriteField("name", "huge")
+			writer.WriteField("category", "phone")
+			part, err := writer.CreateFormFile("image", "photo.png")
+			if err != nil {
+				t.Fatalf("failed to create form file: %v", err)
+			}
+			part.Write(tt.image)
+			if err := writer.Close(); err != nil {
+				t.Fatalf("failed to close writer: %v", err)
+			}
+
+			target := "/items"
+			if tt.images {
+				target = "/items/1/images"
+			}
+			req := httptest.NewRequest("POST", target, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.SetPathValue("item_id", "1")
+			res := httptest.NewRecorder()
+
+			switch {
+			case tt.importing:
+				h.ImportItems(res, asUser(req, testSeller))
+			case tt.images:
+				h.AddItemImages(res, asUser(req, testSeller))
+			default:
+				h.AddItem(res, asUser(req, testSeller))
+			}
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, res.Code, res.Body)
+			}
+			if entries, _ := os.ReadDir(imgDirPath); len(entries) != 0 {
+				t.Errorf("expected nothing to be stored, got %d entries", len(entries))
+			}
+		})
+	}
+}
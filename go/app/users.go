@@ -0,0 +1,437 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	errUserNotFound    = errors.New("user not found")
+	errUserExists      = errors.New("a user with this email already exists")
+	errSessionNotFound = errors.New("session not found or expired")
+	// errRefreshTokenReused is returned for a refresh token that was already traded for a new
+	// one: either the client or an attacker holds a stolen copy, so the session is revoked.
+	errRefreshTokenReused = errors.New("refresh token reused: the session is revoked")
+)
+
+// Role is what a user may do beyond managing their own account and items.
+type Role string
+
+const (
+	RoleUser Role = "user"
+	// RoleModerator keeps the marketplace clean without administering it.
+	RoleModerator Role = "moderator"
+	// RoleAdmin has every permission, see rolePermissions.
+	RoleAdmin Role = "admin"
+)
+
+// User is an account that can log in with its email and password.
+type User struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// Role is stored as RoleUser when empty.
+	Role Role `json:"role"`
+	// PasswordHash is the Argon2id hash of the password (see hashPassword). It is never sent to clients.
+	PasswordHash string `json:"-"`
+	// FailedLogins counts the wrong passwords given since the last successful login or lockout.
+	FailedLogins int `json:"-"`
+	// LockedUntil is when the account accepts logins again after too many failures, zero when not locked.
+	LockedUntil time.Time `json:"-"`
+	// BannedAt is when a moderator banned the user, who may no longer log in; zero when not banned.
+	BannedAt  time.Time `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is a login of a user. It is identified by a short-lived access token, sent with
+// every request, and a long-lived refresh token, which trades itself for a new pair.
+type Session struct {
+	ID     int
+	UserID int
+	// TokenHash is the SHA-256 of the access token (see hashToken), which itself is never stored.
+	TokenHash string
+	CreatedAt time.Time
+	// ExpiresAt is when the access token expires.
+	ExpiresAt time.Time
+	// RefreshTokenHash is the SHA-256 of the refresh token. Sessions created before refresh
+	// tokens existed have none, and end with their access token.
+	RefreshTokenHash string
+	RefreshExpiresAt time.Time
+}
+
+// UserRepository is an interface to manage users and their sessions.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Select(ctx context.Context, id int) (*User, error)
+	SelectByEmail(ctx context.Context, email string) (*User, error)
+	RecordFailedLogin(ctx context.Context, id int) (int, error)
+	Lock(ctx context.Context, id int, until time.Time) error
+	ResetFailedLogins(ctx context.Context, id int) error
+	InsertSession(ctx context.Context, session *Session) error
+	SelectSession(ctx context.Context, tokenHash string, now time.Time) (*Session, error)
+	RotateSession(ctx context.Context, refreshTokenHash string, next *Session, now time.Time) error
+	DeleteSession(ctx context.Context, tokenHash string) error
+	DeleteUserSessions(ctx context.Context, userID int) error
+	SetBanned(ctx context.Context, id int, banned bool) error
+	SetRole(ctx context.Context, id int, role Role) error
+	CountByRole(ctx context.Context, role Role) (int, error)
+}
+
+// userRepository is an implementation of UserRepository
+type userRepository struct {
+	db *sql.DB
+	// dialect is the SQL flavour of db, used to rewrite placeholders.
+	dialect dialect
+	// queryTimeout bounds every query issued by the repository. Zero means no limit.
+	queryTimeout time.Duration
+}
+
+// dbTime formats t as stored in the database: RFC 3339 in UTC, which also sorts by time.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseDBTime parses a time stored by dbTime. NULL is the zero time.
+func parseDBTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", s.String, err)
+	}
+	return t, nil
+}
+
+// Insert adds user and sets its ID and creation time. It returns errUserExists when the email is taken.
+func (r *userRepository) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	user.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	query := `INSERT INTO users (email, name, role, password_hash, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (email) DO NOTHING RETURNING id`
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(query), user.Email, user.Name, string(user.Role), user.PasswordHash, dbTime(user.CreatedAt)).Scan(&user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errUserExists
+	}
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to insert user: %w", err))
+	}
+	return nil
+}
+
+// userColumns selects the columns scanned by scanUser.
+const userColumns = `SELECT id, email, name, role, password_hash, failed_logins, locked_until, banned_at, created_at FROM users`
+
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	var lockedUntil, bannedAt, createdAt sql.NullString
+	err := row.Scan(&user.ID, &user.Email, &user.Name, &user.Role, &user.PasswordHash, &user.FailedLogins, &lockedUntil, &bannedAt, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.LockedUntil, err = parseDBTime(lockedUntil); err != nil {
+		return nil, err
+	}
+	if user.BannedAt, err = parseDBTime(bannedAt); err != nil {
+		return nil, err
+	}
+	if user.CreatedAt, err = parseDBTime(createdAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Select returns the user with the given ID, or errUserNotFound.
+func (r *userRepository) Select(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, r.dialect.rebind(userColumns+` WHERE id = ?`), id))
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		return nil, queryError(ctx, err)
+	}
+	return user, err
+}
+
+// SelectByEmail returns the user with the given email, or errUserNotFound.
+func (r *userRepository) SelectByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, r.dialect.rebind(userColumns+` WHERE email = ?`), email))
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		return nil, queryError(ctx, err)
+	}
+	return user, err
+}
+
+// RecordFailedLogin counts a wrong password given for the user with the given ID and
+// returns the number of failures since the last successful login or lockout.
+func (r *userRepository) RecordFailedLogin(ctx context.Context, id int) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	// incrementing in the database keeps concurrent attempts from being lost
+	var failures int
+	query := `UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins`
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(query), id).Scan(&failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errUserNotFound
+	}
+	if err != nil {
+		return 0, queryError(ctx, fmt.Errorf("failed to record failed login: %w", err))
+	}
+	return failures, nil
+}
+
+// Lock refuses logins to the user with the given ID until the given time, and starts
+// counting failures anew.
+func (r *userRepository) Lock(ctx context.Context, id int, until time.Time) error {
+	return r.exec(ctx, "lock user", `UPDATE users SET failed_logins = 0, locked_until = ? WHERE id = ?`, dbTime(until), id)
+}
+
+// ResetFailedLogins forgets the failures of the user with the given ID after a successful login.
+func (r *userRepository) ResetFailedLogins(ctx context.Context, id int) error {
+	return r.exec(ctx, "reset failed logins", `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?`, id)
+}
+
+// SetBanned bans the user with the given ID, or lifts their ban. It returns errUserNotFound
+// if there is no such user. Banning keeps the time of the first ban.
+func (r *userRepository) SetBanned(ctx context.Context, id int, banned bool) error {
+	if !banned {
+		return r.update(ctx, "unban user", `UPDATE users SET banned_at = NULL WHERE id = ?`, id)
+	}
+	return r.update(ctx, "ban user", `UPDATE users SET banned_at = COALESCE(banned_at, ?) WHERE id = ?`, dbTime(time.Now()), id)
+}
+
+// SetRole changes the role of the user with the given ID, or returns errUserNotFound. It
+// returns errLastAdmin, changing nothing, when that would leave no admin.
+func (r *userRepository) SetRole(ctx context.Context, id int, role Role) (err error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	defer func() {
+		if err != nil && !errors.Is(err, errUserNotFound) && !errors.Is(err, errLastAdmin) {
+			err = queryError(ctx, err)
+		}
+	}()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	admins := int64(0)
+	if role != RoleAdmin {
+		// this no-op update counts the admins and locks their rows until the end of the
+		// transaction, so that of two concurrent demotions the second counts once the first is done
+		res, err := tx.ExecContext(ctx, r.dialect.rebind(`UPDATE users SET role = role WHERE role = ?`), string(RoleAdmin))
+		if err != nil {
+			return fmt.Errorf("failed to lock admins: %w", err)
+		}
+		if admins, err = res.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to lock admins: %w", err)
+		}
+	}
+	var current Role
+	err = tx.QueryRowContext(ctx, r.dialect.rebind(`SELECT role FROM users WHERE id = ?`), id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to select user: %w", err)
+	}
+	if current == RoleAdmin && role != RoleAdmin && admins <= 1 {
+		return errLastAdmin
+	}
+
+	if _, err := tx.ExecContext(ctx, r.dialect.rebind(`UPDATE users SET role = ? WHERE id = ?`), string(role), id); err != nil {
+		return fmt.Errorf("failed to set user role: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// CountByRole returns the number of users with the given role.
+func (r *userRepository) CountByRole(ctx context.Context, role Role) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	var n int
+	if err := r.db.QueryRowContext(ctx, r.dialect.rebind(`SELECT COUNT(*) FROM users WHERE role = ?`), string(role)).Scan(&n); err != nil {
+		return 0, queryError(ctx, fmt.Errorf("failed to count users: %w", err))
+	}
+	return n, nil
+}
+
+// update is exec for statements changing the user of the last argument, and returns
+// errUserNotFound when there is no such user.
+func (r *userRepository) update(ctx context.Context, op, query string, args ...any) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, r.dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to %s: %w", op, err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return queryError(ctx, fmt.Errorf("failed to %s: %w", op, err))
+	} else if n == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+// exec runs a statement that changes nothing but the columns of one row.
+func (r *userRepository) exec(ctx context.Context, op, query string, args ...any) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	if _, err := r.db.ExecContext(ctx, r.dialect.rebind(query), args...); err != nil {
+		return queryError(ctx, fmt.Errorf("failed to %s: %w", op, err))
+	}
+	return nil
+}
+
+// InsertSession adds session and sets its ID.
+func (r *userRepository) InsertSession(ctx context.Context, session *Session) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	query := `INSERT INTO sessions (user_id, token_hash, created_at, expires_at, refresh_token_hash, refresh_expires_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(query),
+		session.UserID, session.TokenHash, dbTime(session.CreatedAt), dbTime(session.ExpiresAt),
+		nullString(session.RefreshTokenHash), nullTime(session.RefreshExpiresAt)).Scan(&session.ID)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to insert session: %w", err))
+	}
+	return nil
+}
+
+// nullTime returns t as stored by dbTime, or NULL for the zero time.
+func nullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: dbTime(t), Valid: true}
+}
+
+// sessionColumns selects the columns scanned by scanSession.
+const sessionColumns = `SELECT id, user_id, token_hash, created_at, expires_at, refresh_token_hash, refresh_expires_at FROM sessions`
+
+func scanSession(row *sql.Row) (*Session, error) {
+	var session Session
+	var createdAt, expiresAt, refreshTokenHash, refreshExpiresAt sql.NullString
+	err := row.Scan(&session.ID, &session.UserID, &session.TokenHash, &createdAt, &expiresAt, &refreshTokenHash, &refreshExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	session.RefreshTokenHash = refreshTokenHash.String
+	if session.CreatedAt, err = parseDBTime(createdAt); err != nil {
+		return nil, err
+	}
+	if session.ExpiresAt, err = parseDBTime(expiresAt); err != nil {
+		return nil, err
+	}
+	if session.RefreshExpiresAt, err = parseDBTime(refreshExpiresAt); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SelectSession returns the session whose access token has the given hash, or
+// errSessionNotFound when there is none or the token expired before now.
+func (r *userRepository) SelectSession(ctx context.Context, tokenHash string, now time.Time) (*Session, error) {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, r.dialect.rebind(sessionColumns+` WHERE token_hash = ? AND expires_at > ?`), tokenHash, dbTime(now)))
+	if err != nil && !errors.Is(err, errSessionNotFound) {
+		return nil, queryError(ctx, err)
+	}
+	return session, err
+}
+
+// RotateSession trades the refresh token with the given hash for the tokens of next, whose
+// ID, user and creation time are set from the session. It returns errSessionNotFound when
+// the refresh token is unknown or expired before now, and errRefreshTokenReused, after
+// revoking the session, when it is the refresh token the session was last rotated from.
+func (r *userRepository) RotateSession(ctx context.Context, refreshTokenHash string, next *Session, now time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	// a single statement, so that of two concurrent refreshes with the same token only one
+	// wins, and the other looks like a replay
+	var createdAt sql.NullString
+	query := `UPDATE sessions SET token_hash = ?, expires_at = ?, refresh_token_hash = ?, refresh_expires_at = ?,
+			previous_refresh_hash = refresh_token_hash
+		WHERE refresh_token_hash = ? AND refresh_expires_at > ?
+		RETURNING id, user_id, created_at`
+	err := r.db.QueryRowContext(ctx, r.dialect.rebind(query),
+		next.TokenHash, dbTime(next.ExpiresAt), next.RefreshTokenHash, dbTime(next.RefreshExpiresAt),
+		refreshTokenHash, dbTime(now)).Scan(&next.ID, &next.UserID, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r.revokeReplayedSession(ctx, refreshTokenHash)
+	}
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to rotate session: %w", err))
+	}
+	next.CreatedAt, err = parseDBTime(createdAt)
+	return err
+}
+
+// revokeReplayedSession ends the session last rotated from the refresh token with the given
+// hash, if any, and returns the error RotateSession reports for the token.
+func (r *userRepository) revokeReplayedSession(ctx context.Context, refreshTokenHash string) error {
+	res, err := r.db.ExecContext(ctx, r.dialect.rebind(`DELETE FROM sessions WHERE previous_refresh_hash = ?`), refreshTokenHash)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to revoke session: %w", err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return queryError(ctx, fmt.Errorf("failed to revoke session: %w", err))
+	} else if n > 0 {
+		return errRefreshTokenReused
+	}
+	return errSessionNotFound
+}
+
+// DeleteSession ends the session whose access token has the given hash, or returns errSessionNotFound.
+func (r *userRepository) DeleteSession(ctx context.Context, tokenHash string) error {
+	ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, r.dialect.rebind(`DELETE FROM sessions WHERE token_hash = ?`), tokenHash)
+	if err != nil {
+		return queryError(ctx, fmt.Errorf("failed to delete session: %w", err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return queryError(ctx, fmt.Errorf("failed to delete session: %w", err))
+	} else if n == 0 {
+		return errSessionNotFound
+	}
+	return nil
+}
+
+// DeleteUserSessions ends every session of the user with the given ID.
+func (r *userRepository) DeleteUserSessions(ctx context.Context, userID int) error {
+	return r.exec(ctx, "delete sessions", `DELETE FROM sessions WHERE user_id = ?`, userID)
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUserRepository(t *testing.T) {
+	backends := map[string]func(t *testing.T) string{
+		"sqlite3":  sqliteTestDSN,
+		"postgres": postgresTestDSN,
+	}
+
+	for name, dsn := range backends {
+		t.Run(name, func(t *testing.T) {
+			if testing.Short() && name == "postgres" {
+				t.Skip("skipping postgres in short mode")
+			}
+			items := newTestItemRepository(t, dsn(t))
+			testUserRepository(t, &userRepository{db: items.db, dialect: items.dialect})
+		})
+	}
+}
+
+// testUserRepository runs the user repository test suite shared by every backend.
+func testUserRepository(t *testing.T, repo *userRepository) {
+	ctx := context.Background()
+
+	user := &User{Email: "alice@example.com", Name: "Alice", PasswordHash: "hash"}
+	if err := repo.Insert(ctx, user); err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+
+	t.Run("Insert", func(t *testing.T) {
+		if user.ID == 0 || user.CreatedAt.IsZero() {
+			t.Errorf("expected the ID and creation time to be set, got %+v", user)
+		}
+		err := repo.Insert(ctx, &User{Email: user.Email, Name: "Mallory", PasswordHash: "hash"})
+		if !errors.Is(err, errUserExists) {
+			t.Errorf("expected errUserExists for a taken email, got %v", err)
+		}
+	})
+
+	t.Run("Select", func(t *testing.T) {
+		got, err := repo.Select(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("failed to select user: %v", err)
+		}
+		if diff := cmp.Diff(user, got); diff != "" {
+			t.Errorf("unexpected user (-want +got):\n%s", diff)
+		}
+		got, err = repo.SelectByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatalf("failed to select user by email: %v", err)
+		}
+		if diff := cmp.Diff(user, got); diff != "" {
+			t.Errorf("unexpected user (-want +got):\n%s", diff)
+		}
+
+		if _, err := repo.Select(ctx, 9999); !errors.Is(err, errUserNotFound) {
+			t.Errorf("expected errUserNotFound, got %v", err)
+		}
+		if _, err := repo.SelectByEmail(ctx, "nobody@example.com"); !errors.Is(err, errUserNotFound) {
+			t.Errorf("expected errUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Lockout", func(t *testing.T) {
+		for want := 1; want <= 3; want++ {
+			got, err := repo.RecordFailedLogin(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("failed to record failed login: %v", err)
+			}
+			if got != want {
+				t.Errorf("expected %d failures, got %d", want, got)
+			}
+		}
+
+		until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		if err := repo.Lock(ctx, user.ID, until); err != nil {
+			t.Fatalf("failed to lock user: %v", err)
+		}
+		got, err := repo.Select(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("failed to select user: %v", err)
+		}
+		if got.FailedLogins != 0 || !got.LockedUntil.Equal(until) {
+			t.Errorf("expected a locked user with no failures, got %d failures, locked until %v", got.FailedLogins, got.LockedUntil)
+		}
+
+		if err := repo.ResetFailedLogins(ctx, user.ID); err != nil {
+			t.Fatalf("failed to reset failed logins: %v", err)
+		}
+		got, err = repo.Select(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("failed to select user: %v", err)
+		}
+		if got.FailedLogins != 0 || !got.LockedUntil.IsZero() {
+			t.Errorf("expected an unlocked user, got %d failures, locked until %v", got.FailedLogins, got.LockedUntil)
+		}
+
+		if _, err := repo.RecordFailedLogin(ctx, 9999); !errors.Is(err, errUserNotFound) {
+			t.Errorf("expected errUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		session := &Session{UserID: user.ID, TokenHash: hashToken("token"), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := repo.InsertSession(ctx, session); err != nil {
+			t.Fatalf("failed to insert session: %v", err)
+		}
+
+		got, err := repo.SelectSession(ctx, session.TokenHash, now)
+		if err != nil {
+			t.Fatalf("failed to select session: %v", err)
+		}
+		if diff := cmp.Diff(session, got); diff != "" {
+			t.Errorf("unexpected session (-want +got):\n%s", diff)
+		}
+		if _, err := repo.SelectSession(ctx, session.TokenHash, now.Add(time.Hour)); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected errSessionNotFound for an expired session, got %v", err)
+		}
+
+		if err := repo.DeleteSession(ctx, session.TokenHash); err != nil {
+			t.Fatalf("failed to delete session: %v", err)
+		}
+		if _, err := repo.SelectSession(ctx, session.TokenHash, now); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected errSessionNotFound after logout, got %v", err)
+		}
+		if err := repo.DeleteSession(ctx, session.TokenHash); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected errSessionNotFound for a second logout, got %v", err)
+		}
+	})
+
+	t.Run("RotateSession", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		session := &Session{UserID: user.ID, TokenHash: hashToken("access 1"), CreatedAt: now, ExpiresAt: now.Add(time.Minute),
+			RefreshTokenHash: hashToken("refresh 1"), RefreshExpiresAt: now.Add(time.Hour)}
+		if err := repo.InsertSession(ctx, session); err != nil {
+			t.Fatalf("failed to insert session: %v", err)
+		}
+
+		later := now.Add(30 * time.Minute)
+		next := &Session{TokenHash: hashToken("access 2"), ExpiresAt: later.Add(time.Minute),
+			RefreshTokenHash: hashToken("refresh 2"), RefreshExpiresAt: later.Add(time.Hour)}
+		if err := repo.RotateSession(ctx, hashToken("refresh 1"), next, later); err != nil {
+			t.Fatalf("failed to rotate session: %v", err)
+		}
+		if next.ID != session.ID || next.UserID != user.ID || !next.CreatedAt.Equal(now) {
+			t.Errorf("expected the rotated session to keep its ID, user and creation time, got %+v", next)
+		}
+		if _, err := repo.SelectSession(ctx, hashToken("access 1"), now); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected the old access token to be revoked, got %v", err)
+		}
+		got, err := repo.SelectSession(ctx, hashToken("access 2"), later)
+		if err != nil {
+			t.Fatalf("failed to select rotated session: %v", err)
+		}
+		if diff := cmp.Diff(next, got); diff != "" {
+			t.Errorf("unexpected session (-want +got):\n%s", diff)
+		}
+
+		// replaying the first refresh token revokes the whole session
+		replay := &Session{TokenHash: hashToken("access 3"), ExpiresAt: later.Add(time.Minute),
+			RefreshTokenHash: hashToken("refresh 3"), RefreshExpiresAt: later.Add(time.Hour)}
+		if err := repo.RotateSession(ctx, hashToken("refresh 1"), replay, later); !errors.Is(err, errRefreshTokenReused) {
+			t.Fatalf("expected errRefreshTokenReused, got %v", err)
+		}
+		if _, err := repo.SelectSession(ctx, hashToken("access 2"), later); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected the session to be revoked, got %v", err)
+		}
+		if err := repo.RotateSession(ctx, hashToken("refresh 2"), replay, later); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected errSessionNotFound for the refresh token of a revoked session, got %v", err)
+		}
+	})
+
+	t.Run("RotateSessionExpired", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		session := &Session{UserID: user.ID, TokenHash: hashToken("access 4"), CreatedAt: now, ExpiresAt: now.Add(time.Minute),
+			RefreshTokenHash: hashToken("refresh 4"), RefreshExpiresAt: now.Add(time.Hour)}
+		if err := repo.InsertSession(ctx, session); err != nil {
+			t.Fatalf("failed to insert session: %v", err)
+		}
+		next := &Session{TokenHash: hashToken("access 5"), RefreshTokenHash: hashToken("refresh 5")}
+		if err := repo.RotateSession(ctx, hashToken("refresh 4"), next, now.Add(time.Hour)); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("expected errSessionNotFound for an expired refresh token, got %v", err)
+		}
+	})
+
+	t.Run("DeleteUserSessions", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		for _, token := range []string{"phone", "laptop"} {
+			session := &Session{UserID: user.ID, TokenHash: hashToken(token), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			if err := repo.InsertSession(ctx, session); err != nil {
+				t.Fatalf("failed to insert session: %v", err)
+			}
+		}
+		if err := repo.DeleteUserSessions(ctx, user.ID); err != nil {
+			t.Fatalf("failed to delete sessions: %v", err)
+		}
+		for _, token := range []string{"phone", "laptop"} {
+			if _, err := repo.SelectSession(ctx, hashToken(token), now); !errors.Is(err, errSessionNotFound) {
+				t.Errorf("expected session %s to be revoked, got %v", token, err)
+			}
+		}
+	})
+
+	t.Run("Moderation", func(t *testing.T) {
+		if err := repo.SetBanned(ctx, user.ID, true); err != nil {
+			t.Fatalf("failed to ban user: %v", err)
+		}
+		got, err := repo.Select(ctx, user.ID)
+		if err != nil || got.BannedAt.IsZero() {
+			t.Fatalf("expected a banned user, got %+v: %v", got, err)
+		}
+		if err := repo.SetBanned(ctx, user.ID, false); err != nil {
+			t.Fatalf("failed to unban user: %v", err)
+		}
+		if got, err := repo.Select(ctx, user.ID); err != nil || !got.BannedAt.IsZero() {
+			t.Errorf("expected the ban to be lifted, got %+v: %v", got, err)
+		}
+
+		if n, err := repo.CountByRole(ctx, RoleModerator); err != nil || n != 0 {
+			t.Errorf("expected no moderator, got %d: %v", n, err)
+		}
+		if err := repo.SetRole(ctx, user.ID, RoleModerator); err != nil {
+			t.Fatalf("failed to set role: %v", err)
+		}
+		if n, err := repo.CountByRole(ctx, RoleModerator); err != nil || n != 1 {
+			t.Errorf("expected one moderator, got %d: %v", n, err)
+		}
+		if err := repo.SetRole(ctx, user.ID, RoleUser); err != nil {
+			t.Fatalf("failed to set role: %v", err)
+		}
+
+		admins := make([]*User, 2)
+		for i := range admins {
+			admins[i] = &User{Email: fmt.Sprintf("admin%d@example.com", i), Name: "Admin", Role: RoleAdmin, PasswordHash: "hash"}
+			if err := repo.Insert(ctx, admins[i]); err != nil {
+				t.Fatalf("failed to insert admin: %v", err)
+			}
+		}
+		// of two concurrent demotions of the last two admins, only one goes through
+		errs := make(chan error, len(admins))
+		for _, admin := range admins {
+			go func() { errs <- repo.SetRole(ctx, admin.ID, RoleUser) }()
+		}
+		var demoted int
+		for range admins {
+			switch err := <-errs; {
+			case err == nil:
+				demoted++
+			case !errors.Is(err, errLastAdmin):
+				t.Fatalf("failed to set role: %v", err)
+			}
+		}
+		if n, err := repo.CountByRole(ctx, RoleAdmin); err != nil || demoted != 1 || n != 1 {
+			t.Errorf("expected one admin left after one demotion, got %d after %d: %v", n, demoted, err)
+		}
+		for _, admin := range admins {
+			if err := repo.SetRole(ctx, admin.ID, RoleModerator); err != nil && !errors.Is(err, errLastAdmin) {
+				t.Fatalf("failed to set role: %v", err)
+			}
+		}
+		if n, err := repo.CountByRole(ctx, RoleAdmin); err != nil || n != 1 {
+			t.Errorf("expected the last admin to stay, got %d admins: %v", n, err)
+		}
+
+		if err := repo.SetBanned(ctx, 9999, true); !errors.Is(err, errUserNotFound) {
+			t.Errorf("expected errUserNotFound, got %v", err)
+		}
+		if err := repo.SetRole(ctx, 9999, RoleAdmin); !errors.Is(err, errUserNotFound) {
+			t.Errorf("expected errUserNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mercari-build-training/app"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// withAdmin opens the database for maintenance and calls fn with it.
+func withAdmin(fn func(a *app.Admin) error) error {
+	a, err := app.NewAdmin(databaseConfig(), imageDirPath)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if a.ImageStore, err = imageStore(); err != nil {
+		return err
+	}
+	return fn(a)
+}
+
+// noArgs rejects any argument, flags included.
+func noArgs(name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	return nil
+}
+
+// itemID parses the only argument as an item ID.
+func itemID(name string, args []string) (int, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return 0, errUsage
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return 0, errUsage
+	}
+	return id, nil
+}
+
+func migrateUp(ctx context.Context, args []string) error {
+	if err := noArgs("migrate up", args); err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		applied, err := a.MigrateUp(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return nil
+	})
+}
+
+func migrateDown(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 || *steps < 1 {
+		return errUsage
+	}
+	return withAdmin(func(a *app.Admin) error {
+		reverted, err := a.MigrateDown(ctx, *steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+		return nil
+	})
+}
+
+func migrateStatus(ctx context.Context, args []string) error {
+	if err := noArgs("migrate status", args); err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		statuses, err := a.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+		for _, s := range statuses {
+			status := "pending"
+			if s.Applied {
+				status = "applied at " + s.AppliedAt
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, status)
+		}
+		return tw.Flush()
+	})
+}
+
+func seed(ctx context.Context, args []string) error {
+	if err := noArgs("seed", args); err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		categories, items, err := a.Seed(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("inserted %d categories and %d items\n", categories, items)
+		return nil
+	})
+}
+
+func itemsList(ctx context.Context, args []string) error {
+	if err := noArgs("items list", args); err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		items, err := a.Items.LoadItems(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tCATEGORY\tIMAGE")
+		for _, item := range items {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", item.ID, item.Name, item.Category, item.ImageFileName)
+		}
+		return tw.Flush()
+	})
+}
+
+func itemsShow(ctx context.Context, args []string) error {
+	id, err := itemID("items show", args)
+	if err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		item, err := a.Items.Select(ctx, id)
+		if errors.Is(err, app.ErrNotFound) {
+			return fmt.Errorf("item %d not found", id)
+		}
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(item)
+	})
+}
+
+func itemsDelete(ctx context.Context, args []string) error {
+	id, err := itemID("items delete", args)
+	if err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		err := a.Items.Delete(ctx, id)
+		if errors.Is(err, app.ErrNotFound) {
+			return fmt.Errorf("item %d not found", id)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted item %d\n", id)
+		return nil
+	})
+}
+
+func itemsImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("items import", flag.ContinueOnError)
+	format := fs.String("format", "", "csv or jsonl (default: guessed from the file extension)")
+	imagesPath := fs.String("images", "", "zip archive holding the images referenced by path")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		f, err := app.ImportFormatFromName(path)
+		if err != nil {
+			return err
+		}
+		*format = f
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var images *zip.Reader
+	if *imagesPath != "" {
+		archive, err := zip.OpenReader(*imagesPath)
+		if err != nil {
+			return fmt.Errorf("failed to open image archive: %w", err)
+		}
+		defer archive.Close()
+		images = &archive.Reader
+	}
+
+	return withAdmin(func(a *app.Admin) error {
+		report, err := a.ImportItems(ctx, f, *format, images)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ROW\tNAME\tRESULT")
+		for _, r := range report.Results {
+			result := fmt.Sprintf("imported as item %d", r.ItemID)
+			if r.Error != "" {
+				result = "error: " + r.Error
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", r.Row, r.Name, result)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("imported %d items, %d failed\n", report.Imported, report.Failed)
+		if report.Failed > 0 {
+			return fmt.Errorf("%d rows failed to import", report.Failed)
+		}
+		return nil
+	})
+}
+
+func categoriesAdd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("categories add", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || fs.Arg(0) == "" {
+		return errUsage
+	}
+	return withAdmin(func(a *app.Admin) error {
+		c := &app.Category{Name: fs.Arg(0)}
+		err := a.Categories.Insert(ctx, c)
+		if errors.Is(err, app.ErrCategoryExists) {
+			return fmt.Errorf("category %q already exists", c.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("added category %d %s\n", c.ID, c.Name)
+		return nil
+	})
+}
+
+func categoriesList(ctx context.Context, args []string) error {
+	if err := noArgs("categories list", args); err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		categories, err := a.Categories.List(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME")
+		for _, c := range categories {
+			fmt.Fprintf(tw, "%d\t%s\n", c.ID, c.Name)
+		}
+		return tw.Flush()
+	})
+}
+
+func imagesGC(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("images gc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be deleted")
+	grace := fs.Duration("grace", imageGCGracePeriod, "keep unreferenced images modified more recently than this")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	return withAdmin(func(a *app.Admin) error {
+		report, err := a.CollectImageGarbage(ctx, app.ImageGCOptions{GracePeriod: *grace, DryRun: *dryRun})
+		verb := "removed"
+		if *dryRun {
+			verb = "would remove"
+		}
+		for _, f := range report.Removed {
+			fmt.Printf("%s %s (%d bytes, modified %s)\n", verb, f.Name, f.Size, f.ModTime.Format(time.RFC3339))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %d images, %d bytes; kept %d unreferenced images younger than %s\n",
+			verb, len(report.Removed), report.Bytes, report.Spared, *grace)
+		return nil
+	})
+}
+
+func imagesVerify(ctx context.Context, args []string) error {
+	if err := noArgs("images verify", args); err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		problems, err := a.VerifyImages(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", p.Name, p.Problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d images have problems", len(problems))
+		}
+		fmt.Println("all referenced images are present and intact")
+		return nil
+	})
+}
+
+func usersCreateAdmin(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("users create-admin", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return errUsage
+	}
+	password, err := adminPassword()
+	if err != nil {
+		return err
+	}
+	return withAdmin(func(a *app.Admin) error {
+		user, err := a.CreateAdmin(ctx, fs.Arg(0), fs.Arg(1), password)
+		if errors.Is(err, app.ErrAdminExists) {
+			return errors.New("an admin already exists: promote users with PUT /users/{user_id}/role instead")
+		}
+		if errors.Is(err, app.ErrUserExists) {
+			return fmt.Errorf("a user with email %s already exists", fs.Arg(0))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created admin %d %s\n", user.ID, user.Email)
+		return nil
+	})
+}
+
+// adminPassword reads the password of the admin to create from ADMIN_PASSWORD, or else from
+// the first line of the standard input, so that it does not show in the process list.
+func adminPassword() (string, error) {
+	if password, found := os.LookupEnv("ADMIN_PASSWORD"); found {
+		return password, nil
+	}
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || password == "") {
+		return "", fmt.Errorf("failed to read the password from ADMIN_PASSWORD or the standard input: %w", err)
+	}
+	return strings.TrimRight(password, "\r\n"), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"mercari-build-training/app"
+	"os"
+	"path/filepath"
+)
+
+// backup writes a backup archive to the path given as the only argument.
+// The archive is first written next to its destination and renamed into place,
+// so an interrupted backup never leaves a truncated archive behind.
+func backup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	archivePath := fs.Arg(0)
+	if err := requireImageDir(); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(archivePath), ".backup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	manifest, err := app.Backup(ctx, databaseConfig(), imageDirPath, tmp)
+	if err != nil {
+		return fmt.Errorf("failed to back up: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), archivePath); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	fmt.Printf("backed up %d files to %s\n", len(manifest.Files), archivePath)
+	return nil
+}
+
+// restore restores the database and images from the archive given as the only argument.
+func restore(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	archivePath := fs.Arg(0)
+	if err := requireImageDir(); err != nil {
+		return err
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	manifest, err := app.Restore(ctx, databaseConfig(), imageDirPath, f)
+	if err != nil {
+		return fmt.Errorf("failed to restore: %w", err)
+	}
+
+	fmt.Printf("restored %d files from %s (created at %s)\n", len(manifest.Files), archivePath, manifest.CreatedAt)
+	return nil
+}
+
+// requireImageDir fails when images are stored in S3, since backups only cover the image directory.
+func requireImageDir() error {
+	if _, found := os.LookupEnv("S3_BUCKET"); found {
+		return fmt.Errorf("backups do not cover images stored in S3_BUCKET: %w", app.ErrRemoteImageStore)
+	}
+	return nil
+}
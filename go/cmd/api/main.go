@@ -1,20 +1,209 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"mercari-build-training/app"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 )
 
 const (
 	port         = "9000"
 	imageDirPath = "images"
+	databaseDSN  = "db/mercari.sqlite3"
+	queryTimeout = 5 * time.Second
+
+	// database connection pool and SQLite lock settings
+	busyTimeout     = 5 * time.Second
+	maxOpenConns    = 10
+	maxIdleConns    = 10
+	connMaxLifetime = 30 * time.Minute
+	connMaxIdleTime = 5 * time.Minute
+
+	// upload limits: the body of an upload request and the pixels of each image
+	maxUploadBytes = 20 << 20
+	maxImagePixels = 40_000_000
+
+	// unreferenced images are removed in the background once older than the grace period,
+	// which leaves time to insert the item of an image just stored
+	imageGCInterval    = 6 * time.Hour
+	imageGCGracePeriod = time.Hour
+
+	// signed URLs of the images of items that are not public expire after this
+	imageURLTTL = 15 * time.Minute
 )
 
+// errUsage is returned by a command when it was invoked with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// command is a subcommand of the api binary.
+type command struct {
+	// name is the space-separated words selecting the command, e.g. "migrate up".
+	name string
+	// args is the synopsis of the arguments shown in the usage message.
+	args string
+	// help is a one-line description shown in the usage message.
+	help string
+	run  func(ctx context.Context, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{name: "serve", help: "start the server (default)", run: serve},
+		{name: "migrate up", help: "apply every pending migration", run: migrateUp},
+		{name: "migrate down", args: "[-steps n]", help: "revert the last n migrations (default 1)", run: migrateDown},
+		{name: "migrate status", help: "list migrations and whether they are applied", run: migrateStatus},
+		{name: "seed", help: "insert sample categories and items", run: seed},
+		{name: "items list", help: "list every item", run: itemsList},
+		{name: "items show", args: "<id>", help: "show an item", run: itemsShow},
+		{name: "items delete", args: "<id>", help: "delete an item", run: itemsDelete},
+		{name: "items import", args: "[-format csv|jsonl] [-images archive.zip] <file>", help: "import items from CSV or JSON Lines", run: itemsImport},
+		{name: "categories add", args: "<name>", help: "add a category", run: categoriesAdd},
+		{name: "categories list", help: "list every category", run: categoriesList},
+		{name: "users create-admin", args: "<email> <name>", help: "create the first admin, reading the password from ADMIN_PASSWORD or stdin", run: usersCreateAdmin},
+		{name: "images gc", args: "[-dry-run] [-grace duration]", help: "delete images no item references", run: imagesGC},
+		{name: "images verify", help: "check referenced images exist and match their hash", run: imagesVerify},
+		{name: "backup", args: "<archive>", help: "write a snapshot of the database and its images to archive", run: backup},
+		{name: "restore", args: "<archive>", help: "restore the database and its images from archive", run: restore},
+	}
+}
+
 func main() {
 	// This is the entry point of the application.
-	// You don't need to modify this function.
-	os.Exit(app.Server{
+	// Without arguments it starts the server; otherwise it runs the given command.
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	cmd, rest, ok := findCommand(args)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", strings.Join(args, " "))
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := cmd.run(ctx, rest); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: api %s %s\n", cmd.name, cmd.args)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// findCommand returns the command with the longest name matching the leading words of args,
+// along with the remaining arguments.
+func findCommand(args []string) (command, []string, bool) {
+	var found command
+	var rest []string
+	ok := false
+	for _, c := range commands() {
+		words := strings.Fields(c.name)
+		if len(words) > len(args) || (ok && len(words) <= len(strings.Fields(found.name))) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == c.name {
+			found, rest, ok = c, args[len(words):], true
+		}
+	}
+	return found, rest, ok
+}
+
+func printUsage(w *os.File) {
+	fmt.Fprintf(w, "usage: api [command]\n\ncommands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %s\n      %s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+}
+
+func newServer() (app.Server, error) {
+	images, err := imageStore()
+	if err != nil {
+		return app.Server{}, err
+	}
+	return app.Server{
 		Port:         port,
 		ImageDirPath: imageDirPath,
-	}.Run())
+		Images:       images,
+		Database:     databaseConfig(),
+		Uploads: app.UploadLimits{
+			MaxBodyBytes:   maxUploadBytes,
+			MaxImagePixels: maxImagePixels,
+		},
+		ImageGC: app.ImageGCConfig{
+			Interval:    imageGCInterval,
+			GracePeriod: imageGCGracePeriod,
+		},
+		// shared by every replica, so that any of them accepts the URLs another signed
+		ImageURLKey: []byte(os.Getenv("IMAGE_URL_KEY")),
+		ImageURLTTL: imageURLTTL,
+		// comma-separated, e.g. "images.example.com,cdn.example.com"
+		ImportImageHosts: strings.FieldsFunc(os.Getenv("IMPORT_IMAGE_HOSTS"), func(r rune) bool { return r == ',' || r == ' ' }),
+	}, nil
+}
+
+// imageStore returns where images are stored: the S3-compatible bucket named by S3_BUCKET
+// when it is set, so that several replicas can share the images, and the image directory otherwise.
+func imageStore() (app.ImageStore, error) {
+	bucket, found := os.LookupEnv("S3_BUCKET")
+	if !found {
+		return app.NewFileImageStore(imageDirPath), nil
+	}
+	return app.NewS3ImageStore(app.S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Region:          os.Getenv("S3_REGION"),
+		Bucket:          bucket,
+		Prefix:          os.Getenv("S3_PREFIX"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+	})
+}
+
+// databaseConfig returns the database settings, honoring DATABASE_URL.
+func databaseConfig() app.DatabaseConfig {
+	cfg := app.DatabaseConfig{
+		DSN:             databaseDSN,
+		QueryTimeout:    queryTimeout,
+		BusyTimeout:     busyTimeout,
+		MaxOpenConns:    maxOpenConns,
+		MaxIdleConns:    maxIdleConns,
+		ConnMaxLifetime: connMaxLifetime,
+		ConnMaxIdleTime: connMaxIdleTime,
+	}
+	if dsn, found := os.LookupEnv("DATABASE_URL"); found {
+		cfg.DSN = dsn
+	}
+	return cfg
+}
+
+func serve(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	server, err := newServer()
+	if err != nil {
+		return err
+	}
+	if code := server.Run(); code != 0 {
+		return fmt.Errorf("server exited with status %d", code)
+	}
+	return nil
 }
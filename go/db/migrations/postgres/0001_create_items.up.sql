@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS categories (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS items (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    category_id INTEGER,
+    image_name TEXT,
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE SET NULL
+);
@@ -0,0 +1,3 @@
+-- what clients show while an image loads: its BlurHash and most common color as #rrggbb
+ALTER TABLE images ADD COLUMN blurhash TEXT;
+ALTER TABLE images ADD COLUMN dominant_color TEXT;
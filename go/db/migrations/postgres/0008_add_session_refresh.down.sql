@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS sessions_previous_refresh_hash;
+DROP INDEX IF EXISTS sessions_refresh_token_hash;
+
+ALTER TABLE sessions DROP COLUMN previous_refresh_hash;
+ALTER TABLE sessions DROP COLUMN refresh_expires_at;
+ALTER TABLE sessions DROP COLUMN refresh_token_hash;
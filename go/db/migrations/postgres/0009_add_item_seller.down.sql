@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS items_seller_id;
+
+ALTER TABLE items DROP COLUMN seller_id;
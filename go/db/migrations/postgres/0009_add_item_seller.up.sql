@@ -0,0 +1,4 @@
+-- the user who listed an item; items listed before accounts existed have none
+ALTER TABLE items ADD COLUMN seller_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS items_seller_id ON items (seller_id);
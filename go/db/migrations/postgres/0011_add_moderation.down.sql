@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS audit_log;
+
+ALTER TABLE users DROP COLUMN banned_at;
+
+ALTER TABLE items DROP COLUMN hidden_reason;
+ALTER TABLE items DROP COLUMN hidden_at;
@@ -0,0 +1,16 @@
+-- keys of server-to-server integrations, which act as their user within their scopes;
+-- the prefix identifies a key in listings and logs, the key itself is only stored hashed
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL UNIQUE,
+    key_hash TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    expires_at TEXT,
+    last_used_at TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS images (
+    name TEXT PRIMARY KEY,
+    format TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size INTEGER NOT NULL
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS item_images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL,
+    image_name TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS item_images_item_id ON item_images (item_id, position);
+
+INSERT INTO item_images (item_id, image_name, position)
+SELECT id, image_name, 0 FROM items WHERE image_name IS NOT NULL AND image_name != '';
@@ -0,0 +1,2 @@
+-- the perceptual hash (dHash) of the image as 16 hex digits, NULL for images stored before it existed
+ALTER TABLE images ADD COLUMN phash TEXT;
@@ -0,0 +1,2 @@
+-- public listings are visible to anyone; the images of draft and reserved ones need signed URLs
+ALTER TABLE items ADD COLUMN status TEXT NOT NULL DEFAULT 'public';
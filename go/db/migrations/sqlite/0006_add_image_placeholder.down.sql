@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN dominant_color;
+ALTER TABLE images DROP COLUMN blurhash;
@@ -0,0 +1,8 @@
+-- token_hash and expires_at are now those of the short-lived access token; the refresh token
+-- trades itself for a new pair, and previous_refresh_hash catches a replay of the one it replaced
+ALTER TABLE sessions ADD COLUMN refresh_token_hash TEXT;
+ALTER TABLE sessions ADD COLUMN refresh_expires_at TEXT;
+ALTER TABLE sessions ADD COLUMN previous_refresh_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_refresh_token_hash ON sessions (refresh_token_hash);
+CREATE INDEX IF NOT EXISTS sessions_previous_refresh_hash ON sessions (previous_refresh_hash);
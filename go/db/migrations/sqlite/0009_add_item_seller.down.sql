@@ -0,0 +1,24 @@
+-- SQLite cannot drop a column with a foreign key, so items is rebuilt without it.
+-- Migrations run in a transaction, where foreign keys cannot be turned off: dropping items
+-- deletes the rows of item_images through ON DELETE CASCADE, so they are put back afterwards.
+CREATE TEMP TABLE item_images_backup AS SELECT * FROM item_images;
+
+CREATE TABLE items_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    category_id INTEGER,
+    image_name TEXT,
+    status TEXT NOT NULL DEFAULT 'public',
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE SET NULL
+);
+
+INSERT INTO items_new (id, name, category_id, image_name, status)
+SELECT id, name, category_id, image_name, status FROM items;
+
+DROP TABLE items;
+
+ALTER TABLE items_new RENAME TO items;
+
+INSERT INTO item_images SELECT * FROM item_images_backup;
+
+DROP TABLE item_images_backup;
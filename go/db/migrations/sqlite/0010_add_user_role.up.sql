@@ -0,0 +1,2 @@
+-- admins may change the items of every seller
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,18 @@
+-- a moderator hid the item when hidden_at is set; only its seller and moderators still see it
+ALTER TABLE items ADD COLUMN hidden_at TEXT;
+ALTER TABLE items ADD COLUMN hidden_reason TEXT;
+
+-- a banned user can no longer log in
+ALTER TABLE users ADD COLUMN banned_at TEXT;
+
+-- the operations of admins and moderators
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id INTEGER NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
@@ -3,11 +3,22 @@ import { Item, fetchItems } from '~/api';
 
 const PLACEHOLDER_IMAGE = import.meta.env.VITE_FRONTEND_URL + '/logo192.png';
 
-const getImageURL = (imageName: string) => {
+// THUMBNAIL_WIDTHS are the resized variants served by GET /images/{filename}?w=
+const THUMBNAIL_WIDTHS = [150, 300, 600];
+
+const getImageURL = (imageName: string, width?: number) => {
   if (!imageName) {
     return PLACEHOLDER_IMAGE;
   }
-  return import.meta.env.VITE_BACKEND_URL + '/images/' + imageName;
+  const url = import.meta.env.VITE_BACKEND_URL + '/images/' + imageName;
+  return width ? url + '?w=' + width : url;
+}
+
+const getImageSrcSet = (imageName: string) => {
+  if (!imageName) {
+    return undefined;
+  }
+  return THUMBNAIL_WIDTHS.map((w) => `${getImageURL(imageName, w)} ${w}w`).join(', ');
 }
 
 interface Prop {
@@ -41,7 +52,9 @@ export const ItemList = ({ reload, onLoadCompleted }: Prop) => {
         return (
           <div key={item.id} className="ItemListItem">
             <img
-              src={getImageURL(item.image_name)}
+              src={getImageURL(item.image_name, 300)}
+              srcSet={getImageSrcSet(item.image_name)}
+              sizes="300px"
               className="Image"
               alt={item.name}
             />